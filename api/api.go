@@ -2,7 +2,13 @@ package api
 
 import (
 	"context"
+
+	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/zgfzgf/mid-lotus/chain"
+	"github.com/zgfzgf/mid-lotus/chain/address"
+	"github.com/zgfzgf/mid-lotus/chain/vectors"
 )
 
 // Version provides various build-time information
@@ -10,8 +16,148 @@ type Version struct {
 	Version string
 }
 
+// ReplayResults is the outcome of re-executing a message
+type ReplayResults struct {
+	Msg     *chain.Message
+	Receipt *chain.MessageReceipt
+	Trace   *chain.ExecutionTrace
+}
+
+// MethodCall is the result of applying a message without committing it
+type MethodCall struct {
+	Receipt *chain.MessageReceipt
+	Trace   *chain.ExecutionTrace
+}
+
+// MinerStatus describes the state of the block miner
+type MinerStatus struct {
+	Running     bool
+	Address     address.Address
+	BlocksMined uint64
+
+	// OrphansAvoided is the number of rounds abandoned because a new head
+	// arrived while mining
+	OrphansAvoided uint64
+}
+
 // API is a low-level interface to the Filecoin network
 type API interface {
+	// chain
+
+	ChainHead(context.Context) (*chain.TipSet, error)
+
+	// ChainGetTipSet loads the tipset made of the given blocks
+	ChainGetTipSet(context.Context, []cid.Cid) (*chain.TipSet, error)
+
+	// state
+
+	// StateReplay re-executes a message included in the given tipset against
+	// the tipset's parent state, and returns its execution trace
+	StateReplay(context.Context, *chain.TipSet, cid.Cid) (*ReplayResults, error)
+
+	// StateCall applies a message on top of the state of a tipset (or the
+	// current head if nil) without committing the result
+	StateCall(context.Context, *chain.Message, *chain.TipSet, *chain.CallOptions) (*MethodCall, error)
+
+	// StateRecordVectors captures the state transitions of the blocks in a
+	// tipset as VM test vectors
+	StateRecordVectors(context.Context, *chain.TipSet) ([]*vectors.TestVector, error)
+
+	// gas
+
+	// GasEstimateGasLimit returns the gas used by a message plus a safety margin
+	GasEstimateGasLimit(context.Context, *chain.Message, *chain.TipSet) (chain.BigInt, error)
+
+	// mpool
+
+	// MpoolPending returns the messages in the pool which can be included in
+	// the next block
+	MpoolPending(context.Context) ([]*chain.SignedMessage, error)
+
+	// MpoolPush adds a signed message to the pool and publishes it
+	MpoolPush(context.Context, *chain.SignedMessage) error
+
+	// MpoolPushMessage signs a message with the key of its sender held in the
+	// local wallet, fills in the nonce, and pushes it to the pool
+	MpoolPushMessage(context.Context, *chain.Message) (*chain.SignedMessage, error)
+
+	// MpoolGetNonce returns the nonce the next message from an address should
+	// use, taking pending messages into account
+	MpoolGetNonce(context.Context, address.Address) (uint64, error)
+
+	// MpoolSub streams messages entering and leaving the pool until the
+	// context is cancelled
+	MpoolSub(context.Context) (<-chan chain.MpoolUpdate, error)
+
+	// MpoolSelect returns the pending messages which would be included in a
+	// block mined on top of the given tipset (or the current head if nil)
+	MpoolSelect(context.Context, *chain.TipSet) ([]*chain.SignedMessage, error)
+
+	// miner
+
+	// MinerStart starts mining blocks, with the given address or, if it's
+	// empty, the configured one
+	MinerStart(context.Context, address.Address) error
+
+	// MinerStop stops mining and waits for the current round to be abandoned
+	MinerStop(context.Context) error
+
+	// MinerStatus returns whether the miner is running and with which address
+	MinerStatus(context.Context) (MinerStatus, error)
+
+	// MinerCreateBlock builds a block on top of base for an external miner,
+	// with the tickets of any null rounds followed by the block's ticket.
+	// All referenced messages are stored by the node.
+	MinerCreateBlock(context.Context, *chain.TipSet, address.Address, []chain.Ticket, chain.ElectionProof) (*chain.BlockMsg, error)
+
+	// sync
+
+	// SyncSubmitBlock validates and stores a block built by an external
+	// miner, and gossips it to the network
+	SyncSubmitBlock(context.Context, *chain.BlockMsg) error
+
+	// wallet
+
+	// WalletNew generates a key of the given type, secp256k1 or bls
+	WalletNew(context.Context, string) (address.Address, error)
+
+	// WalletList returns the addresses of the keys in the wallet
+	WalletList(context.Context) ([]address.Address, error)
+
+	// WalletBalance returns the balance of an address in the head state
+	WalletBalance(context.Context, address.Address) (chain.BigInt, error)
+
+	// WalletDefaultAddress returns the address used when none is specified
+	WalletDefaultAddress(context.Context) (address.Address, error)
+
+	// WalletSetDefault changes the default address
+	WalletSetDefault(context.Context, address.Address) error
+
+	// WalletSign signs arbitrary data with the key of an address
+	WalletSign(context.Context, address.Address, []byte) (*chain.Signature, error)
+
+	// WalletVerify returns whether the signature over the data was made by
+	// the key of an address
+	WalletVerify(context.Context, address.Address, []byte, *chain.Signature) (bool, error)
+
+	// WalletExport returns the private key of an address held by the wallet
+	WalletExport(context.Context, address.Address) (*chain.KeyInfo, error)
+
+	// WalletImport adds a key to the wallet, it fails if the key is already
+	// there
+	WalletImport(context.Context, *chain.KeyInfo) (address.Address, error)
+
+	// WalletHDInit sets the seed of HD keys from a BIP39 mnemonic and
+	// passphrase, it can only be set once
+	WalletHDInit(ctx context.Context, mnemonic string, passphrase string) error
+
+	// WalletHDNew derives the next unused HD key of the given type
+	WalletHDNew(context.Context, string) (address.Address, error)
+
+	// WalletHDScan adds the HD keys of the given type whose addresses have
+	// actors in the head state, stopping after gap unused addresses in a row
+	WalletHDScan(ctx context.Context, typ string, gap int) ([]address.Address, error)
+
 	// network
 
 	NetPeers(context.Context) ([]peer.AddrInfo, error) // TODO: check serialization
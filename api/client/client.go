@@ -11,3 +11,10 @@ func NewRPC(addr string) api.API {
 	jsonrpc.NewClient(addr, "Filecoin", &res.Internal)
 	return &res
 }
+
+// NewSignerRPC creates a new http jsonrpc client for a remote signer.
+func NewSignerRPC(addr string) api.Signer {
+	var res api.SignerStruct
+	jsonrpc.NewClient(addr, "Signer", &res.Internal)
+	return &res
+}
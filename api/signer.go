@@ -0,0 +1,37 @@
+package api
+
+import (
+	"context"
+
+	"github.com/zgfzgf/mid-lotus/chain"
+	"github.com/zgfzgf/mid-lotus/chain/address"
+)
+
+// Signer is the API of a remote signer process, it is served under the
+// "Signer" namespace
+type Signer interface {
+	// List returns the addresses the signer will sign for
+	List(context.Context) ([]address.Address, error)
+
+	// Sign signs data with the key of an address, if the address is on the
+	// signer's allowlist
+	Sign(context.Context, address.Address, []byte) (*chain.Signature, error)
+}
+
+// SignerStruct implements Signer
+type SignerStruct struct {
+	Internal struct {
+		List func(context.Context) ([]address.Address, error)
+		Sign func(context.Context, address.Address, []byte) (*chain.Signature, error)
+	}
+}
+
+func (c *SignerStruct) List(ctx context.Context) ([]address.Address, error) {
+	return c.Internal.List(ctx)
+}
+
+func (c *SignerStruct) Sign(ctx context.Context, addr address.Address, data []byte) (*chain.Signature, error) {
+	return c.Internal.Sign(ctx, addr, data)
+}
+
+var _ chain.RemoteSigner = &SignerStruct{}
@@ -2,7 +2,13 @@ package api
 
 import (
 	"context"
+
+	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p-core/peer"
+
+	"github.com/zgfzgf/mid-lotus/chain"
+	"github.com/zgfzgf/mid-lotus/chain/address"
+	"github.com/zgfzgf/mid-lotus/chain/vectors"
 )
 
 // Struct implements API passing calls to user-provided function values.
@@ -11,12 +17,166 @@ type Struct struct {
 		ID      func(context.Context) (peer.ID, error)
 		Version func(context.Context) (Version, error)
 
+		ChainHead      func(context.Context) (*chain.TipSet, error)
+		ChainGetTipSet func(context.Context, []cid.Cid) (*chain.TipSet, error)
+
+		StateReplay func(context.Context, *chain.TipSet, cid.Cid) (*ReplayResults, error)
+		StateCall   func(context.Context, *chain.Message, *chain.TipSet, *chain.CallOptions) (*MethodCall, error)
+
+		StateRecordVectors func(context.Context, *chain.TipSet) ([]*vectors.TestVector, error)
+
+		GasEstimateGasLimit func(context.Context, *chain.Message, *chain.TipSet) (chain.BigInt, error)
+
+		MpoolPending     func(context.Context) ([]*chain.SignedMessage, error)
+		MpoolPush        func(context.Context, *chain.SignedMessage) error
+		MpoolPushMessage func(context.Context, *chain.Message) (*chain.SignedMessage, error)
+		MpoolGetNonce    func(context.Context, address.Address) (uint64, error)
+		MpoolSub         func(context.Context) (<-chan chain.MpoolUpdate, error)
+		MpoolSelect      func(context.Context, *chain.TipSet) ([]*chain.SignedMessage, error)
+
+		MinerStart  func(context.Context, address.Address) error
+		MinerStop   func(context.Context) error
+		MinerStatus func(context.Context) (MinerStatus, error)
+
+		MinerCreateBlock func(context.Context, *chain.TipSet, address.Address, []chain.Ticket, chain.ElectionProof) (*chain.BlockMsg, error)
+
+		SyncSubmitBlock func(context.Context, *chain.BlockMsg) error
+
+		WalletNew            func(context.Context, string) (address.Address, error)
+		WalletList           func(context.Context) ([]address.Address, error)
+		WalletBalance        func(context.Context, address.Address) (chain.BigInt, error)
+		WalletDefaultAddress func(context.Context) (address.Address, error)
+		WalletSetDefault     func(context.Context, address.Address) error
+		WalletSign           func(context.Context, address.Address, []byte) (*chain.Signature, error)
+		WalletVerify         func(context.Context, address.Address, []byte, *chain.Signature) (bool, error)
+		WalletExport         func(context.Context, address.Address) (*chain.KeyInfo, error)
+		WalletImport         func(context.Context, *chain.KeyInfo) (address.Address, error)
+		WalletHDInit         func(context.Context, string, string) error
+		WalletHDNew          func(context.Context, string) (address.Address, error)
+		WalletHDScan         func(context.Context, string, int) ([]address.Address, error)
+
 		NetPeers       func(context.Context) ([]peer.AddrInfo, error)
 		NetConnect     func(context.Context, peer.AddrInfo) error
 		NetAddrsListen func(context.Context) (peer.AddrInfo, error)
 	}
 }
 
+func (c *Struct) ChainHead(ctx context.Context) (*chain.TipSet, error) {
+	return c.Internal.ChainHead(ctx)
+}
+
+func (c *Struct) ChainGetTipSet(ctx context.Context, cids []cid.Cid) (*chain.TipSet, error) {
+	return c.Internal.ChainGetTipSet(ctx, cids)
+}
+
+func (c *Struct) StateReplay(ctx context.Context, ts *chain.TipSet, mc cid.Cid) (*ReplayResults, error) {
+	return c.Internal.StateReplay(ctx, ts, mc)
+}
+
+func (c *Struct) StateCall(ctx context.Context, msg *chain.Message, ts *chain.TipSet, opts *chain.CallOptions) (*MethodCall, error) {
+	return c.Internal.StateCall(ctx, msg, ts, opts)
+}
+
+func (c *Struct) StateRecordVectors(ctx context.Context, ts *chain.TipSet) ([]*vectors.TestVector, error) {
+	return c.Internal.StateRecordVectors(ctx, ts)
+}
+
+func (c *Struct) GasEstimateGasLimit(ctx context.Context, msg *chain.Message, ts *chain.TipSet) (chain.BigInt, error) {
+	return c.Internal.GasEstimateGasLimit(ctx, msg, ts)
+}
+
+func (c *Struct) MpoolPending(ctx context.Context) ([]*chain.SignedMessage, error) {
+	return c.Internal.MpoolPending(ctx)
+}
+
+func (c *Struct) MpoolPush(ctx context.Context, smsg *chain.SignedMessage) error {
+	return c.Internal.MpoolPush(ctx, smsg)
+}
+
+func (c *Struct) MpoolPushMessage(ctx context.Context, msg *chain.Message) (*chain.SignedMessage, error) {
+	return c.Internal.MpoolPushMessage(ctx, msg)
+}
+
+func (c *Struct) MpoolGetNonce(ctx context.Context, addr address.Address) (uint64, error) {
+	return c.Internal.MpoolGetNonce(ctx, addr)
+}
+
+func (c *Struct) MpoolSub(ctx context.Context) (<-chan chain.MpoolUpdate, error) {
+	return c.Internal.MpoolSub(ctx)
+}
+
+func (c *Struct) MpoolSelect(ctx context.Context, ts *chain.TipSet) ([]*chain.SignedMessage, error) {
+	return c.Internal.MpoolSelect(ctx, ts)
+}
+
+func (c *Struct) MinerStart(ctx context.Context, addr address.Address) error {
+	return c.Internal.MinerStart(ctx, addr)
+}
+
+func (c *Struct) MinerStop(ctx context.Context) error {
+	return c.Internal.MinerStop(ctx)
+}
+
+func (c *Struct) MinerStatus(ctx context.Context) (MinerStatus, error) {
+	return c.Internal.MinerStatus(ctx)
+}
+
+func (c *Struct) MinerCreateBlock(ctx context.Context, base *chain.TipSet, miner address.Address, tickets []chain.Ticket, proof chain.ElectionProof) (*chain.BlockMsg, error) {
+	return c.Internal.MinerCreateBlock(ctx, base, miner, tickets, proof)
+}
+
+func (c *Struct) SyncSubmitBlock(ctx context.Context, blk *chain.BlockMsg) error {
+	return c.Internal.SyncSubmitBlock(ctx, blk)
+}
+
+func (c *Struct) WalletNew(ctx context.Context, typ string) (address.Address, error) {
+	return c.Internal.WalletNew(ctx, typ)
+}
+
+func (c *Struct) WalletList(ctx context.Context) ([]address.Address, error) {
+	return c.Internal.WalletList(ctx)
+}
+
+func (c *Struct) WalletBalance(ctx context.Context, addr address.Address) (chain.BigInt, error) {
+	return c.Internal.WalletBalance(ctx, addr)
+}
+
+func (c *Struct) WalletDefaultAddress(ctx context.Context) (address.Address, error) {
+	return c.Internal.WalletDefaultAddress(ctx)
+}
+
+func (c *Struct) WalletSetDefault(ctx context.Context, addr address.Address) error {
+	return c.Internal.WalletSetDefault(ctx, addr)
+}
+
+func (c *Struct) WalletSign(ctx context.Context, addr address.Address, data []byte) (*chain.Signature, error) {
+	return c.Internal.WalletSign(ctx, addr, data)
+}
+
+func (c *Struct) WalletVerify(ctx context.Context, addr address.Address, data []byte, sig *chain.Signature) (bool, error) {
+	return c.Internal.WalletVerify(ctx, addr, data, sig)
+}
+
+func (c *Struct) WalletExport(ctx context.Context, addr address.Address) (*chain.KeyInfo, error) {
+	return c.Internal.WalletExport(ctx, addr)
+}
+
+func (c *Struct) WalletImport(ctx context.Context, ki *chain.KeyInfo) (address.Address, error) {
+	return c.Internal.WalletImport(ctx, ki)
+}
+
+func (c *Struct) WalletHDInit(ctx context.Context, mnemonic string, passphrase string) error {
+	return c.Internal.WalletHDInit(ctx, mnemonic, passphrase)
+}
+
+func (c *Struct) WalletHDNew(ctx context.Context, typ string) (address.Address, error) {
+	return c.Internal.WalletHDNew(ctx, typ)
+}
+
+func (c *Struct) WalletHDScan(ctx context.Context, typ string, gap int) ([]address.Address, error) {
+	return c.Internal.WalletHDScan(ctx, typ, gap)
+}
+
 func (c *Struct) NetPeers(ctx context.Context) ([]peer.AddrInfo, error) {
 	return c.Internal.NetPeers(ctx)
 }
@@ -0,0 +1,16 @@
+package build
+
+// BlockDelay is the length of an epoch, in seconds
+const BlockDelay = 2
+
+// AllowableClockDrift is how far in the future, in seconds, a block
+// timestamp may be before the block is rejected
+const AllowableClockDrift = 1
+
+// MaxBlockLag is how many epochs behind the current epoch a block received
+// from the network may be. Older blocks are only accepted when syncing.
+const MaxBlockLag = 3
+
+// FilecoinPrecision is the number of attoFIL, the unit of balances on chain,
+// in one FIL
+const FilecoinPrecision = 1000000000000000000
@@ -15,6 +15,8 @@ import (
 func init() {
 	cbor.RegisterCborType(InitActorState{})
 	cbor.RegisterCborType(AccountActorState{})
+	cbor.RegisterCborType(StorageMarketState{})
+	cbor.RegisterCborType(MinerInfo{})
 }
 
 var AccountActorCodeCid cid.Cid
@@ -22,10 +24,12 @@ var StorageMarketActorCodeCid cid.Cid
 var StorageMinerCodeCid cid.Cid
 var MultisigActorCodeCid cid.Cid
 var InitActorCodeCid cid.Cid
+var CronActorCodeCid cid.Cid
 
 var InitActorAddress = mustIDAddress(0)
 var NetworkAddress = mustIDAddress(1)
 var StorageMarketAddress = mustIDAddress(2)
+var CronActorAddress = mustIDAddress(3)
 
 func mustIDAddress(i uint64) address.Address {
 	a, err := address.NewIDAddress(i)
@@ -50,6 +54,7 @@ func init() {
 	StorageMinerCodeCid = mustSum("sminer")
 	MultisigActorCodeCid = mustSum("multisig")
 	InitActorCodeCid = mustSum("init")
+	CronActorCodeCid = mustSum("cron")
 }
 
 type VMActor struct {
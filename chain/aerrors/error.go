@@ -0,0 +1,143 @@
+package aerrors
+
+import (
+	"fmt"
+)
+
+// ActorError is an error raised while applying a message.
+//
+// Non-fatal errors are the message's fault: the VM reverts its state changes
+// and records the exit code in a failed receipt. Fatal errors mean the node
+// itself couldn't apply the message (e.g. broken local storage), and abort
+// processing of the whole block.
+type ActorError interface {
+	error
+	IsFatal() bool
+	RetCode() ExitCode
+}
+
+type actorError struct {
+	fatal   bool
+	retCode ExitCode
+	msg     string
+	err     error
+}
+
+func (e *actorError) IsFatal() bool {
+	return e.fatal
+}
+
+func (e *actorError) RetCode() ExitCode {
+	return e.retCode
+}
+
+func (e *actorError) Error() string {
+	var out string
+	if e.fatal {
+		out = "fatal error: " + e.msg
+	} else {
+		out = fmt.Sprintf("%s (%s)", e.msg, e.retCode)
+	}
+
+	if e.err != nil {
+		out += ": " + e.err.Error()
+	}
+	return out
+}
+
+func (e *actorError) Unwrap() error {
+	return e.err
+}
+
+// New creates a non-fatal error with the given exit code
+func New(retCode ExitCode, msg string) ActorError {
+	if retCode == Ok {
+		return &actorError{
+			fatal: true,
+			msg:   "tried creating an error with exit code Ok: " + msg,
+		}
+	}
+
+	return &actorError{
+		retCode: retCode,
+		msg:     msg,
+	}
+}
+
+// Newf creates a non-fatal error with the given exit code and a formatted message
+func Newf(retCode ExitCode, format string, args ...interface{}) ActorError {
+	return New(retCode, fmt.Sprintf(format, args...))
+}
+
+// Absorb wraps err in a non-fatal error with the given exit code
+func Absorb(err error, retCode ExitCode, msg string) ActorError {
+	if err == nil {
+		return nil
+	}
+
+	if aerr, ok := err.(ActorError); ok && aerr.IsFatal() {
+		return aerr
+	}
+
+	ae := New(retCode, msg).(*actorError)
+	ae.err = err
+	return ae
+}
+
+// Fatal creates a fatal error
+func Fatal(msg string) ActorError {
+	return &actorError{
+		fatal: true,
+		msg:   msg,
+	}
+}
+
+// Escalate wraps err in a fatal error. It returns nil if err is nil
+func Escalate(err error, msg string) ActorError {
+	if err == nil {
+		return nil
+	}
+
+	return &actorError{
+		fatal: true,
+		msg:   msg,
+		err:   err,
+	}
+}
+
+// Wrap adds context to an existing ActorError, keeping its exit code and fatality
+func Wrap(err ActorError, msg string) ActorError {
+	if err == nil {
+		return nil
+	}
+
+	return &actorError{
+		fatal:   err.IsFatal(),
+		retCode: err.RetCode(),
+		msg:     msg,
+		err:     err,
+	}
+}
+
+// IsFatal returns true for errors which should abort processing of the block.
+// Plain errors that aren't ActorErrors are treated as fatal.
+func IsFatal(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	aerr, ok := err.(ActorError)
+	return !ok || aerr.IsFatal()
+}
+
+// RetCode returns the exit code for err, or Ok if err is nil
+func RetCode(err error) ExitCode {
+	if err == nil {
+		return Ok
+	}
+
+	if aerr, ok := err.(ActorError); ok {
+		return aerr.RetCode()
+	}
+	return SysErrIllegalActor
+}
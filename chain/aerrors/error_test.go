@@ -0,0 +1,50 @@
+package aerrors
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExitCodeJSON(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, c := range []ExitCode{Ok, SysErrInsufficientFunds, ErrIllegalState, ExitCode(200)} {
+		b, err := json.Marshal(c)
+		assert.NoError(err)
+
+		var out ExitCode
+		assert.NoError(json.Unmarshal(b, &out))
+		assert.Equal(c, out)
+	}
+
+	b, err := json.Marshal(SysErrOutOfGas)
+	assert.NoError(err)
+	assert.Equal(`"SysErrOutOfGas"`, string(b))
+
+	var out ExitCode
+	assert.NoError(json.Unmarshal([]byte("4"), &out))
+	assert.Equal(SysErrInsufficientFunds, out)
+}
+
+func TestFatality(t *testing.T) {
+	assert := assert.New(t)
+
+	aerr := New(ErrNotFound, "missing")
+	assert.False(aerr.IsFatal())
+	assert.False(IsFatal(aerr))
+	assert.Equal(ErrNotFound, RetCode(aerr))
+
+	assert.True(IsFatal(fmt.Errorf("plain error")))
+	assert.True(Escalate(fmt.Errorf("disk gone"), "loading state").IsFatal())
+	assert.Nil(Escalate(nil, "loading state"))
+
+	// absorbing a fatal error must not hide it
+	fatal := Fatal("broken")
+	assert.True(Absorb(fatal, ErrIllegalState, "wrapped").IsFatal())
+
+	assert.True(New(Ok, "not an error").IsFatal())
+	assert.Equal(Ok, RetCode(nil))
+}
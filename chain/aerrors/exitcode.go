@@ -0,0 +1,106 @@
+package aerrors
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// ExitCode is the result code of applying a message, stored in its receipt
+type ExitCode uint8
+
+const (
+	// Ok means the message was applied successfully.
+	Ok ExitCode = iota
+
+	// SysErrInvalidMethod means the receiver has no method with the given number.
+	SysErrInvalidMethod
+	// SysErrInvalidParameters means the method parameters could not be decoded.
+	SysErrInvalidParameters
+	// SysErrInvalidReceiver means the receiver does not exist and could not be created.
+	SysErrInvalidReceiver
+	// SysErrInsufficientFunds means the sender can't cover value plus the gas limit.
+	SysErrInsufficientFunds
+	// SysErrOutOfGas means execution ran out of gas.
+	SysErrOutOfGas
+	// SysErrForbidden means the caller is not allowed to invoke the method.
+	SysErrForbidden
+	// SysErrIllegalActor means the actor code is unknown or invalid.
+	SysErrIllegalActor
+)
+
+// FirstActorErrorCode is the lowest exit code actors may use for their own failures
+const FirstActorErrorCode = ExitCode(16)
+
+const (
+	// ErrIllegalArgument means the actor rejected its parameters.
+	ErrIllegalArgument ExitCode = FirstActorErrorCode + iota
+	// ErrNotFound means a value the actor looked up does not exist.
+	ErrNotFound
+	// ErrForbidden means the actor refused the caller.
+	ErrForbidden
+	// ErrInsufficientFunds means the actor does not have enough funds.
+	ErrInsufficientFunds
+	// ErrIllegalState means the actor state is inconsistent.
+	ErrIllegalState
+)
+
+var names = map[ExitCode]string{
+	Ok:                      "Ok",
+	SysErrInvalidMethod:     "SysErrInvalidMethod",
+	SysErrInvalidParameters: "SysErrInvalidParameters",
+	SysErrInvalidReceiver:   "SysErrInvalidReceiver",
+	SysErrInsufficientFunds: "SysErrInsufficientFunds",
+	SysErrOutOfGas:          "SysErrOutOfGas",
+	SysErrForbidden:         "SysErrForbidden",
+	SysErrIllegalActor:      "SysErrIllegalActor",
+	ErrIllegalArgument:      "ErrIllegalArgument",
+	ErrNotFound:             "ErrNotFound",
+	ErrForbidden:            "ErrForbidden",
+	ErrInsufficientFunds:    "ErrInsufficientFunds",
+	ErrIllegalState:         "ErrIllegalState",
+}
+
+// IsSystemError returns true for codes set by the VM rather than by an actor
+func (c ExitCode) IsSystemError() bool {
+	return c != Ok && c < FirstActorErrorCode
+}
+
+func (c ExitCode) String() string {
+	if n, ok := names[c]; ok {
+		return n
+	}
+	return fmt.Sprintf("ExitCode(%d)", uint8(c))
+}
+
+// MarshalJSON encodes the code by name so API output is readable
+func (c ExitCode) MarshalJSON() ([]byte, error) {
+	return json.Marshal(c.String())
+}
+
+// UnmarshalJSON accepts both names and bare numbers
+func (c *ExitCode) UnmarshalJSON(b []byte) error {
+	var n uint8
+	if err := json.Unmarshal(b, &n); err == nil {
+		*c = ExitCode(n)
+		return nil
+	}
+
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	for code, name := range names {
+		if name == s {
+			*c = code
+			return nil
+		}
+	}
+
+	var parsed uint8
+	if _, err := fmt.Sscanf(s, "ExitCode(%d)", &parsed); err != nil {
+		return fmt.Errorf("unknown exit code: %q", s)
+	}
+	*c = ExitCode(parsed)
+	return nil
+}
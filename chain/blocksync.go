@@ -13,6 +13,7 @@ import (
 
 	"github.com/zgfzgf/mid-lotus/lib/cborrpc"
 
+	blocks "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	cbor "github.com/ipfs/go-ipld-cbor"
 	inet "github.com/libp2p/go-libp2p-core/network"
@@ -67,8 +68,11 @@ type BlockSyncResponse struct {
 type BSTipSet struct {
 	Blocks []*BlockHeader
 
-	Messages    []*SignedMessage
-	MsgIncludes [][]int
+	BlsMessages    []*Message
+	BlsMsgIncludes [][]int
+
+	SecpkMessages    []*SignedMessage
+	SecpkMsgIncludes [][]int
 }
 
 func NewBlockSyncService(cs *ChainStore) *BlockSyncService {
@@ -128,14 +132,15 @@ func (bss *BlockSyncService) collectChainSegment(start []cid.Cid, length uint64,
 
 		if opts.IncludeMessages {
 			log.Error("INCLUDING MESSAGES IN SYNC RESPONSE")
-			msgs, mincl, err := bss.gatherMessages(ts)
+			bmsgs, bmincl, smsgs, smincl, err := bss.gatherMessages(ts)
 			if err != nil {
 				return nil, err
 			}
-			log.Errorf("messages: ", msgs)
 
-			bst.Messages = msgs
-			bst.MsgIncludes = mincl
+			bst.BlsMessages = bmsgs
+			bst.BlsMsgIncludes = bmincl
+			bst.SecpkMessages = smsgs
+			bst.SecpkMsgIncludes = smincl
 		}
 
 		if opts.IncludeBlocks {
@@ -153,33 +158,47 @@ func (bss *BlockSyncService) collectChainSegment(start []cid.Cid, length uint64,
 	}
 }
 
-func (bss *BlockSyncService) gatherMessages(ts *TipSet) ([]*SignedMessage, [][]int, error) {
-	msgmap := make(map[cid.Cid]int)
-	var allmsgs []*SignedMessage
-	var msgincl [][]int
+func (bss *BlockSyncService) gatherMessages(ts *TipSet) ([]*Message, [][]int, []*SignedMessage, [][]int, error) {
+	blsmsgmap := make(map[cid.Cid]int)
+	secpkmsgmap := make(map[cid.Cid]int)
+	var secpkmsgs []*SignedMessage
+	var blsmsgs []*Message
+	var secpkincl, blsincl [][]int
 
 	for _, b := range ts.Blocks() {
-		msgs, err := bss.cs.MessagesForBlock(b)
+		bmsgs, smsgs, err := bss.cs.MessagesForBlock(b)
 		if err != nil {
-			return nil, nil, err
+			return nil, nil, nil, nil, err
 		}
-		log.Errorf("MESSAGES FOR BLOCK: %d", len(msgs))
 
-		msgindexes := make([]int, 0, len(msgs))
-		for _, m := range msgs {
-			i, ok := msgmap[m.Cid()]
+		bmi := make([]int, 0, len(bmsgs))
+		for _, m := range bmsgs {
+			i, ok := blsmsgmap[m.Cid()]
 			if !ok {
-				i = len(allmsgs)
-				allmsgs = append(allmsgs, m)
-				msgmap[m.Cid()] = i
+				i = len(blsmsgs)
+				blsmsgs = append(blsmsgs, m)
+				blsmsgmap[m.Cid()] = i
 			}
 
-			msgindexes = append(msgindexes, i)
+			bmi = append(bmi, i)
 		}
-		msgincl = append(msgincl, msgindexes)
+		blsincl = append(blsincl, bmi)
+
+		smi := make([]int, 0, len(smsgs))
+		for _, m := range smsgs {
+			i, ok := secpkmsgmap[m.Cid()]
+			if !ok {
+				i = len(secpkmsgs)
+				secpkmsgs = append(secpkmsgs, m)
+				secpkmsgmap[m.Cid()] = i
+			}
+
+			smi = append(smi, i)
+		}
+		secpkincl = append(secpkincl, smi)
 	}
 
-	return allmsgs, msgincl, nil
+	return blsmsgs, blsincl, secpkmsgs, secpkincl, nil
 }
 
 type BlockSync struct {
@@ -313,8 +332,11 @@ func bstsToFullTipSet(bts *BSTipSet) (*FullTipSet, error) {
 		fb := &FullBlock{
 			Header: b,
 		}
-		for _, mi := range bts.MsgIncludes[i] {
-			fb.Messages = append(fb.Messages, bts.Messages[mi])
+		for _, mi := range bts.BlsMsgIncludes[i] {
+			fb.BlsMessages = append(fb.BlsMessages, bts.BlsMessages[mi])
+		}
+		for _, mi := range bts.SecpkMsgIncludes[i] {
+			fb.SecpkMessages = append(fb.SecpkMessages, bts.SecpkMessages[mi])
 		}
 		fts.Blocks = append(fts.Blocks, fb)
 	}
@@ -394,10 +416,52 @@ func (bs *BlockSync) AddPeer(p peer.ID) {
 func (bs *BlockSync) FetchMessagesByCids(cids []cid.Cid) ([]*SignedMessage, error) {
 	out := make([]*SignedMessage, len(cids))
 
-	resp, err := bs.bswap.GetBlocks(context.TODO(), cids)
+	err := bs.fetchCids(cids, func(i int, b blocks.Block) error {
+		sm, err := DecodeSignedMessage(b.RawData())
+		if err != nil {
+			return err
+		}
+
+		if out[i] != nil {
+			return fmt.Errorf("received duplicate message")
+		}
+
+		out[i] = sm
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (bs *BlockSync) FetchBlsMessagesByCids(cids []cid.Cid) ([]*Message, error) {
+	out := make([]*Message, len(cids))
+
+	err := bs.fetchCids(cids, func(i int, b blocks.Block) error {
+		m, err := DecodeMessage(b.RawData())
+		if err != nil {
+			return err
+		}
+
+		if out[i] != nil {
+			return fmt.Errorf("received duplicate message")
+		}
+
+		out[i] = m
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
+	return out, nil
+}
+
+func (bs *BlockSync) fetchCids(cids []cid.Cid, cb func(int, blocks.Block) error) error {
+	resp, err := bs.bswap.GetBlocks(context.TODO(), cids)
+	if err != nil {
+		return err
+	}
 
 	m := make(map[cid.Cid]int)
 	for i, c := range cids {
@@ -412,25 +476,19 @@ func (bs *BlockSync) FetchMessagesByCids(cids []cid.Cid) ([]*SignedMessage, erro
 					break
 				}
 
-				return nil, fmt.Errorf("failed to fetch all messages")
+				return fmt.Errorf("failed to fetch all messages")
 			}
 
-			sm, err := DecodeSignedMessage(v.RawData())
-			if err != nil {
-				return nil, err
-			}
-			ix, ok := m[sm.Cid()]
+			ix, ok := m[v.Cid()]
 			if !ok {
-				return nil, fmt.Errorf("received message we didnt ask for")
+				return fmt.Errorf("received message we didnt ask for")
 			}
 
-			if out[ix] != nil {
-				return nil, fmt.Errorf("received duplicate message")
+			if err := cb(ix, v); err != nil {
+				return err
 			}
-
-			out[ix] = sm
 		}
 	}
 
-	return out, nil
+	return nil
 }
@@ -0,0 +1,99 @@
+package chain
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// CallGasLimit is the gas limit used for calls that don't set one
+const CallGasLimit = 10000000
+
+// GasEstimateMarginPercent is added on top of the gas used by a call when
+// estimating the gas limit of a message
+const GasEstimateMarginPercent = 25
+
+// CallOptions controls which checks Call applies to the message
+type CallOptions struct {
+	// CheckNonce makes the call fail if the message nonce doesn't match the
+	// sender's. Otherwise the current nonce of the sender is used.
+	CheckNonce bool
+
+	// Signature, if set, is verified against the message sender
+	Signature *Signature
+}
+
+// Call applies msg on top of the state of ts (or the heaviest tipset if ts is
+// nil) without committing the result. It returns the message receipt and the
+// execution trace.
+func (cs *ChainStore) Call(msg *Message, ts *TipSet, opts *CallOptions) (*MessageReceipt, *ExecutionTrace, error) {
+	if ts == nil {
+		ts = cs.GetHeaviestTipSet()
+	}
+	if opts == nil {
+		opts = &CallOptions{}
+	}
+
+	if opts.Signature != nil {
+		data, err := msg.Serialize()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := opts.Signature.Verify(msg.From, data); err != nil {
+			return nil, nil, errors.Wrap(err, "message signature invalid")
+		}
+	}
+
+	state, err := cs.TipSetState(ts.Cids())
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "loading tipset state")
+	}
+
+	// the VM state is never flushed, so nothing the call does is persisted
+	vm, err := NewVM(state, ts.Height()+1, ts.Blocks()[0].Miner, cs)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cmsg := *msg
+	if cmsg.Value.Nil() {
+		cmsg.Value = NewInt(0)
+	}
+	if cmsg.GasPrice.Nil() {
+		cmsg.GasPrice = NewInt(0)
+	}
+	if cmsg.GasLimit.Nil() || cmsg.GasLimit.Sign() == 0 {
+		cmsg.GasLimit = NewInt(CallGasLimit)
+	}
+
+	if !opts.CheckNonce {
+		from, err := vm.cstate.GetActor(cmsg.From)
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "loading sender actor")
+		}
+		cmsg.Nonce = from.Nonce
+	}
+
+	return vm.ApplyMessageTraced(&cmsg)
+}
+
+// EstimateGasLimit returns the gas limit msg needs to be applied on top of
+// the state of ts, with a safety margin added to the gas it actually used
+func (cs *ChainStore) EstimateGasLimit(msg *Message, ts *TipSet) (BigInt, error) {
+	cmsg := *msg
+	cmsg.GasLimit = NewInt(CallGasLimit)
+	cmsg.GasPrice = NewInt(0)
+
+	rct, _, err := cs.Call(&cmsg, ts, nil)
+	if err != nil {
+		return BigInt{}, err
+	}
+
+	if rct.ExitCode != 0 {
+		return BigInt{}, fmt.Errorf("message execution failed: exit %s", rct.ExitCode)
+	}
+
+	margin := BigMul(rct.GasUsed, NewInt(GasEstimateMarginPercent))
+	return BigAdd(rct.GasUsed, BigDiv(margin, NewInt(100))), nil
+}
@@ -4,9 +4,11 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/zgfzgf/mid-lotus/chain/address"
 
+	block "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	datastore "github.com/ipfs/go-datastore"
 	dstore "github.com/ipfs/go-datastore"
@@ -89,7 +91,7 @@ func MakeGenesisBlock(bs bstore.Blockstore, w *Wallet) (*GenesisBootstrap, error
 		return nil, err
 	}
 
-	minerAddr, err := w.GenerateKey(KTSecp256k1)
+	minerAddr, err := w.GenerateKey(KTBLS)
 	if err != nil {
 		return nil, err
 	}
@@ -103,6 +105,18 @@ func MakeGenesisBlock(bs bstore.Blockstore, w *Wallet) (*GenesisBootstrap, error
 		return nil, err
 	}
 
+	// the genesis miner starts out with all the power
+	smact, err := SetupStorageMarketActor(bs, []MinerInfo{
+		{Miner: minerAddr, Worker: minerAddr, Power: 1},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := state.SetActor(StorageMarketAddress, smact); err != nil {
+		return nil, err
+	}
+
 	err = state.SetActor(NetworkAddress, &Actor{
 		Code:    AccountActorCodeCid,
 		Balance: NewInt(100000000000),
@@ -112,6 +126,15 @@ func MakeGenesisBlock(bs bstore.Blockstore, w *Wallet) (*GenesisBootstrap, error
 		return nil, err
 	}
 
+	err = state.SetActor(CronActorAddress, &Actor{
+		Code:    CronActorCodeCid,
+		Balance: NewInt(0),
+		Head:    emptyobject,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	err = state.SetActor(minerAddr, &Actor{
 		Code:    AccountActorCodeCid,
 		Balance: NewInt(5000000),
@@ -134,16 +157,26 @@ func MakeGenesisBlock(bs bstore.Blockstore, w *Wallet) (*GenesisBootstrap, error
 	}
 	fmt.Println("Empty Genesis root: ", emptyroot)
 
+	mmroot, err := cst.Put(context.TODO(), &MsgMeta{
+		BlsMessages:   emptyroot,
+		SecpkMessages: emptyroot,
+	})
+	if err != nil {
+		return nil, err
+	}
+
 	b := &BlockHeader{
 		Miner:           InitActorAddress,
-		Tickets:         []Ticket{},
+		Tickets:         []Ticket{GenesisTicket},
 		ElectionProof:   []byte("the Genesis block"),
 		Parents:         []cid.Cid{},
 		Height:          0,
 		ParentWeight:    NewInt(0),
 		StateRoot:       stateroot,
-		Messages:        emptyroot,
+		Messages:        mmroot,
+		BLSAggregate:    Signature{Type: KTBLS},
 		MessageReceipts: emptyroot,
+		Timestamp:       uint64(time.Now().Unix()),
 	}
 
 	sb, err := b.ToStorageBlock()
@@ -181,10 +214,20 @@ func NewChainStore(bs bstore.Blockstore, ds datastore.Batching) *ChainStore {
 	}
 }
 
+func (cs *ChainStore) Blockstore() bstore.Blockstore {
+	return cs.bs
+}
+
+// SubNewTips returns a channel receiving each new heaviest tipset. The channel
+// must be read from until it's passed to UnsubNewTips.
 func (cs *ChainStore) SubNewTips() chan interface{} {
 	return cs.bestTips.Sub("best")
 }
 
+func (cs *ChainStore) UnsubNewTips(ch chan interface{}) {
+	cs.bestTips.Unsub(ch, "best")
+}
+
 func (cs *ChainStore) SetGenesis(b *BlockHeader) error {
 	gents, err := NewTipSet([]*BlockHeader{b})
 	if err != nil {
@@ -228,6 +271,7 @@ func (cs *ChainStore) maybeTakeHeavierTipSet(ts *TipSet) error {
 		cs.headChange(revert, apply)
 		log.Errorf("New heaviest tipset! %s", ts.Cids())
 		cs.heaviest = ts
+		cs.bestTips.Pub(ts, "best")
 	}
 	return nil
 }
@@ -325,8 +369,25 @@ func (cs *ChainStore) ReorgOps(a, b *TipSet) ([]*TipSet, []*TipSet, error) {
 	return leftChain, rightChain, nil
 }
 
+const (
+	// BlockWeight is the weight each block adds to the chain
+	BlockWeight = 10
+
+	// NullRoundPenalty is the weight taken off a tipset for each null round
+	// between it and its parent
+	NullRoundPenalty = 1
+)
+
+// Weight returns the weight of the chain ending in ts. Null rounds make a
+// chain lighter, a tipset still always adds some weight.
 func (cs *ChainStore) Weight(ts *TipSet) uint64 {
-	return ts.Blocks()[0].ParentWeight.Uint64() + uint64(len(ts.Cids()))
+	w := BlockWeight * uint64(len(ts.Cids()))
+	penalty := NullRoundPenalty * ts.NullRounds()
+	if penalty >= w {
+		penalty = w - 1
+	}
+
+	return ts.Blocks()[0].ParentWeight.Uint64() + w - penalty
 }
 
 func (cs *ChainStore) GetHeaviestTipSet() *TipSet {
@@ -349,7 +410,12 @@ func (cs *ChainStore) persistBlock(b *FullBlock) error {
 		return err
 	}
 
-	for _, m := range b.Messages {
+	for _, m := range b.BlsMessages {
+		if err := cs.PutMessage(m); err != nil {
+			return err
+		}
+	}
+	for _, m := range b.SecpkMessages {
 		if err := cs.PutMessage(m); err != nil {
 			return err
 		}
@@ -357,7 +423,11 @@ func (cs *ChainStore) persistBlock(b *FullBlock) error {
 	return nil
 }
 
-func (cs *ChainStore) PutMessage(m *SignedMessage) error {
+type storable interface {
+	ToStorageBlock() (block.Block, error)
+}
+
+func (cs *ChainStore) PutMessage(m storable) error {
 	sb, err := m.ToStorageBlock()
 	if err != nil {
 		return err
@@ -420,9 +490,18 @@ func (cs *ChainStore) GetMessage(c cid.Cid) (*SignedMessage, error) {
 	return DecodeSignedMessage(sb.RawData())
 }
 
-func (cs *ChainStore) MessagesForBlock(b *BlockHeader) ([]*SignedMessage, error) {
+func (cs *ChainStore) GetBlsMessage(c cid.Cid) (*Message, error) {
+	sb, err := cs.bs.Get(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return DecodeMessage(sb.RawData())
+}
+
+func (cs *ChainStore) readSharrayCids(root cid.Cid) ([]cid.Cid, error) {
 	cst := hamt.CSTFromBstore(cs.bs)
-	shar, err := sharray.Load(context.TODO(), b.Messages, 4, cst)
+	shar, err := sharray.Load(context.TODO(), root, 4, cst)
 	if err != nil {
 		return nil, errors.Wrap(err, "sharray load")
 	}
@@ -441,7 +520,150 @@ func (cs *ChainStore) MessagesForBlock(b *BlockHeader) ([]*SignedMessage, error)
 		return nil, err
 	}
 
-	return cs.LoadMessagesFromCids(cids)
+	return cids, nil
+}
+
+// readMsgMetaCids returns the cids of the BLS and secp messages linked from
+// a MsgMeta
+func (cs *ChainStore) readMsgMetaCids(mmc cid.Cid) ([]cid.Cid, []cid.Cid, error) {
+	cst := hamt.CSTFromBstore(cs.bs)
+	var msgmeta MsgMeta
+	if err := cst.Get(context.TODO(), mmc, &msgmeta); err != nil {
+		return nil, nil, errors.Wrap(err, "loading msgmeta")
+	}
+
+	blscids, err := cs.readSharrayCids(msgmeta.BlsMessages)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "loading bls message cids")
+	}
+
+	secpkcids, err := cs.readSharrayCids(msgmeta.SecpkMessages)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "loading secpk message cids")
+	}
+
+	return blscids, secpkcids, nil
+}
+
+// MessagesForBlock returns the BLS and secp messages included in a block
+func (cs *ChainStore) MessagesForBlock(b *BlockHeader) ([]*Message, []*SignedMessage, error) {
+	blscids, secpkcids, err := cs.readMsgMetaCids(b.Messages)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	blsmsgs, err := cs.LoadBlsMessagesFromCids(blscids)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "loading bls messages")
+	}
+
+	secpkmsgs, err := cs.LoadMessagesFromCids(secpkcids)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "loading secpk messages")
+	}
+
+	return blsmsgs, secpkmsgs, nil
+}
+
+// BlockMessages returns the messages of a block in the order they are
+// applied, BLS messages first
+func (cs *ChainStore) BlockMessages(b *BlockHeader) ([]*Message, error) {
+	blsmsgs, secpkmsgs, err := cs.MessagesForBlock(b)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]*Message, 0, len(blsmsgs)+len(secpkmsgs))
+	out = append(out, blsmsgs...)
+	for _, m := range secpkmsgs {
+		out = append(out, &m.Message)
+	}
+	return out, nil
+}
+
+// GetBalance returns the balance of addr in the state of the heaviest tipset,
+// addresses without an actor have a zero balance
+func (cs *ChainStore) GetBalance(addr address.Address) (BigInt, error) {
+	st, err := cs.headStateTree()
+	if err != nil {
+		return BigInt{}, err
+	}
+
+	act, err := st.GetActor(addr)
+	if err != nil {
+		if err == ErrActorNotFound {
+			return NewInt(0), nil
+		}
+		return BigInt{}, err
+	}
+
+	return act.Balance, nil
+}
+
+// ActorExists returns whether there is an actor for addr in the state of the
+// heaviest tipset
+func (cs *ChainStore) ActorExists(addr address.Address) (bool, error) {
+	st, err := cs.headStateTree()
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := st.GetActor(addr); err != nil {
+		if err == ErrActorNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func (cs *ChainStore) headStateTree() (*StateTree, error) {
+	ts := cs.GetHeaviestTipSet()
+
+	root, err := cs.TipSetState(ts.Cids())
+	if err != nil {
+		return nil, errors.Wrap(err, "loading head state")
+	}
+
+	st, err := LoadStateTree(hamt.CSTFromBstore(cs.bs), root)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading head state tree")
+	}
+	return st, nil
+}
+
+// LoadFullBlock loads the messages referenced by a block message from the
+// local store
+func (cs *ChainStore) LoadFullBlock(bm *BlockMsg) (*FullBlock, error) {
+	bmsgs, err := cs.LoadBlsMessagesFromCids(bm.BlsMessages)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading bls messages")
+	}
+
+	smsgs, err := cs.LoadMessagesFromCids(bm.SecpkMessages)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading secpk messages")
+	}
+
+	return &FullBlock{
+		Header:        bm.Header,
+		BlsMessages:   bmsgs,
+		SecpkMessages: smsgs,
+	}, nil
+}
+
+func (cs *ChainStore) LoadBlsMessagesFromCids(cids []cid.Cid) ([]*Message, error) {
+	msgs := make([]*Message, 0, len(cids))
+	for _, c := range cids {
+		m, err := cs.GetBlsMessage(c)
+		if err != nil {
+			return nil, err
+		}
+
+		msgs = append(msgs, m)
+	}
+
+	return msgs, nil
 }
 
 func (cs *ChainStore) LoadMessagesFromCids(cids []cid.Cid) ([]*SignedMessage, error) {
@@ -0,0 +1,41 @@
+package chain
+
+import (
+	"testing"
+)
+
+func TestWeightNullRoundPenalty(t *testing.T) {
+	tc := newTestChain(t)
+	gen := tc.cs.GetHeaviestTipSet()
+	base := tc.cs.Weight(gen)
+
+	for _, c := range []struct {
+		nullRounds int
+		weight     uint64
+	}{
+		{0, base + BlockWeight},
+		{1, base + BlockWeight - NullRoundPenalty},
+		{3, base + BlockWeight - 3*NullRoundPenalty},
+	} {
+		b := tc.mineBlock(t, gen, c.nullRounds, nil)
+		ts, err := NewTipSet([]*BlockHeader{b.Header})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if w := tc.cs.Weight(ts); w != c.weight {
+			t.Errorf("%d null rounds: weight %d, expected %d", c.nullRounds, w, c.weight)
+		}
+	}
+
+	// a tipset always adds weight, however long the gap before it
+	h := *tc.mineBlock(t, gen, 0, nil).Header
+	h.Tickets = make([]Ticket, 2*BlockWeight)
+	ts, err := NewTipSet([]*BlockHeader{&h})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := tc.cs.Weight(ts); w != base+1 {
+		t.Errorf("weight after a long gap %d, expected %d", w, base+1)
+	}
+}
@@ -0,0 +1,83 @@
+package chain
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/zgfzgf/mid-lotus/build"
+)
+
+// Clock maps wall clock time to epochs. Epoch 0 starts at the genesis
+// timestamp, and each epoch lasts build.BlockDelay seconds.
+type Clock struct {
+	genesisTime uint64
+}
+
+func NewClock(genesisTime uint64) *Clock {
+	return &Clock{genesisTime: genesisTime}
+}
+
+// Clock returns the clock of the chain, derived from its genesis block
+func (cs *ChainStore) Clock() (*Clock, error) {
+	gen, err := cs.GetGenesis()
+	if err != nil {
+		return nil, err
+	}
+
+	return NewClock(gen.Timestamp), nil
+}
+
+// EpochStart returns the unix timestamp at which the epoch starts
+func (c *Clock) EpochStart(h uint64) uint64 {
+	return c.genesisTime + h*build.BlockDelay
+}
+
+// CurrentEpoch returns the epoch the wall clock is in
+func (c *Clock) CurrentEpoch() uint64 {
+	now := uint64(time.Now().Unix())
+	if now < c.genesisTime {
+		return 0
+	}
+	return (now - c.genesisTime) / build.BlockDelay
+}
+
+// WaitForEpoch blocks until the epoch starts, or ctx is cancelled
+func (c *Clock) WaitForEpoch(ctx context.Context, h uint64) error {
+	start := time.Unix(int64(c.EpochStart(h)), 0)
+
+	select {
+	case <-time.After(time.Until(start)):
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CheckRecent returns an error if height h is more than build.MaxBlockLag
+// epochs behind the current epoch. Blocks mined on time are never that late,
+// so it's checked for blocks as they're received.
+func (c *Clock) CheckRecent(h uint64) error {
+	if cur := c.CurrentEpoch(); h+build.MaxBlockLag < cur {
+		return errors.Errorf("block at height %d is too far behind the current epoch %d", h, cur)
+	}
+	return nil
+}
+
+// CheckTimestamp returns an error if the timestamp isn't within the epoch at
+// height h, or is in the future
+func (c *Clock) CheckTimestamp(timestamp, h uint64) error {
+	if now := uint64(time.Now().Unix()); timestamp > now+build.AllowableClockDrift {
+		return errors.Errorf("block timestamp %d is in the future (now %d)", timestamp, now)
+	}
+
+	if timestamp < c.EpochStart(h) {
+		return errors.Errorf("block timestamp %d is too old for height %d, which starts at %d", timestamp, h, c.EpochStart(h))
+	}
+	if timestamp >= c.EpochStart(h+1) {
+		return errors.Errorf("block timestamp %d is past the end of height %d", timestamp, h)
+	}
+
+	return nil
+}
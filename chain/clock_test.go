@@ -0,0 +1,100 @@
+package chain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/zgfzgf/mid-lotus/build"
+)
+
+func TestClockEpochs(t *testing.T) {
+	genesis := uint64(time.Now().Unix()) - 10*build.BlockDelay
+	c := NewClock(genesis)
+
+	if s := c.EpochStart(0); s != genesis {
+		t.Errorf("epoch 0 starts at %d, expected %d", s, genesis)
+	}
+	if s := c.EpochStart(3); s != genesis+3*build.BlockDelay {
+		t.Errorf("epoch 3 starts at %d, expected %d", s, genesis+3*build.BlockDelay)
+	}
+
+	// allow for the wall clock moving on while the test runs
+	if e := c.CurrentEpoch(); e < 10 || e > 11 {
+		t.Errorf("current epoch %d, expected 10", e)
+	}
+
+	future := NewClock(uint64(time.Now().Unix()) + 100)
+	if e := future.CurrentEpoch(); e != 0 {
+		t.Errorf("current epoch %d before genesis, expected 0", e)
+	}
+}
+
+func TestClockCheckTimestamp(t *testing.T) {
+	genesis := uint64(time.Now().Unix()) - 100*build.BlockDelay
+	c := NewClock(genesis)
+
+	const h = 5
+	start, end := c.EpochStart(h), c.EpochStart(h+1)
+
+	for _, ts := range []uint64{start, end - 1} {
+		if err := c.CheckTimestamp(ts, h); err != nil {
+			t.Errorf("timestamp %d: %s", ts, err)
+		}
+	}
+
+	for _, ts := range []uint64{start - 1, end} {
+		if err := c.CheckTimestamp(ts, h); err == nil {
+			t.Errorf("timestamp %d outside of epoch %d accepted", ts, h)
+		}
+	}
+}
+
+func TestClockCheckTimestampFuture(t *testing.T) {
+	now := uint64(time.Now().Unix())
+	c := NewClock(now - 100*build.BlockDelay)
+
+	epochOf := func(ts uint64) uint64 {
+		return (ts - c.genesisTime) / build.BlockDelay
+	}
+
+	// a block slightly ahead of our clock is fine
+	ts := now + build.AllowableClockDrift
+	if err := c.CheckTimestamp(ts, epochOf(ts)); err != nil {
+		t.Errorf("timestamp within the allowed drift: %s", err)
+	}
+
+	ts = now + build.AllowableClockDrift + 60
+	if err := c.CheckTimestamp(ts, epochOf(ts)); err == nil {
+		t.Error("timestamp in the future accepted")
+	}
+}
+
+func TestClockWaitForEpoch(t *testing.T) {
+	c := NewClock(uint64(time.Now().Unix()))
+
+	if err := c.WaitForEpoch(context.Background(), 0); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := c.WaitForEpoch(ctx, 1000); err != context.Canceled {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestClockCheckRecent(t *testing.T) {
+	c := NewClock(uint64(time.Now().Unix()) - 100*build.BlockDelay)
+	cur := c.CurrentEpoch()
+
+	for _, h := range []uint64{cur, cur - build.MaxBlockLag + 1} {
+		if err := c.CheckRecent(h); err != nil {
+			t.Errorf("height %d: %s", h, err)
+		}
+	}
+
+	if err := c.CheckRecent(cur - build.MaxBlockLag - 2); err == nil {
+		t.Error("block far behind the current epoch accepted")
+	}
+}
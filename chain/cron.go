@@ -0,0 +1,92 @@
+package chain
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"github.com/zgfzgf/mid-lotus/chain/address"
+	"github.com/zgfzgf/mid-lotus/chain/aerrors"
+)
+
+const CronMethodEpochTick = 1
+
+// CronEntry is a method invoked on a system actor once at the end of every tipset
+type CronEntry struct {
+	Receiver address.Address
+	Method   uint64
+}
+
+var cronLk sync.Mutex
+var cronEntries []CronEntry
+
+// RegisterCronEntry adds a cron handler. Handlers run ordered by receiver
+// address and then method number, so the state transition doesn't depend on
+// the order in which they were registered.
+func RegisterCronEntry(receiver address.Address, method uint64) {
+	cronLk.Lock()
+	defer cronLk.Unlock()
+
+	for _, e := range cronEntries {
+		if e.Receiver == receiver && e.Method == method {
+			return
+		}
+	}
+
+	cronEntries = append(cronEntries, CronEntry{
+		Receiver: receiver,
+		Method:   method,
+	})
+
+	sort.Slice(cronEntries, func(i, j int) bool {
+		c := bytes.Compare(cronEntries[i].Receiver.Bytes(), cronEntries[j].Receiver.Bytes())
+		if c != 0 {
+			return c < 0
+		}
+		return cronEntries[i].Method < cronEntries[j].Method
+	})
+}
+
+// CronEntries returns the registered cron handlers in the order they are run
+func CronEntries() []CronEntry {
+	cronLk.Lock()
+	defer cronLk.Unlock()
+
+	out := make([]CronEntry, len(cronEntries))
+	copy(out, cronEntries)
+	return out
+}
+
+type CronActor struct{}
+
+func (ca CronActor) EpochTick(act *Actor, vmctx *VMContext, params []byte) ([]byte, aerrors.ActorError) {
+	if vmctx.Message().From != CronActorAddress {
+		return nil, aerrors.New(aerrors.SysErrForbidden, "EpochTick is only callable by the system")
+	}
+
+	for _, e := range CronEntries() {
+		_, err := vmctx.Send(e.Receiver, e.Method, NewInt(0), nil)
+		if err != nil {
+			if err.IsFatal() {
+				return nil, err
+			}
+
+			// one failing handler shouldn't keep the others from running
+			log.Warnf("cron handler %s:%d failed: %s", e.Receiver, e.Method, err)
+		}
+	}
+
+	return nil, nil
+}
+
+func cronTickMessage(height uint64) *Message {
+	return &Message{
+		To:       CronActorAddress,
+		From:     CronActorAddress,
+		Nonce:    height,
+		Value:    NewInt(0),
+		GasPrice: NewInt(0),
+		GasLimit: NewInt(1 << 30),
+		Method:   CronMethodEpochTick,
+	}
+}
@@ -0,0 +1,204 @@
+package chain
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/minio/blake2b-simd"
+	"github.com/pkg/errors"
+
+	"github.com/zgfzgf/mid-lotus/chain/address"
+
+	hamt "github.com/ipfs/go-hamt-ipld"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+// GenesisTicket is the ticket of the genesis block, the first tickets of the
+// chain are derived from it
+var GenesisTicket = Ticket("the Genesis ticket")
+
+// MinerInfo is a miner's entry in the storage market power table
+type MinerInfo struct {
+	Miner  address.Address
+	Worker address.Address
+	Power  uint64
+}
+
+// StorageMarketState holds the power table used for leader election
+type StorageMarketState struct {
+	Miners     []MinerInfo
+	TotalPower uint64
+}
+
+func SetupStorageMarketActor(bs bstore.Blockstore, miners []MinerInfo) (*Actor, error) {
+	sms := StorageMarketState{
+		Miners: miners,
+	}
+	for _, mi := range miners {
+		sms.TotalPower += mi.Power
+	}
+
+	cst := hamt.CSTFromBstore(bs)
+	statecid, err := cst.Put(context.TODO(), &sms)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Actor{
+		Code:    StorageMarketActorCodeCid,
+		Head:    statecid,
+		Balance: NewInt(0),
+	}, nil
+}
+
+// MinerPower returns the miner's power table entry and the total power in
+// the state of ts
+func (cs *ChainStore) MinerPower(ts *TipSet, maddr address.Address) (*MinerInfo, uint64, error) {
+	stcid, err := cs.TipSetState(ts.Cids())
+	if err != nil {
+		return nil, 0, err
+	}
+
+	cst := hamt.CSTFromBstore(cs.bs)
+	st, err := LoadStateTree(cst, stcid)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	act, err := st.GetActor(StorageMarketAddress)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "loading storage market actor")
+	}
+
+	var sms StorageMarketState
+	if err := cst.Get(context.TODO(), act.Head, &sms); err != nil {
+		return nil, 0, errors.Wrap(err, "loading storage market state")
+	}
+
+	for i := range sms.Miners {
+		if sms.Miners[i].Miner == maddr {
+			return &sms.Miners[i], sms.TotalPower, nil
+		}
+	}
+
+	return nil, 0, fmt.Errorf("miner %s not in power table", maddr)
+}
+
+// MinTicket returns the smallest of the last tickets of the blocks in the
+// tipset, it is the input for the tickets of the next round
+func (ts *TipSet) MinTicket() Ticket {
+	var min Ticket
+	for _, b := range ts.Blocks() {
+		t := GenesisTicket
+		if len(b.Tickets) > 0 {
+			t = b.Tickets[len(b.Tickets)-1]
+		}
+
+		if min == nil || bytes.Compare(t, min) < 0 {
+			min = t
+		}
+	}
+	return min
+}
+
+// NullRounds returns the number of rounds without blocks between the tipset
+// and its parent, each of them adds a ticket to the blocks
+func (ts *TipSet) NullRounds() uint64 {
+	n := len(ts.Blocks()[0].Tickets)
+	if n == 0 {
+		return 0
+	}
+	return uint64(n - 1)
+}
+
+// ComputeVRF signs input with the worker key. Only BLS signatures are unique
+// for a key and message, so workers must have BLS keys, otherwise a miner
+// could try several signatures until one wins.
+func ComputeVRF(w *Wallet, worker address.Address, input []byte) ([]byte, error) {
+	if worker.Protocol() != address.BLS {
+		return nil, fmt.Errorf("worker %s is not a bls address", worker)
+	}
+
+	sig, err := w.Sign(worker, input)
+	if err != nil {
+		return nil, err
+	}
+
+	return sig.Data, nil
+}
+
+// VerifyVRF checks that out was produced by ComputeVRF with the given worker
+// and input
+func VerifyVRF(worker address.Address, input, out []byte) error {
+	if worker.Protocol() != address.BLS {
+		return fmt.Errorf("worker %s is not a bls address", worker)
+	}
+
+	sig := Signature{
+		Type: KTBLS,
+		Data: out,
+	}
+	return sig.Verify(worker, input)
+}
+
+var maxProofHash = new(big.Int).Lsh(big.NewInt(1), 256)
+
+// IsTicketWinner returns whether the election proof wins a round for a miner
+// with the given share of the total power, which happens with probability
+// power / total
+func IsTicketWinner(proof ElectionProof, power, total uint64) bool {
+	if total == 0 {
+		return false
+	}
+
+	h := blake2b.Sum256(proof)
+
+	// h / 2^256 < power / total
+	lhs := new(big.Int).SetBytes(h[:])
+	lhs.Mul(lhs, new(big.Int).SetUint64(total))
+
+	rhs := new(big.Int).SetUint64(power)
+	rhs.Mul(rhs, maxProofHash)
+
+	return lhs.Cmp(rhs) < 0
+}
+
+// validateElection checks the block's tickets and election proof against the
+// power table of its parent tipset
+func (syncer *Syncer) validateElection(h *BlockHeader, baseTs *TipSet, mi *MinerInfo, total uint64) error {
+	// one ticket for the block itself, and one for each null round
+	if len(h.Tickets) == 0 {
+		return fmt.Errorf("block has no tickets")
+	}
+	if h.Height != baseTs.Height()+uint64(len(h.Tickets)) {
+		return fmt.Errorf("block at height %d has %d tickets, parent is at height %d", h.Height, len(h.Tickets), baseTs.Height())
+	}
+
+	if pw := syncer.store.Weight(baseTs); h.ParentWeight.Uint64() != pw {
+		return fmt.Errorf("block has parent weight %s, expected %d", h.ParentWeight, pw)
+	}
+
+	if mi.Worker.Protocol() != address.BLS {
+		return fmt.Errorf("miner %s has worker %s, workers must be bls addresses", h.Miner, mi.Worker)
+	}
+
+	prev := baseTs.MinTicket()
+	for i, t := range h.Tickets {
+		if err := VerifyVRF(mi.Worker, prev, t); err != nil {
+			return errors.Wrapf(err, "invalid ticket %d", i)
+		}
+		prev = t
+	}
+
+	if err := VerifyVRF(mi.Worker, prev, h.ElectionProof); err != nil {
+		return errors.Wrap(err, "invalid election proof")
+	}
+
+	if !IsTicketWinner(h.ElectionProof, mi.Power, total) {
+		return fmt.Errorf("miner %s did not win the election", h.Miner)
+	}
+
+	return nil
+}
@@ -0,0 +1,137 @@
+package chain
+
+import (
+	"testing"
+)
+
+// electionBlock builds a block on the genesis tipset with a ticket and
+// election proof computed by worker
+func electionBlock(t *testing.T, tc *testChain, worker func(input []byte) []byte) (*BlockHeader, *TipSet) {
+	t.Helper()
+
+	base := tc.cs.GetHeaviestTipSet()
+	ticket := worker(base.MinTicket())
+	proof := worker(ticket)
+
+	return &BlockHeader{
+		Miner:         tc.miner,
+		Tickets:       []Ticket{ticket},
+		ElectionProof: proof,
+		Height:        base.Height() + 1,
+		ParentWeight:  NewInt(tc.cs.Weight(base)),
+	}, base
+}
+
+func TestElectionWinAndVerify(t *testing.T) {
+	tc := newTestChain(t)
+	syncer := &Syncer{store: tc.cs}
+
+	mi, total, err := tc.cs.MinerPower(tc.cs.GetHeaviestTipSet(), tc.miner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, base := electionBlock(t, tc, func(input []byte) []byte {
+		out, err := ComputeVRF(tc.w, mi.Worker, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	})
+
+	// the genesis miner has all the power, so it wins every round
+	if err := syncer.validateElection(h, base, mi, total); err != nil {
+		t.Fatal(err)
+	}
+
+	if IsTicketWinner(h.ElectionProof, 0, total) {
+		t.Error("miner without power won")
+	}
+	if IsTicketWinner(h.ElectionProof, mi.Power, 0) {
+		t.Error("miner won with no total power")
+	}
+}
+
+func TestElectionTamperedProofRejected(t *testing.T) {
+	tc := newTestChain(t)
+	syncer := &Syncer{store: tc.cs}
+
+	mi, total, err := tc.cs.MinerPower(tc.cs.GetHeaviestTipSet(), tc.miner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, base := electionBlock(t, tc, func(input []byte) []byte {
+		out, err := ComputeVRF(tc.w, mi.Worker, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	})
+
+	proof := make(ElectionProof, len(h.ElectionProof))
+	copy(proof, h.ElectionProof)
+	proof[0] ^= 0xff
+	h.ElectionProof = proof
+
+	if err := syncer.validateElection(h, base, mi, total); err == nil {
+		t.Fatal("tampered election proof accepted")
+	}
+}
+
+func TestElectionSecpWorkerRejected(t *testing.T) {
+	tc := newTestChain(t)
+	syncer := &Syncer{store: tc.cs}
+
+	worker, err := tc.w.GenerateKey(KTSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ComputeVRF(tc.w, worker, []byte("input")); err == nil {
+		t.Fatal("computed a VRF with a secp256k1 worker")
+	}
+
+	// valid signatures from the worker still don't make a valid proof
+	h, base := electionBlock(t, tc, func(input []byte) []byte {
+		sig, err := tc.w.Sign(worker, input)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig.Data
+	})
+
+	mi := &MinerInfo{Miner: tc.miner, Worker: worker, Power: 1}
+	if err := syncer.validateElection(h, base, mi, 1); err == nil {
+		t.Fatal("election proof from a secp256k1 worker accepted")
+	}
+	if err := VerifyVRF(worker, base.MinTicket(), h.Tickets[0]); err == nil {
+		t.Fatal("ticket from a secp256k1 worker verified")
+	}
+}
+
+func TestElectionHeightMatchesTickets(t *testing.T) {
+	tc := newTestChain(t)
+	syncer := &Syncer{store: tc.cs}
+
+	gen := tc.cs.GetHeaviestTipSet()
+	mi, total, err := tc.cs.MinerPower(gen, tc.miner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b := tc.mineBlock(t, gen, 2, nil)
+	if b.Header.Height != gen.Height()+uint64(len(b.Header.Tickets)) {
+		t.Fatalf("block at height %d with %d tickets", b.Header.Height, len(b.Header.Tickets))
+	}
+	if err := syncer.validateElection(b.Header, gen, mi, total); err != nil {
+		t.Fatal(err)
+	}
+
+	// claiming fewer null rounds than the tickets say
+	h := *b.Header
+	h.Height--
+	if err := syncer.validateElection(&h, gen, mi, total); err == nil {
+		t.Fatal("block with a height not matching its tickets accepted")
+	}
+}
@@ -0,0 +1,44 @@
+package chain
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/zgfzgf/mid-lotus/build"
+)
+
+// FIL is an amount of attoFIL, formatted and parsed in whole FIL
+type FIL BigInt
+
+func (f FIL) String() string {
+	if f.Int == nil {
+		return "0 FIL"
+	}
+
+	r := new(big.Rat).SetFrac(f.Int, big.NewInt(build.FilecoinPrecision))
+	s := strings.TrimRight(r.FloatString(18), "0")
+	return strings.TrimSuffix(s, ".") + " FIL"
+}
+
+// ParseFIL parses an amount in FIL, like "1.5" or "1.5 FIL", into attoFIL.
+// Negative amounts are rejected.
+func ParseFIL(s string) (FIL, error) {
+	s = strings.TrimSpace(s)
+	s = strings.TrimSpace(strings.TrimSuffix(strings.ToLower(s), "fil"))
+
+	r, ok := new(big.Rat).SetString(s)
+	if !ok {
+		return FIL{}, fmt.Errorf("failed to parse %q as a FIL amount", s)
+	}
+	if r.Sign() < 0 {
+		return FIL{}, fmt.Errorf("%q is a negative amount", s)
+	}
+
+	r.Mul(r, new(big.Rat).SetInt64(build.FilecoinPrecision))
+	if !r.IsInt() {
+		return FIL{}, fmt.Errorf("%q has more precision than an attoFIL", s)
+	}
+
+	return FIL{Int: r.Num()}, nil
+}
@@ -0,0 +1,42 @@
+package chain
+
+import (
+	"testing"
+)
+
+func TestParseFIL(t *testing.T) {
+	for _, tcase := range []struct {
+		in  string
+		out string
+	}{
+		{"1", "1000000000000000000"},
+		{"1.5 FIL", "1500000000000000000"},
+		{"0.000000000000000001", "1"},
+		{" 2fil ", "2000000000000000000"},
+		{"0", "0"},
+	} {
+		f, err := ParseFIL(tcase.in)
+		if err != nil {
+			t.Fatalf("parsing %q: %s", tcase.in, err)
+		}
+		if f.Int.String() != tcase.out {
+			t.Errorf("%q parsed as %s attoFIL, expected %s", tcase.in, f.Int, tcase.out)
+		}
+	}
+
+	for _, in := range []string{"", "abc", "-1", "-0.5 FIL", "0.0000000000000000001"} {
+		if _, err := ParseFIL(in); err == nil {
+			t.Errorf("parsed invalid amount %q", in)
+		}
+	}
+}
+
+func TestFILString(t *testing.T) {
+	f, err := ParseFIL("1.25")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := f.String(); s != "1.25 FIL" {
+		t.Errorf("formatted as %q", s)
+	}
+}
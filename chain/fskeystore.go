@@ -0,0 +1,221 @@
+package chain
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	fsKeystoreSaltFile = ".salt"
+
+	// scrypt parameters used to derive the encryption key from the
+	// passphrase
+	fsKeystoreScryptN = 1 << 15
+	fsKeystoreScryptR = 8
+	fsKeystoreScryptP = 1
+)
+
+// key names are encoded so they are always valid file names
+var fsKeyNameEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// FSKeystore is a Keystore storing each key in its own file. Keys are
+// encrypted with AES-GCM, using a key derived from a passphrase with scrypt.
+// Neither the directory nor the key files may be accessible by other users.
+type FSKeystore struct {
+	path string
+	aead cipher.AEAD
+}
+
+// OpenFSKeystore opens the keystore at path, creating it if needed. A wrong
+// passphrase is only detected when reading a key.
+func OpenFSKeystore(path string, passphrase string) (*FSKeystore, error) {
+	if err := os.MkdirAll(path, 0700); err != nil {
+		return nil, errors.Wrap(err, "creating keystore directory")
+	}
+
+	if err := checkPermissions(path, 0700); err != nil {
+		return nil, err
+	}
+
+	salt, err := loadKeystoreSalt(path)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := passphraseAEAD(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &FSKeystore{
+		path: path,
+		aead: aead,
+	}, nil
+}
+
+// passphraseAEAD returns an AES-GCM cipher keyed with a key derived from the
+// passphrase
+func passphraseAEAD(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, fsKeystoreScryptN, fsKeystoreScryptR, fsKeystoreScryptP, 32)
+	if err != nil {
+		return nil, errors.Wrap(err, "deriving key from passphrase")
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func loadKeystoreSalt(path string) ([]byte, error) {
+	p := filepath.Join(path, fsKeystoreSaltFile)
+
+	salt, err := ioutil.ReadFile(p)
+	switch {
+	case err == nil:
+		return salt, checkPermissions(p, 0600)
+	case !os.IsNotExist(err):
+		return nil, errors.Wrap(err, "reading keystore salt")
+	}
+
+	salt = make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	if err := writeFileExcl(p, salt); err != nil {
+		return nil, errors.Wrap(err, "writing keystore salt")
+	}
+	return salt, nil
+}
+
+// checkPermissions returns an error if the file has permissions not in allowed
+func checkPermissions(path string, allowed os.FileMode) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if fi.Mode().Perm()&^allowed != 0 {
+		return fmt.Errorf("%s has permissions %o, expected at most %o", path, fi.Mode().Perm(), allowed)
+	}
+	return nil
+}
+
+func writeFileExcl(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close() //nolint:errcheck
+		return err
+	}
+	return f.Close()
+}
+
+func (fks *FSKeystore) keyPath(name string) string {
+	return filepath.Join(fks.path, fsKeyNameEncoding.EncodeToString([]byte(name)))
+}
+
+func (fks *FSKeystore) List() ([]string, error) {
+	files, err := ioutil.ReadDir(fks.path)
+	if err != nil {
+		return nil, err
+	}
+
+	var out []string
+	for _, f := range files {
+		if f.Name() == fsKeystoreSaltFile {
+			continue
+		}
+
+		name, err := fsKeyNameEncoding.DecodeString(f.Name())
+		if err != nil {
+			return nil, errors.Wrapf(err, "unexpected file %s in keystore", f.Name())
+		}
+		out = append(out, string(name))
+	}
+
+	sort.Strings(out)
+	return out, nil
+}
+
+func (fks *FSKeystore) Get(name string) (KeyInfo, error) {
+	p := fks.keyPath(name)
+
+	data, err := ioutil.ReadFile(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return KeyInfo{}, ErrKeyNotFound
+		}
+		return KeyInfo{}, err
+	}
+
+	if err := checkPermissions(p, 0600); err != nil {
+		return KeyInfo{}, err
+	}
+
+	ns := fks.aead.NonceSize()
+	if len(data) < ns {
+		return KeyInfo{}, fmt.Errorf("key %s is truncated", name)
+	}
+
+	plain, err := fks.aead.Open(nil, data[:ns], data[ns:], []byte(name))
+	if err != nil {
+		return KeyInfo{}, fmt.Errorf("decrypting key %s failed, wrong passphrase?", name)
+	}
+
+	var ki KeyInfo
+	if err := json.Unmarshal(plain, &ki); err != nil {
+		return KeyInfo{}, errors.Wrapf(err, "decoding key %s", name)
+	}
+	return ki, nil
+}
+
+func (fks *FSKeystore) Put(name string, ki KeyInfo) error {
+	plain, err := json.Marshal(ki)
+	if err != nil {
+		return err
+	}
+
+	nonce := make([]byte, fks.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return err
+	}
+
+	// the name is authenticated so key files can't be swapped around
+	data := fks.aead.Seal(nonce, nonce, plain, []byte(name))
+
+	if err := writeFileExcl(fks.keyPath(name), data); err != nil {
+		if os.IsExist(err) {
+			return ErrKeyExists
+		}
+		return err
+	}
+	return nil
+}
+
+func (fks *FSKeystore) Delete(name string) error {
+	err := os.Remove(fks.keyPath(name))
+	if os.IsNotExist(err) {
+		return ErrKeyNotFound
+	}
+	return err
+}
+
+var _ Keystore = &FSKeystore{}
@@ -0,0 +1,102 @@
+package chain
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempKeystoreDir(t *testing.T) string {
+	t.Helper()
+
+	dir, err := ioutil.TempDir("", "fskeystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "keystore")
+}
+
+func TestFSKeystoreRoundTrip(t *testing.T) {
+	dir := tempKeystoreDir(t)
+	defer os.RemoveAll(filepath.Dir(dir)) //nolint:errcheck
+
+	ks, err := OpenFSKeystore(dir, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ki := KeyInfo{Type: KTSecp256k1, PrivateKey: bytes.Repeat([]byte{7}, 32)}
+	if err := ks.Put("wallet-k", ki); err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Put("wallet-k", ki); err != ErrKeyExists {
+		t.Fatalf("expected ErrKeyExists, got %v", err)
+	}
+
+	// the key isn't stored in plain text
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, f.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Contains(data, ki.PrivateKey) {
+			t.Fatalf("%s holds the plain private key", f.Name())
+		}
+	}
+
+	// reopening with the same passphrase reads it back
+	ks, err = OpenFSKeystore(dir, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := ks.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 1 || names[0] != "wallet-k" {
+		t.Fatalf("unexpected keys %v", names)
+	}
+
+	got, err := ks.Get("wallet-k")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Type != ki.Type || !bytes.Equal(got.PrivateKey, ki.PrivateKey) {
+		t.Fatalf("got %+v, expected %+v", got, ki)
+	}
+
+	if err := ks.Delete("wallet-k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Get("wallet-k"); err != ErrKeyNotFound {
+		t.Fatalf("expected ErrKeyNotFound, got %v", err)
+	}
+}
+
+func TestFSKeystoreWrongPassphrase(t *testing.T) {
+	dir := tempKeystoreDir(t)
+	defer os.RemoveAll(filepath.Dir(dir)) //nolint:errcheck
+
+	ks, err := OpenFSKeystore(dir, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ks.Put("wallet-k", KeyInfo{Type: KTSecp256k1, PrivateKey: []byte("secret")}); err != nil {
+		t.Fatal(err)
+	}
+
+	ks, err = OpenFSKeystore(dir, "battery staple")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ks.Get("wallet-k"); err == nil {
+		t.Fatal("read a key with the wrong passphrase")
+	}
+}
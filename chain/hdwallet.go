@@ -0,0 +1,149 @@
+package chain
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	"github.com/zgfzgf/mid-lotus/chain/address"
+	"github.com/zgfzgf/mid-lotus/lib/hd"
+)
+
+// KTHDSeed is the key type of the wallet's HD seed
+const KTHDSeed = "hd-seed"
+
+// KHDSeed is the keystore name of the wallet's HD seed
+const KHDSeed = "hd-seed"
+
+// DefaultHDScanGap is how many unused addresses in a row HDScan looks at
+// before it stops, as in BIP44
+const DefaultHDScanGap = 20
+
+// DeriveHDKey derives the key of the given type at index from an HD seed.
+// secp256k1 keys use the path m/44'/461'/0'/0/index, and bls keys
+// m/12381/461/0/index.
+func DeriveHDKey(seed []byte, typ string, index uint32) (*KeyInfo, error) {
+	var pk []byte
+	var err error
+	switch typ {
+	case KTSecp256k1:
+		pk, err = hd.DeriveSecp256k1(seed, hd.Secp256k1Path(index))
+	case KTBLS:
+		pk, err = hd.DeriveBLS(seed, hd.BLSPath(index))
+	default:
+		return nil, fmt.Errorf("invalid key type: %s", typ)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "deriving %s key %d", typ, index)
+	}
+
+	return &KeyInfo{
+		PrivateKey: pk,
+		Type:       typ,
+	}, nil
+}
+
+// HDInit sets the seed new HD keys are derived from, restored from a BIP39
+// mnemonic. The seed is kept in the keystore, and can't be replaced once set.
+func (w *Wallet) HDInit(mnemonic, passphrase string) error {
+	if w.remote != nil {
+		return ErrRemoteWallet
+	}
+
+	seed, err := hd.MnemonicToSeed(mnemonic, passphrase)
+	if err != nil {
+		return err
+	}
+
+	w.hdLk.Lock()
+	defer w.hdLk.Unlock()
+
+	if w.hdSeed != nil {
+		return errors.Wrap(ErrKeyExists, "wallet already has an HD seed")
+	}
+
+	if err := w.keystore.Put(KHDSeed, KeyInfo{Type: KTHDSeed, PrivateKey: seed}); err != nil {
+		return errors.Wrap(err, "saving HD seed")
+	}
+
+	w.hdSeed = seed
+	return nil
+}
+
+// HasHDSeed returns whether HD keys can be generated
+func (w *Wallet) HasHDSeed() bool {
+	w.hdLk.Lock()
+	defer w.hdLk.Unlock()
+
+	return w.hdSeed != nil
+}
+
+// GenerateHDKey derives the first key of the given type which isn't in the
+// wallet yet, and adds it
+func (w *Wallet) GenerateHDKey(typ string) (address.Address, error) {
+	w.hdLk.Lock()
+	defer w.hdLk.Unlock()
+
+	if w.hdSeed == nil {
+		return address.Undef, fmt.Errorf("wallet has no HD seed")
+	}
+
+	for i := uint32(0); i < hd.Hardened; i++ {
+		ki, err := DeriveHDKey(w.hdSeed, typ, i)
+		if err != nil {
+			return address.Undef, err
+		}
+
+		if w.HasKey(ki.Address()) {
+			continue
+		}
+
+		return w.addKey(ki)
+	}
+
+	return address.Undef, fmt.Errorf("all %s HD keys are in use", typ)
+}
+
+// HDScan derives keys of the given type in order and adds those whose
+// address is used, it stops after gap unused addresses in a row. It returns
+// the used addresses.
+func (w *Wallet) HDScan(typ string, gap int, used func(address.Address) (bool, error)) ([]address.Address, error) {
+	w.hdLk.Lock()
+	defer w.hdLk.Unlock()
+
+	if w.hdSeed == nil {
+		return nil, fmt.Errorf("wallet has no HD seed")
+	}
+	if gap <= 0 {
+		gap = DefaultHDScanGap
+	}
+
+	var out []address.Address
+	unused := 0
+	for i := uint32(0); unused < gap && i < hd.Hardened; i++ {
+		ki, err := DeriveHDKey(w.hdSeed, typ, i)
+		if err != nil {
+			return nil, err
+		}
+
+		addr := ki.Address()
+		ok, err := used(addr)
+		if err != nil {
+			return nil, errors.Wrapf(err, "checking %s", addr)
+		}
+		if !ok {
+			unused++
+			continue
+		}
+		unused = 0
+
+		if !w.HasKey(addr) {
+			if _, err := w.addKey(ki); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, addr)
+	}
+
+	return out, nil
+}
@@ -0,0 +1,47 @@
+package chain
+
+import (
+	"github.com/zgfzgf/mid-lotus/chain/aerrors"
+
+	"github.com/ipfs/go-cid"
+)
+
+// ActorMethod is the native implementation of a single method of a built-in actor
+type ActorMethod func(act *Actor, vmctx *VMContext, params []byte) ([]byte, aerrors.ActorError)
+
+type nativeCode map[uint64]ActorMethod
+
+type invoker struct {
+	builtInCode map[cid.Cid]nativeCode
+}
+
+func newInvoker() *invoker {
+	inv := &invoker{
+		builtInCode: make(map[cid.Cid]nativeCode),
+	}
+
+	// add builtInCode using: register(cid, methods)
+	inv.register(CronActorCodeCid, nativeCode{
+		CronMethodEpochTick: CronActor{}.EpochTick,
+	})
+
+	return inv
+}
+
+func (inv *invoker) register(c cid.Cid, code nativeCode) {
+	inv.builtInCode[c] = code
+}
+
+func (inv *invoker) Invoke(act *Actor, vmctx *VMContext, method uint64, params []byte) ([]byte, aerrors.ActorError) {
+	code, ok := inv.builtInCode[act.Code]
+	if !ok {
+		return nil, aerrors.Newf(aerrors.SysErrIllegalActor, "no code for actor %s", act.Code)
+	}
+
+	m, ok := code[method]
+	if !ok {
+		return nil, aerrors.Newf(aerrors.SysErrInvalidMethod, "no method %d on actor %s", method, act.Code)
+	}
+
+	return m(act, vmctx, params)
+}
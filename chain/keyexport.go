@@ -0,0 +1,116 @@
+package chain
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// Exported keys are hex encoded JSON. Without a password, the JSON is the
+// KeyInfo itself, with the private key in base64:
+//
+//   {"Type":"secp256k1","PrivateKey":"..."}
+//
+// With a password, the KeyInfo JSON is encrypted with AES-GCM, under a key
+// derived from the password with scrypt, and wrapped as:
+//
+//   {"Encrypted":{"Salt":"...","Nonce":"...","Ciphertext":"..."}}
+//
+// The type is "secp256k1" or "bls", and the private key is the raw 32 byte
+// scalar for both.
+
+type encryptedKeyInfo struct {
+	Salt       []byte
+	Nonce      []byte
+	Ciphertext []byte
+}
+
+type exportedKey struct {
+	Type       string `json:",omitempty"`
+	PrivateKey []byte `json:",omitempty"`
+
+	Encrypted *encryptedKeyInfo `json:",omitempty"`
+}
+
+// EncodeKeyInfo encodes the key in the export format, encrypted if password
+// isn't empty
+func EncodeKeyInfo(ki *KeyInfo, password string) (string, error) {
+	plain, err := json.Marshal(ki)
+	if err != nil {
+		return "", err
+	}
+
+	if password == "" {
+		return hex.EncodeToString(plain), nil
+	}
+
+	enc := &encryptedKeyInfo{
+		Salt: make([]byte, 32),
+	}
+	if _, err := rand.Read(enc.Salt); err != nil {
+		return "", err
+	}
+
+	aead, err := passphraseAEAD(password, enc.Salt)
+	if err != nil {
+		return "", err
+	}
+
+	enc.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(enc.Nonce); err != nil {
+		return "", err
+	}
+	enc.Ciphertext = aead.Seal(nil, enc.Nonce, plain, nil)
+
+	out, err := json.Marshal(&exportedKey{Encrypted: enc})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(out), nil
+}
+
+// DecodeKeyInfo decodes a key in the export format. The password is required
+// if the key was exported with one.
+func DecodeKeyInfo(s string, password string) (*KeyInfo, error) {
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		return nil, errors.Wrap(err, "decoding hex")
+	}
+
+	var ek exportedKey
+	if err := json.Unmarshal(data, &ek); err != nil {
+		return nil, errors.Wrap(err, "decoding key json")
+	}
+
+	if ek.Encrypted == nil {
+		ki := &KeyInfo{Type: ek.Type, PrivateKey: ek.PrivateKey}
+		return ki, ki.Validate()
+	}
+
+	if password == "" {
+		return nil, fmt.Errorf("key is encrypted, a password is required")
+	}
+
+	aead, err := passphraseAEAD(password, ek.Encrypted.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(ek.Encrypted.Nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid nonce size %d", len(ek.Encrypted.Nonce))
+	}
+
+	plain, err := aead.Open(nil, ek.Encrypted.Nonce, ek.Encrypted.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypting key failed, wrong password?")
+	}
+
+	var ki KeyInfo
+	if err := json.Unmarshal(plain, &ki); err != nil {
+		return nil, errors.Wrap(err, "decoding key json")
+	}
+	return &ki, ki.Validate()
+}
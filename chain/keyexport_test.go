@@ -0,0 +1,136 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestKeyInfoExportRoundTrip(t *testing.T) {
+	w, err := NewWallet(NewMemKeystore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, typ := range []string{KTSecp256k1, KTBLS} {
+		addr, err := w.GenerateKey(typ)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ki, err := w.Export(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for _, password := range []string{"", "correct horse"} {
+			s, err := EncodeKeyInfo(ki, password)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			out, err := DecodeKeyInfo(s, password)
+			if err != nil {
+				t.Fatalf("%s key, password %q: %s", typ, password, err)
+			}
+			if out.Type != ki.Type || !bytes.Equal(out.PrivateKey, ki.PrivateKey) {
+				t.Fatalf("%s key, password %q: decoded a different key", typ, password)
+			}
+			if out.Address() != addr {
+				t.Fatalf("%s key, password %q: decoded key has address %s, expected %s", typ, password, out.Address(), addr)
+			}
+		}
+	}
+}
+
+func TestKeyInfoExportPassword(t *testing.T) {
+	w, err := NewWallet(NewMemKeystore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := w.GenerateKey(KTSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ki, err := w.Export(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := EncodeKeyInfo(ki, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(s, hex.EncodeToString(ki.PrivateKey)) {
+		t.Fatal("encrypted export holds the plain private key")
+	}
+
+	if _, err := DecodeKeyInfo(s, ""); err == nil || !strings.Contains(err.Error(), "password is required") {
+		t.Fatalf("expected a missing password error, got %v", err)
+	}
+	if _, err := DecodeKeyInfo(s, "battery staple"); err == nil || !strings.Contains(err.Error(), "wrong password") {
+		t.Fatalf("expected a wrong password error, got %v", err)
+	}
+}
+
+func TestDecodeKeyInfoInvalid(t *testing.T) {
+	encode := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hex.EncodeToString(b)
+	}
+
+	cases := []struct {
+		name   string
+		in     string
+		expect string
+	}{
+		{"not hex", "zz", "decoding hex"},
+		{"not json", hex.EncodeToString([]byte("{not json")), "decoding key json"},
+		{"unknown type", encode(&KeyInfo{Type: "ed25519", PrivateKey: make([]byte, 32)}), "unknown key type"},
+		{"no type", encode(&KeyInfo{PrivateKey: make([]byte, 32)}), "unknown key type"},
+		{"short secp256k1 key", encode(&KeyInfo{Type: KTSecp256k1, PrivateKey: make([]byte, 16)}), "must be"},
+		{"short bls key", encode(&KeyInfo{Type: KTBLS, PrivateKey: make([]byte, 16)}), "must be"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if _, err := DecodeKeyInfo(c.in, ""); err == nil || !strings.Contains(err.Error(), c.expect) {
+				t.Fatalf("expected %q, got %v", c.expect, err)
+			}
+		})
+	}
+
+	// corrupt ciphertext is refused, not decoded into garbage
+	w, err := NewWallet(NewMemKeystore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := w.GenerateKey(KTSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ki, err := w.Export(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := EncodeKeyInfo(ki, "correct horse")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ek exportedKey
+	if err := json.Unmarshal(data, &ek); err != nil {
+		t.Fatal(err)
+	}
+	ek.Encrypted.Ciphertext[0] ^= 1
+	if _, err := DecodeKeyInfo(encode(&ek), "correct horse"); err == nil {
+		t.Fatal("corrupt ciphertext decoded")
+	}
+}
@@ -0,0 +1,81 @@
+package chain
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrKeyNotFound = fmt.Errorf("key not found")
+	ErrKeyExists   = fmt.Errorf("key already exists")
+)
+
+// Keystore stores KeyInfo entries by name
+type Keystore interface {
+	// List returns the names of all stored keys
+	List() ([]string, error)
+	// Get returns the key with the given name, or ErrKeyNotFound
+	Get(name string) (KeyInfo, error)
+	// Put stores a key, it fails with ErrKeyExists if the name is taken
+	Put(name string, ki KeyInfo) error
+	// Delete removes a key, or returns ErrKeyNotFound
+	Delete(name string) error
+}
+
+// MemKeystore is a Keystore which only keeps keys in memory
+type MemKeystore struct {
+	lk   sync.Mutex
+	keys map[string]KeyInfo
+}
+
+func NewMemKeystore() *MemKeystore {
+	return &MemKeystore{keys: make(map[string]KeyInfo)}
+}
+
+func (mks *MemKeystore) List() ([]string, error) {
+	mks.lk.Lock()
+	defer mks.lk.Unlock()
+
+	out := make([]string, 0, len(mks.keys))
+	for name := range mks.keys {
+		out = append(out, name)
+	}
+	sort.Strings(out)
+	return out, nil
+}
+
+func (mks *MemKeystore) Get(name string) (KeyInfo, error) {
+	mks.lk.Lock()
+	defer mks.lk.Unlock()
+
+	ki, ok := mks.keys[name]
+	if !ok {
+		return KeyInfo{}, ErrKeyNotFound
+	}
+	return ki, nil
+}
+
+func (mks *MemKeystore) Put(name string, ki KeyInfo) error {
+	mks.lk.Lock()
+	defer mks.lk.Unlock()
+
+	if _, ok := mks.keys[name]; ok {
+		return ErrKeyExists
+	}
+	mks.keys[name] = ki
+	return nil
+}
+
+func (mks *MemKeystore) Delete(name string) error {
+	mks.lk.Lock()
+	defer mks.lk.Unlock()
+
+	if _, ok := mks.keys[name]; !ok {
+		return ErrKeyNotFound
+	}
+	delete(mks.keys, name)
+	return nil
+}
+
+var _ Keystore = &MemKeystore{}
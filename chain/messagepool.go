@@ -1,22 +1,106 @@
 package chain
 
 import (
+	"fmt"
+	"sort"
 	"sync"
 
 	"github.com/zgfzgf/mid-lotus/chain/address"
+
+	lru "github.com/hashicorp/golang-lru"
+	"github.com/ipfs/go-cid"
+	hamt "github.com/ipfs/go-hamt-ipld"
+	"github.com/pkg/errors"
+)
+
+// MaxNonceGap is how far ahead of the sender's pending nonce a message nonce
+// may be for the message to be accepted into the pool
+const MaxNonceGap = 4
+
+const blsSigCacheSize = 40000
+
+const (
+	// DefaultReplaceByFeePercent is how much, in percent, the gas price of a
+	// message must exceed the price of the pending message it replaces
+	DefaultReplaceByFeePercent = 25
+
+	// DefaultMaxPending is the default limit on the number of messages in
+	// the pool
+	DefaultMaxPending = 10000
+
+	// DefaultMaxPendingPerSender is the default limit on the number of
+	// pending messages from a single sender
+	DefaultMaxPendingPerSender = 1000
+)
+
+// Errors returned by MessagePool.Add. They are wrapped with details about the
+// message, use errors.Cause to compare against them.
+var (
+	ErrInvalidToAddr    = errors.New("message has invalid to address")
+	ErrInvalidFromAddr  = errors.New("message has invalid from address")
+	ErrInvalidValue     = errors.New("message has invalid value")
+	ErrInvalidGas       = errors.New("message has invalid gas price or limit")
+	ErrInvalidSignature = errors.New("message signature invalid")
+	ErrSenderNotFound   = errors.New("message sender has no actor")
+	ErrNonceTooLow      = errors.New("message nonce too low")
+	ErrNonceGap         = errors.New("message nonce too far ahead of pending nonce")
+	ErrNotEnoughFunds   = errors.New("not enough funds to cover value and gas")
+
+	ErrReplaceByFeeTooLow = errors.New("gas price too low to replace pending message")
+	ErrTooManyPending     = errors.New("too many pending messages from sender")
+	ErrMpoolFull          = errors.New("message pool is full")
 )
 
 type MessagePool struct {
 	lk sync.Mutex
 
 	pending map[address.Address]*msgSet
+	size    int
+
+	localMsgs map[cid.Cid]*SignedMessage
+
+	// localDeferred holds the local messages which aren't valid against the
+	// current head, they're retried on every head change
+	localDeferred map[cid.Cid]*SignedMessage
+
+	// updates are queued by notify while lk is held, and delivered to subs
+	// by publishUpdates after it's released
+	queueLk sync.Mutex
+	queued  []MpoolUpdate
+	subLk   sync.Mutex
+	subs    map[*mpoolSub]struct{}
 
-	cs *ChainStore
+	// blsSigCache keeps the signatures of BLS messages, which aren't stored
+	// in blocks, so reverted BLS messages can be added back to the pool
+	blsSigCache *lru.Cache
+
+	cs     *ChainStore
+	wallet *Wallet
+
+	// BlockGasLimit and BlockSizeLimit bound the messages returned by
+	// SelectMessages
+	BlockGasLimit  BigInt
+	BlockSizeLimit int
+
+	// ReplaceByFeePercent is how much higher, in percent, the gas price of a
+	// message must be to replace a pending message with the same nonce
+	ReplaceByFeePercent uint64
+
+	// MaxPending and MaxPendingPerSender bound the size of the pool. When the
+	// pool is full, the cheapest messages from senders not in our wallet are
+	// evicted to make space.
+	MaxPending          int
+	MaxPendingPerSender int
 }
 
 type msgSet struct {
 	msgs       map[uint64]*SignedMessage
 	startNonce uint64
+	nextNonce  uint64
+
+	// local is set if the sender's key is in our wallet, it's checked when
+	// the set is created
+	local bool
 }
 
 func newMsgSet() *msgSet {
@@ -29,32 +113,116 @@ func (ms *msgSet) add(m *SignedMessage) {
 	if len(ms.msgs) == 0 || m.Message.Nonce < ms.startNonce {
 		ms.startNonce = m.Message.Nonce
 	}
+	if m.Message.Nonce+1 > ms.nextNonce {
+		ms.nextNonce = m.Message.Nonce + 1
+	}
 	ms.msgs[m.Message.Nonce] = m
 }
 
-func NewMessagePool(cs *ChainStore) *MessagePool {
+func (ms *msgSet) rm(nonce uint64) {
+	delete(ms.msgs, nonce)
+
+	ms.nextNonce = 0
+	first := true
+	for n := range ms.msgs {
+		if first || n < ms.startNonce {
+			ms.startNonce = n
+		}
+		if n+1 > ms.nextNonce {
+			ms.nextNonce = n + 1
+		}
+		first = false
+	}
+}
+
+// last returns the pending message with the highest nonce
+func (ms *msgSet) last() *SignedMessage {
+	return ms.msgs[ms.nextNonce-1]
+}
+
+func NewMessagePool(cs *ChainStore, w *Wallet) *MessagePool {
+	cache, _ := lru.New(blsSigCacheSize)
 	mp := &MessagePool{
-		pending: make(map[address.Address]*msgSet),
-		cs:      cs,
+		pending:       make(map[address.Address]*msgSet),
+		localMsgs:     make(map[cid.Cid]*SignedMessage),
+		localDeferred: make(map[cid.Cid]*SignedMessage),
+		subs:          make(map[*mpoolSub]struct{}),
+
+		blsSigCache: cache,
+		cs:          cs,
+		wallet:      w,
+
+		BlockGasLimit:  NewInt(DefaultBlockGasLimit),
+		BlockSizeLimit: DefaultBlockSizeLimit,
+
+		ReplaceByFeePercent: DefaultReplaceByFeePercent,
+		MaxPending:          DefaultMaxPending,
+		MaxPendingPerSender: DefaultMaxPendingPerSender,
 	}
 	cs.headChange = mp.HeadChange
 
 	return mp
 }
 
+// Add validates m against the actor state at the current head and adds it
+// to the pool
 func (mp *MessagePool) Add(m *SignedMessage) error {
+	local := mp.isLocal(m.Message.From)
+
+	defer mp.publishUpdates()
 	mp.lk.Lock()
 	defer mp.lk.Unlock()
 
-	data, err := m.Message.Serialize()
+	st, err := mp.stateAt(mp.cs.GetHeaviestTipSet())
 	if err != nil {
 		return err
 	}
 
-	if err := m.Signature.Verify(m.Message.From, data); err != nil {
+	return mp.add(m, st, local)
+}
+
+// add validates m against st and adds it to the pool, it must be called
+// with the pool lock held. local says whether the sender's key is in our
+// wallet.
+func (mp *MessagePool) add(m *SignedMessage, st *StateTree, local bool) error {
+	if err := mp.checkMessage(m, st); err != nil {
 		return err
 	}
 
+	mset, ok := mp.pending[m.Message.From]
+	if !ok {
+		mset = newMsgSet()
+		mset.local = local
+	}
+
+	var evict *SignedMessage
+	if prev, ok := mset.msgs[m.Message.Nonce]; ok {
+		if prev.Cid() == m.Cid() {
+			return nil
+		}
+
+		if !mp.canReplace(prev, m) {
+			return errors.Wrapf(ErrReplaceByFeeTooLow, "gas price %s, pending gas price %s, min increase %d%%",
+				m.Message.GasPrice, prev.Message.GasPrice, mp.ReplaceByFeePercent)
+		}
+	} else {
+		if len(mset.msgs) >= mp.MaxPendingPerSender {
+			return errors.Wrapf(ErrTooManyPending, "from %s", m.Message.From)
+		}
+
+		if mp.size >= mp.MaxPending {
+			evict = mp.evictionCandidate(m.Message.From)
+			if evict == nil || BigCmp(evict.Message.GasPrice, m.Message.GasPrice) >= 0 {
+				if !mset.local {
+					return ErrMpoolFull
+				}
+
+				// our own messages are let in even over the limit
+				evict = nil
+			}
+		}
+	}
+
 	msb, err := m.ToStorageBlock()
 	if err != nil {
 		return err
@@ -64,31 +232,233 @@ func (mp *MessagePool) Add(m *SignedMessage) error {
 		return err
 	}
 
-	mset, ok := mp.pending[m.Message.From]
-	if !ok {
-		mset = newMsgSet()
-		mp.pending[m.Message.From] = mset
+	if evict != nil {
+		log.Infof("evicting message %d from %s from mpool", evict.Message.Nonce, evict.Message.From)
+		mp.remove(evict.Message.From, evict.Message.Nonce, RemoveEvicted)
+	}
+
+	if m.Signature.Type == KTBLS {
+		mp.blsSigCache.Add(m.Message.Cid(), m.Signature)
 	}
 
+	if prev, ok := mset.msgs[m.Message.Nonce]; ok {
+		mp.forgetLocal(prev)
+		mp.notify(MpoolRemove, RemoveReplaced, prev)
+	} else {
+		mp.size++
+	}
 	mset.add(m)
+	mp.pending[m.Message.From] = mset
+
+	mp.notify(MpoolAdd, 0, m)
 	return nil
 }
 
+// canReplace returns whether next pays enough more for gas than prev to
+// replace it in the pool
+func (mp *MessagePool) canReplace(prev, next *SignedMessage) bool {
+	if BigCmp(next.Message.GasPrice, prev.Message.GasPrice) <= 0 {
+		return false
+	}
+
+	minPrice := BigMul(prev.Message.GasPrice, NewInt(100+mp.ReplaceByFeePercent))
+	return BigCmp(BigMul(next.Message.GasPrice, NewInt(100)), minPrice) >= 0
+}
+
+// evictionCandidate returns the cheapest message which can be evicted from
+// the pool. Only the last message of each sender is considered so that no
+// nonce gaps are created. Messages from skip and from our own addresses are
+// never evicted.
+func (mp *MessagePool) evictionCandidate(skip address.Address) *SignedMessage {
+	var out *SignedMessage
+	for from, mset := range mp.pending {
+		if from == skip || mset.local {
+			continue
+		}
+
+		m := mset.last()
+		if out == nil || BigCmp(m.Message.GasPrice, out.Message.GasPrice) < 0 {
+			out = m
+		}
+	}
+
+	return out
+}
+
+// isLocal returns whether the key of addr is in our wallet. It must be called
+// without the pool lock held, as the wallet may ask a remote signer.
+func (mp *MessagePool) isLocal(addr address.Address) bool {
+	return mp.wallet != nil && mp.wallet.HasKey(addr)
+}
+
+func (mp *MessagePool) checkMessage(m *SignedMessage, st *StateTree) error {
+	msg := &m.Message
+
+	if msg.To.Empty() {
+		return ErrInvalidToAddr
+	}
+	switch msg.To.Protocol() {
+	case address.ID, address.SECP256K1, address.Actor, address.BLS:
+	default:
+		// String panics on unknown protocols
+		return errors.Wrapf(ErrInvalidToAddr, "unknown protocol %d", msg.To.Protocol())
+	}
+
+	if msg.From.Empty() {
+		return ErrInvalidFromAddr
+	}
+	if p := msg.From.Protocol(); p != address.SECP256K1 && p != address.BLS {
+		return errors.Wrapf(ErrInvalidFromAddr, "protocol %d, messages must be sent from key addresses", p)
+	}
+
+	if msg.Value.Nil() || msg.Value.Sign() < 0 {
+		return errors.Wrapf(ErrInvalidValue, "value %s", msg.Value)
+	}
+	if msg.GasPrice.Nil() || msg.GasPrice.Sign() < 0 {
+		return errors.Wrapf(ErrInvalidGas, "gas price %s", msg.GasPrice)
+	}
+	// a message has to pay for its inclusion, so it can't have a zero limit
+	if msg.GasLimit.Nil() {
+		return errors.Wrap(ErrInvalidGas, "no gas limit")
+	}
+	if minGas := NewInt(onChainMessageGas(msg)); BigCmp(msg.GasLimit, minGas) < 0 {
+		return errors.Wrapf(ErrInvalidGas, "gas limit %s, inclusion costs %s", msg.GasLimit, minGas)
+	}
+
+	data, err := msg.Serialize()
+	if err != nil {
+		return err
+	}
+
+	if err := m.Signature.Verify(msg.From, data); err != nil {
+		return errors.Wrap(ErrInvalidSignature, err.Error())
+	}
+
+	act, err := st.GetActor(msg.From)
+	if err != nil {
+		if err == ErrActorNotFound {
+			return errors.Wrapf(ErrSenderNotFound, "from %s", msg.From)
+		}
+		return err
+	}
+
+	if msg.Nonce < act.Nonce {
+		return errors.Wrapf(ErrNonceTooLow, "nonce %d, sender nonce %d", msg.Nonce, act.Nonce)
+	}
+
+	pending := mp.pendingNonce(msg.From, act)
+	if msg.Nonce > pending+MaxNonceGap {
+		return errors.Wrapf(ErrNonceGap, "nonce %d, pending nonce %d", msg.Nonce, pending)
+	}
+
+	totalCost := BigAdd(BigMul(msg.GasLimit, msg.GasPrice), msg.Value)
+	if BigCmp(act.Balance, totalCost) < 0 {
+		return errors.Wrapf(ErrNotEnoughFunds, "balance %s, required %s", act.Balance, totalCost)
+	}
+
+	return nil
+}
+
+// stateAt loads the state tree of ts, messages are validated against the
+// state of the current head
+func (mp *MessagePool) stateAt(ts *TipSet) (*StateTree, error) {
+	root, err := mp.cs.TipSetState(ts.Cids())
+	if err != nil {
+		return nil, errors.Wrap(err, "loading head state")
+	}
+
+	st, err := LoadStateTree(hamt.CSTFromBstore(mp.cs.bs), root)
+	if err != nil {
+		return nil, errors.Wrap(err, "loading head state tree")
+	}
+
+	return st, nil
+}
+
+// pendingNonce returns the nonce the next message from addr should use,
+// taking messages already in the pool into account. It's the first nonce
+// from the sender's nonce on without a pending message, messages after a
+// gap don't count.
+func (mp *MessagePool) pendingNonce(addr address.Address, act *Actor) uint64 {
+	nonce := act.Nonce
+	mset, ok := mp.pending[addr]
+	if !ok {
+		return nonce
+	}
+
+	for {
+		if _, ok := mset.msgs[nonce]; !ok {
+			return nonce
+		}
+		nonce++
+	}
+}
+
+// GetNonce returns the nonce the next message sent from addr should use
+func (mp *MessagePool) GetNonce(addr address.Address) (uint64, error) {
+	mp.lk.Lock()
+	defer mp.lk.Unlock()
+
+	st, err := mp.stateAt(mp.cs.GetHeaviestTipSet())
+	if err != nil {
+		return 0, err
+	}
+
+	act, err := st.GetActor(addr)
+	if err != nil {
+		if err != ErrActorNotFound {
+			return 0, err
+		}
+		act = &Actor{}
+	}
+
+	return mp.pendingNonce(addr, act), nil
+}
+
+// Remove drops the pending message with the same sender and nonce as m,
+// which was included in the chain
 func (mp *MessagePool) Remove(m *SignedMessage) {
+	defer mp.publishUpdates()
 	mp.lk.Lock()
 	defer mp.lk.Unlock()
 
-	mset, ok := mp.pending[m.Message.From]
+	mp.removeIncluded(&m.Message)
+}
+
+func (mp *MessagePool) removeIncluded(m *Message) {
+	mset, ok := mp.pending[m.From]
 	if !ok {
 		return
 	}
 
 	// NB: This deletes any message with the given nonce. This makes sense
 	// as two messages with the same sender cannot have the same nonce
-	delete(mset.msgs, m.Message.Nonce)
+	reason := RemoveIncluded
+	if pm, ok := mset.msgs[m.Nonce]; ok && pm.Message.Cid() != m.Cid() {
+		reason = RemoveInvalid
+	}
+	mp.remove(m.From, m.Nonce, reason)
+}
+
+func (mp *MessagePool) remove(from address.Address, nonce uint64, reason MpoolRemoveReason) {
+	mset, ok := mp.pending[from]
+	if !ok {
+		return
+	}
+
+	m, ok := mset.msgs[nonce]
+	if !ok {
+		return
+	}
+
+	mp.forgetLocal(m)
+	mset.rm(nonce)
+	mp.size--
+
+	mp.notify(MpoolRemove, reason, m)
 
 	if len(mset.msgs) == 0 {
-		delete(mp.pending, m.Message.From)
+		delete(mp.pending, from)
 	}
 }
 
@@ -109,32 +479,162 @@ func (mp *MessagePool) Pending() []*SignedMessage {
 	return out
 }
 
+// HeadChange updates the pool after the chain head moved. Messages from
+// reverted blocks are added back, messages from applied blocks are removed,
+// and the remaining messages are revalidated against the new head. Failures
+// with individual messages or blocks are logged and don't stop the update.
 func (mp *MessagePool) HeadChange(revert []*TipSet, apply []*TipSet) error {
+	if len(apply) == 0 {
+		return nil
+	}
+
+	// NB: this is called before the chain store updates its heaviest tipset,
+	// the new head is the first applied tipset
+	st, err := mp.stateAt(apply[0])
+	if err != nil {
+		return err
+	}
+
+	// reverted messages and the locality of their senders are collected
+	// before taking the lock, checking locality may be slow
+	var readd []*SignedMessage
+	local := make(map[address.Address]bool)
 	for _, ts := range revert {
-		for _, b := range ts.Blocks() {
-			msgs, err := mp.cs.MessagesForBlock(b)
+		for _, msg := range mp.tipsetMessages(ts) {
+			smsg, err := mp.recoverSig(msg)
 			if err != nil {
-				return err
+				log.Infof("not re-adding reverted message %s to mpool: %s", msg.Cid(), err)
+				continue
 			}
-			for _, msg := range msgs {
-				if err := mp.Add(msg); err != nil {
-					return err
-				}
+
+			from := smsg.Message.From
+			if _, ok := local[from]; !ok {
+				local[from] = mp.isLocal(from)
 			}
+			readd = append(readd, smsg)
 		}
 	}
 
+	defer mp.publishUpdates()
+	mp.lk.Lock()
+	defer mp.lk.Unlock()
+
+	for _, smsg := range readd {
+		if err := mp.add(smsg, st, local[smsg.Message.From]); err != nil {
+			log.Infof("not re-adding reverted message %s to mpool: %s", smsg.Cid(), err)
+		}
+	}
+
+	included := make(map[cid.Cid]bool)
 	for _, ts := range apply {
-		for _, b := range ts.Blocks() {
-			msgs, err := mp.cs.MessagesForBlock(b)
-			if err != nil {
-				return err
-			}
-			for _, msg := range msgs {
-				mp.Remove(msg)
-			}
+		for _, msg := range mp.tipsetMessages(ts) {
+			mp.removeIncluded(msg.VMMessage())
+			included[msg.Cid()] = true
 		}
 	}
 
+	mp.revalidate(st)
+	mp.retryDeferred(st, included)
 	return nil
 }
+
+// blockMsg is a message included in a block, BLS messages are stored
+// without a signature
+type blockMsg struct {
+	bls   *Message
+	secpk *SignedMessage
+}
+
+func (bm blockMsg) VMMessage() *Message {
+	if bm.bls != nil {
+		return bm.bls
+	}
+	return &bm.secpk.Message
+}
+
+func (bm blockMsg) Cid() cid.Cid {
+	if bm.bls != nil {
+		return bm.bls.Cid()
+	}
+	return bm.secpk.Cid()
+}
+
+func (mp *MessagePool) tipsetMessages(ts *TipSet) []blockMsg {
+	var out []blockMsg
+	for _, b := range ts.Blocks() {
+		bmsgs, smsgs, err := mp.cs.MessagesForBlock(b)
+		if err != nil {
+			log.Errorf("loading messages for block %s: %s", b.Cid(), err)
+			continue
+		}
+		for _, m := range bmsgs {
+			out = append(out, blockMsg{bls: m})
+		}
+		for _, m := range smsgs {
+			out = append(out, blockMsg{secpk: m})
+		}
+	}
+	return out
+}
+
+// recoverSig returns the signed form of a message taken from a block
+func (mp *MessagePool) recoverSig(bm blockMsg) (*SignedMessage, error) {
+	if bm.secpk != nil {
+		return bm.secpk, nil
+	}
+
+	sig, ok := mp.blsSigCache.Get(bm.bls.Cid())
+	if !ok {
+		return nil, fmt.Errorf("signature not in bls signature cache")
+	}
+
+	return &SignedMessage{
+		Message:   *bm.bls,
+		Signature: sig.(Signature),
+	}, nil
+}
+
+// revalidate drops pending messages which can no longer be applied on top of
+// st, because their nonce was used or their sender can't pay for them. When
+// a sender can't pay for a message, its later messages are dropped too.
+func (mp *MessagePool) revalidate(st *StateTree) {
+	for from, mset := range mp.pending {
+		act, err := st.GetActor(from)
+		if err != nil {
+			if err != ErrActorNotFound {
+				log.Errorf("revalidating messages from %s: %s", from, err)
+				continue
+			}
+			act = &Actor{Balance: NewInt(0)}
+		}
+
+		nonces := make([]uint64, 0, len(mset.msgs))
+		for n := range mset.msgs {
+			nonces = append(nonces, n)
+		}
+		sort.Slice(nonces, func(i, j int) bool { return nonces[i] < nonces[j] })
+
+		// messages are applied in nonce order, each one has to be paid for
+		// with what is left after the ones before it
+		spent := NewInt(0)
+		for i, n := range nonces {
+			m := mset.msgs[n]
+			if n < act.Nonce {
+				mp.remove(from, n, RemoveInvalid)
+				continue
+			}
+
+			cost := BigAdd(BigMul(m.Message.GasLimit, m.Message.GasPrice), m.Message.Value)
+			if BigCmp(BigAdd(spent, cost), act.Balance) > 0 {
+				// the later messages can't be applied without this one, local
+				// ones are kept until the sender can afford them again
+				for _, later := range nonces[i:] {
+					mp.deferLocal(mset.msgs[later])
+					mp.remove(from, later, RemoveInvalid)
+				}
+				break
+			}
+			spent = BigAdd(spent, cost)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/zgfzgf/mid-lotus/chain/address"
+)
+
+// testMsg is a transfer from the test chain's accounts
+func (tc *testChain) testMsg(t *testing.T, from address.Address, nonce uint64, gasPrice int64) *SignedMessage {
+	t.Helper()
+
+	return tc.sign(t, &Message{
+		From: from, To: NetworkAddress, Nonce: nonce,
+		Value: NewInt(1), GasPrice: NewInt(uint64(gasPrice)), GasLimit: NewInt(1000),
+	})
+}
+
+// localWallet returns a wallet holding the test chain's keys for addrs, a
+// pool using it treats those senders as local
+func (tc *testChain) localWallet(t *testing.T, addrs ...address.Address) *Wallet {
+	t.Helper()
+
+	w, err := NewWallet(NewMemKeystore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, addr := range addrs {
+		ki, err := tc.w.Export(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Import(ki); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w
+}
+
+func pendingFrom(mp *MessagePool) map[address.Address]int {
+	out := make(map[address.Address]int)
+	for _, m := range mp.Pending() {
+		out[m.Message.From]++
+	}
+	return out
+}
+
+func TestMpoolEviction(t *testing.T) {
+	tc := newTestChainWithAccounts(t, 3)
+	local, a, b := tc.accounts[0], tc.accounts[1], tc.accounts[2]
+
+	mp := NewMessagePool(tc.cs, tc.localWallet(t, local))
+	mp.MaxPending = 2
+
+	for _, m := range []*SignedMessage{
+		tc.testMsg(t, local, 0, 1),
+		tc.testMsg(t, a, 0, 1),
+	} {
+		if err := mp.Add(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// not paying more than the cheapest message doesn't get into a full pool
+	if err := mp.Add(tc.testMsg(t, b, 0, 1)); errors.Cause(err) != ErrMpoolFull {
+		t.Fatalf("expected ErrMpoolFull, got %v", err)
+	}
+
+	// paying more evicts the message from a, ours are never evicted
+	if err := mp.Add(tc.testMsg(t, b, 0, 2)); err != nil {
+		t.Fatal(err)
+	}
+	if p := pendingFrom(mp); p[local] != 1 || p[a] != 0 || p[b] != 1 {
+		t.Fatalf("unexpected pending messages %v", p)
+	}
+
+	// our own messages get in over the limit
+	if err := mp.Add(tc.testMsg(t, local, 1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if p := pendingFrom(mp); p[local] != 2 || p[b] != 1 {
+		t.Fatalf("unexpected pending messages %v", p)
+	}
+}
+
+// stallingSigner is a remote signer whose List blocks until release is
+// closed
+type stallingSigner struct {
+	addrs   []address.Address
+	listing chan struct{}
+	release chan struct{}
+}
+
+func (s *stallingSigner) List(ctx context.Context) ([]address.Address, error) {
+	s.listing <- struct{}{}
+	<-s.release
+	return s.addrs, nil
+}
+
+func (s *stallingSigner) Sign(context.Context, address.Address, []byte) (*Signature, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func TestMpoolLocalityCheckedOutsideLock(t *testing.T) {
+	tc := newTestChainWithAccounts(t, 1)
+
+	rs := &stallingSigner{
+		addrs:   tc.accounts,
+		listing: make(chan struct{}),
+		release: make(chan struct{}),
+	}
+	w, err := NewRemoteWallet(NewMemKeystore(), rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// with a full pool, only messages from our keys get in
+	mp := NewMessagePool(tc.cs, w)
+	mp.MaxPending = 0
+
+	m := tc.testMsg(t, tc.accounts[0], 0, 1)
+	added := make(chan error, 1)
+	go func() {
+		added <- mp.Add(m)
+	}()
+
+	// while Add waits for the remote signer the pool stays usable
+	<-rs.listing
+	pending := make(chan int, 1)
+	go func() {
+		pending <- len(mp.Pending())
+	}()
+	select {
+	case <-pending:
+	case <-time.After(10 * time.Second):
+		t.Fatal("pool locked while listing remote signer keys")
+	}
+
+	close(rs.release)
+	if err := <-added; err != nil {
+		t.Fatal(err)
+	}
+	if len(mp.Pending()) != 1 {
+		t.Fatal("message not added")
+	}
+}
+
+func TestMpoolRejectsInvalid(t *testing.T) {
+	tc := newTestChainWithAccounts(t, 1)
+	from := tc.accounts[0]
+	mp := NewMessagePool(tc.cs, nil)
+
+	nobody, err := tc.w.GenerateKey(KTSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, err := address.NewIDAddress(100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := func() *Message {
+		return &Message{
+			From: from, To: NetworkAddress, Nonce: 0,
+			Value: NewInt(1), GasPrice: NewInt(1), GasLimit: NewInt(1000),
+		}
+	}
+
+	for _, tcase := range []struct {
+		name   string
+		modify func(*Message)
+		err    error
+	}{
+		{"empty to", func(m *Message) { m.To = address.Undef }, ErrInvalidToAddr},
+		{"empty from", func(m *Message) { m.From = address.Undef }, ErrInvalidFromAddr},
+		{"id from", func(m *Message) { m.From = id }, ErrInvalidFromAddr},
+		{"no value", func(m *Message) { m.Value = BigInt{} }, ErrInvalidValue},
+		{"negative value", func(m *Message) { m.Value = BigSub(NewInt(0), NewInt(1)) }, ErrInvalidValue},
+		{"no gas price", func(m *Message) { m.GasPrice = BigInt{} }, ErrInvalidGas},
+		{"negative gas price", func(m *Message) { m.GasPrice = BigSub(NewInt(0), NewInt(1)) }, ErrInvalidGas},
+		{"no gas limit", func(m *Message) { m.GasLimit = BigInt{} }, ErrInvalidGas},
+		{"zero gas limit", func(m *Message) { m.GasLimit = NewInt(0) }, ErrInvalidGas},
+		{"negative gas limit", func(m *Message) { m.GasLimit = BigSub(NewInt(0), NewInt(1)) }, ErrInvalidGas},
+		{"gas limit below inclusion cost", func(m *Message) { m.GasLimit = NewInt(onChainMessageGas(m) / 2) }, ErrInvalidGas},
+		{"unknown sender", func(m *Message) { m.From = nobody }, ErrSenderNotFound},
+		{"nonce gap", func(m *Message) { m.Nonce = MaxNonceGap + 1 }, ErrNonceGap},
+		{"not enough funds", func(m *Message) { m.Value = NewInt(1000000) }, ErrNotEnoughFunds},
+	} {
+		t.Run(tcase.name, func(t *testing.T) {
+			msg := valid()
+			tcase.modify(msg)
+
+			// the signature is made with the sender's key if it has one
+			sm := &SignedMessage{Message: *msg}
+			if tc.w.HasKey(msg.From) {
+				sm = tc.sign(t, msg)
+			}
+
+			if err := mp.Add(sm); errors.Cause(err) != tcase.err {
+				t.Fatalf("expected %v, got %v", tcase.err, err)
+			}
+		})
+	}
+
+	t.Run("bad signature", func(t *testing.T) {
+		sm := tc.sign(t, valid())
+		sm.Message.Value = NewInt(2)
+		if err := mp.Add(sm); errors.Cause(err) != ErrInvalidSignature {
+			t.Fatalf("expected ErrInvalidSignature, got %v", err)
+		}
+	})
+
+	if len(mp.Pending()) != 0 {
+		t.Fatal("invalid messages were added")
+	}
+	if err := mp.Add(tc.sign(t, valid())); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMpoolReplaceByFee(t *testing.T) {
+	tc := newTestChainWithAccounts(t, 1)
+	from := tc.accounts[0]
+	mp := NewMessagePool(tc.cs, nil)
+
+	if err := mp.Add(tc.testMsg(t, from, 0, 4)); err != nil {
+		t.Fatal(err)
+	}
+
+	// 4 * 125% = 5 is the lowest price which replaces it
+	if err := mp.Add(tc.testMsg(t, from, 0, 4)); err != nil {
+		t.Fatalf("re-adding the same message: %s", err)
+	}
+	low := tc.sign(t, &Message{
+		From: from, To: NetworkAddress, Nonce: 0,
+		Value: NewInt(2), GasPrice: NewInt(4), GasLimit: NewInt(1000),
+	})
+	if err := mp.Add(low); errors.Cause(err) != ErrReplaceByFeeTooLow {
+		t.Fatalf("expected ErrReplaceByFeeTooLow, got %v", err)
+	}
+
+	high := tc.testMsg(t, from, 0, 5)
+	if err := mp.Add(high); err != nil {
+		t.Fatal(err)
+	}
+
+	pending := mp.Pending()
+	if len(pending) != 1 || pending[0].Cid() != high.Cid() {
+		t.Fatalf("expected only the replacement to be pending, got %d messages", len(pending))
+	}
+}
+
+func TestMpoolNonceGap(t *testing.T) {
+	tc := newTestChainWithAccounts(t, 1)
+	from := tc.accounts[0]
+	mp := NewMessagePool(tc.cs, nil)
+
+	// messages may be up to MaxNonceGap ahead of the pending nonce, which
+	// messages after a gap don't move
+	if err := mp.Add(tc.testMsg(t, from, MaxNonceGap, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Add(tc.testMsg(t, from, MaxNonceGap+1, 1)); errors.Cause(err) != ErrNonceGap {
+		t.Fatalf("expected ErrNonceGap, got %v", err)
+	}
+	checkNonce := func(expected uint64) {
+		t.Helper()
+		nonce, err := mp.GetNonce(from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nonce != expected {
+			t.Fatalf("pending nonce is %d, expected %d", nonce, expected)
+		}
+	}
+	checkNonce(0)
+
+	// filling the gap moves it past the message after the gap
+	for i := uint64(0); i < MaxNonceGap; i++ {
+		if err := mp.Add(tc.testMsg(t, from, i, 1)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	checkNonce(MaxNonceGap + 1)
+
+	if err := mp.Add(tc.testMsg(t, from, 2*MaxNonceGap+1, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := mp.Add(tc.testMsg(t, from, 2*MaxNonceGap+2, 1)); errors.Cause(err) != ErrNonceGap {
+		t.Fatalf("expected ErrNonceGap, got %v", err)
+	}
+	checkNonce(MaxNonceGap + 1)
+}
+
+func TestMpoolRevalidateDropsLaterNonces(t *testing.T) {
+	tc := newTestChainWithAccounts(t, 1)
+	from := tc.accounts[0]
+	mp := NewMessagePool(tc.cs, nil)
+
+	big := tc.sign(t, &Message{
+		From: from, To: NetworkAddress, Nonce: 1,
+		Value: NewInt(900000), GasPrice: NewInt(1), GasLimit: NewInt(1000),
+	})
+	for _, m := range []*SignedMessage{tc.testMsg(t, from, 0, 1), big, tc.testMsg(t, from, 2, 1)} {
+		if err := mp.Add(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the sender's balance drops so only the first message is affordable
+	st, err := mp.stateAt(tc.cs.GetHeaviestTipSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	act, err := st.GetActor(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	act.Balance = NewInt(5000)
+	if err := st.SetActor(from, act); err != nil {
+		t.Fatal(err)
+	}
+
+	mp.lk.Lock()
+	defer mp.lk.Unlock()
+	mp.revalidate(st)
+
+	// Pending stops at gaps, so look at the sender's set directly
+	mset := mp.pending[from]
+	if _, ok := mset.msgs[0]; !ok || len(mset.msgs) != 1 || mp.size != 1 {
+		t.Fatalf("expected only nonce 0 to be pending, got %d messages", len(mset.msgs))
+	}
+}
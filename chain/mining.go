@@ -3,36 +3,51 @@ package chain
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	cid "github.com/ipfs/go-cid"
 	hamt "github.com/ipfs/go-hamt-ipld"
 	"github.com/pkg/errors"
 	sharray "github.com/whyrusleeping/sharray"
+	"go.opencensus.io/stats"
 
+	"github.com/zgfzgf/mid-lotus/build"
 	"github.com/zgfzgf/mid-lotus/chain/address"
 	bls "github.com/zgfzgf/mid-lotus/lib/bls-signatures"
+	"github.com/zgfzgf/mid-lotus/metrics"
 )
 
 type Miner struct {
 	cs         *ChainStore
 	newBlockCB func(*FullBlock)
 
-	maddr address.Address
-	mpool *MessagePool
-
-	Delay time.Duration
+	maddr  address.Address
+	mpool  *MessagePool
+	wallet *Wallet
 
 	candidate *MiningBase
+
+	lk             sync.Mutex
+	cancel         context.CancelFunc
+	done           chan struct{}
+	mined          uint64
+	orphansAvoided uint64
+
+	// roundLk protects the state of the round being mined, so it can be
+	// cancelled when the head changes
+	roundLk     sync.Mutex
+	roundBase   *TipSet
+	roundCancel context.CancelFunc
 }
 
-func NewMiner(cs *ChainStore, maddr address.Address, mpool *MessagePool, newBlockCB func(*FullBlock)) *Miner {
+func NewMiner(cs *ChainStore, w *Wallet, maddr address.Address, mpool *MessagePool, newBlockCB func(*FullBlock)) *Miner {
 	return &Miner{
 		cs:         cs,
 		newBlockCB: newBlockCB,
 		maddr:      maddr,
 		mpool:      mpool,
-		Delay:      time.Second * 2,
+		wallet:     w,
 	}
 }
 
@@ -41,15 +56,164 @@ type MiningBase struct {
 	tickets []Ticket
 }
 
+// height returns the height of the block mined on the base in this round
+func (mb *MiningBase) height() uint64 {
+	return mb.ts.Height() + uint64(len(mb.tickets)) + 1
+}
+
+// lastTicket returns the ticket the next one is computed from
+func (mb *MiningBase) lastTicket() Ticket {
+	if len(mb.tickets) > 0 {
+		return mb.tickets[len(mb.tickets)-1]
+	}
+	return mb.ts.MinTicket()
+}
+
+// withNullTicket returns the base of the next round, after a round without
+// a block
+func (mb *MiningBase) withNullTicket(ticket Ticket) *MiningBase {
+	tickets := make([]Ticket, len(mb.tickets), len(mb.tickets)+1)
+	copy(tickets, mb.tickets)
+
+	return &MiningBase{
+		ts:      mb.ts,
+		tickets: append(tickets, ticket),
+	}
+}
+
+// Start runs the mining loop in the background. If maddr isn't Undef, the
+// miner switches to mining with that address.
+func (m *Miner) Start(maddr address.Address) error {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	if m.cancel != nil {
+		return fmt.Errorf("miner already running")
+	}
+
+	if maddr != address.Undef {
+		m.maddr = maddr
+	}
+	if m.maddr == address.Undef {
+		return fmt.Errorf("no miner address set")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancel = cancel
+	m.done = make(chan struct{})
+
+	go func(done chan struct{}) {
+		defer close(done)
+		m.Mine(ctx)
+	}(m.done)
+
+	return nil
+}
+
+// Stop stops the mining loop and waits for it to exit
+func (m *Miner) Stop(ctx context.Context) error {
+	m.lk.Lock()
+	cancel, done := m.cancel, m.done
+	m.cancel, m.done = nil, nil
+	m.lk.Unlock()
+
+	if cancel == nil {
+		return fmt.Errorf("miner not running")
+	}
+
+	cancel()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// MinerStatus is a snapshot of the miner's state
+type MinerStatus struct {
+	Running bool
+	Address address.Address
+
+	// BlocksMined and OrphansAvoided count the blocks mined and the rounds
+	// abandoned for a new head since the node started
+	BlocksMined    uint64
+	OrphansAvoided uint64
+}
+
+func (m *Miner) Status() MinerStatus {
+	m.lk.Lock()
+	defer m.lk.Unlock()
+
+	return MinerStatus{
+		Running:        m.cancel != nil,
+		Address:        m.maddr,
+		BlocksMined:    m.mined,
+		OrphansAvoided: m.orphansAvoided,
+	}
+}
+
+// Mine mines blocks until ctx is cancelled. A round is abandoned as soon as
+// the head changes, as the block it would produce would be an orphan.
 func (m *Miner) Mine(ctx context.Context) {
-	log.Error("mining...")
-	defer log.Error("left mining...")
+	log.Info("mining...")
+	defer log.Info("left mining...")
+
+	clock, err := m.cs.Clock()
+	if err != nil {
+		log.Errorf("loading chain clock: %s", err)
+		return
+	}
+
+	heads := m.cs.SubNewTips()
+	go m.watchHeads(ctx, heads)
+	defer m.cs.UnsubNewTips(heads)
+
 	for {
+		if ctx.Err() != nil {
+			return
+		}
+
 		base := m.GetBestMiningCandidate()
 
-		b, err := m.mineOne(ctx, base)
+		roundCtx, cancel := context.WithCancel(ctx)
+		m.roundLk.Lock()
+		m.roundBase, m.roundCancel = base.ts, cancel
+		m.roundLk.Unlock()
+
+		// the head may have moved before the watcher could see this round
+		if !m.cs.GetHeaviestTipSet().Equals(base.ts) {
+			cancel()
+		}
+
+		b, err := m.mineOne(roundCtx, clock, base)
+
+		m.roundLk.Lock()
+		m.roundBase, m.roundCancel = nil, nil
+		m.roundLk.Unlock()
+		aborted := roundCtx.Err() != nil
+		cancel()
+
+		// a block built on a stale base would only be an orphan
+
+		if aborted {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Infof("head changed, restarting mining round")
+			continue
+		}
+
 		if err != nil {
 			log.Error(err)
+
+			// don't spin on errors which persist across rounds
+			select {
+			case <-time.After(build.BlockDelay * time.Second):
+			case <-ctx.Done():
+				return
+			}
 			continue
 		}
 
@@ -59,6 +223,26 @@ func (m *Miner) Mine(ctx context.Context) {
 	}
 }
 
+// watchHeads cancels the current round when the heaviest tipset moves away
+// from its base
+func (m *Miner) watchHeads(ctx context.Context, heads chan interface{}) {
+	for v := range heads {
+		ts := v.(*TipSet)
+
+		m.roundLk.Lock()
+		if m.roundCancel != nil && !ts.Equals(m.roundBase) {
+			m.roundCancel()
+			m.roundCancel = nil
+
+			m.lk.Lock()
+			m.orphansAvoided++
+			m.lk.Unlock()
+			stats.Record(ctx, metrics.MinerOrphansAvoided.M(1))
+		}
+		m.roundLk.Unlock()
+	}
+}
+
 func (m *Miner) GetBestMiningCandidate() *MiningBase {
 	best := m.cs.GetHeaviestTipSet()
 	if m.candidate == nil {
@@ -79,32 +263,69 @@ func (m *Miner) GetBestMiningCandidate() *MiningBase {
 	return m.candidate
 }
 
+// submitNullTicket records a lost round, the next round is mined on the same
+// base with the ticket carried forward
 func (m *Miner) submitNullTicket(base *MiningBase, ticket Ticket) {
-	panic("nyi")
+	m.candidate = base.withNullTicket(ticket)
 }
 
-func (m *Miner) isWinnerNextRound(base *MiningBase) (bool, ElectionProof, error) {
-	return true, []byte("election prooooof"), nil
+// skipMissedRounds carries base forward to the current epoch with a null
+// ticket for each round which is already over, after downtime or a slow
+// sync, so blocks are never mined for past rounds
+func (m *Miner) skipMissedRounds(clock *Clock, mi *MinerInfo, base *MiningBase) (*MiningBase, error) {
+	cur := clock.CurrentEpoch()
+	if base.height() >= cur {
+		return base, nil
+	}
+
+	log.Infof("skipping %d missed rounds", cur-base.height())
+	for base.height() < cur {
+		ticket, err := ComputeVRF(m.wallet, mi.Worker, base.lastTicket())
+		if err != nil {
+			return nil, err
+		}
+		base = base.withNullTicket(ticket)
+	}
+
+	m.candidate = base
+	return base, nil
 }
 
-func (m *Miner) scratchTicket(ctx context.Context, base *MiningBase) (Ticket, error) {
-	select {
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	case <-time.After(m.Delay):
+func (m *Miner) isWinnerNextRound(mi *MinerInfo, totalPower uint64, ticket Ticket) (bool, ElectionProof, error) {
+	proof, err := ComputeVRF(m.wallet, mi.Worker, ticket)
+	if err != nil {
+		return false, nil, errors.Wrap(err, "computing election proof")
+	}
+
+	return IsTicketWinner(proof, mi.Power, totalPower), proof, nil
+}
+
+func (m *Miner) scratchTicket(ctx context.Context, clock *Clock, mi *MinerInfo, base *MiningBase) (Ticket, error) {
+	if err := clock.WaitForEpoch(ctx, base.height()); err != nil {
+		return nil, err
 	}
 
-	return []byte("this is a ticket"), nil
+	return ComputeVRF(m.wallet, mi.Worker, base.lastTicket())
 }
 
-func (m *Miner) mineOne(ctx context.Context, base *MiningBase) (*FullBlock, error) {
+func (m *Miner) mineOne(ctx context.Context, clock *Clock, base *MiningBase) (*FullBlock, error) {
 	log.Info("mine one")
-	ticket, err := m.scratchTicket(ctx, base)
+	mi, totalPower, err := m.cs.MinerPower(base.ts, m.maddr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to get miner power")
+	}
+
+	base, err = m.skipMissedRounds(clock, mi, base)
+	if err != nil {
+		return nil, errors.Wrap(err, "skipping missed rounds")
+	}
+
+	ticket, err := m.scratchTicket(ctx, clock, mi, base)
 	if err != nil {
 		return nil, errors.Wrap(err, "scratching ticket failed")
 	}
 
-	win, proof, err := m.isWinnerNextRound(base)
+	win, proof, err := m.isWinnerNextRound(mi, totalPower, ticket)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to check if we win next round")
 	}
@@ -114,19 +335,28 @@ func (m *Miner) mineOne(ctx context.Context, base *MiningBase) (*FullBlock, erro
 		return nil, nil
 	}
 
-	b, err := m.createBlock(base, ticket, proof)
+	b, err := m.createBlock(base, ticket, proof, clock.EpochStart(base.height()))
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create block")
 	}
-	fmt.Println("created new block:", b.Cid())
+
+	if err := SignBlock(m.wallet, mi.Worker, b.Header); err != nil {
+		return nil, errors.Wrap(err, "failed to sign block")
+	}
+	log.Debugf("created new block: %s", b.Cid())
 
 	return b, nil
 }
 
 func (m *Miner) submitNewBlock(b *FullBlock) {
-	if err := m.cs.AddBlock(b.Header); err != nil {
+	if err := m.cs.PutTipSet(&FullTipSet{Blocks: []*FullBlock{b}}); err != nil {
 		log.Error("failed to add new block to chainstore: ", err)
 	}
+
+	m.lk.Lock()
+	m.mined++
+	m.lk.Unlock()
+
 	m.newBlockCB(b)
 }
 
@@ -134,49 +364,87 @@ func miningRewardForBlock(base *TipSet) BigInt {
 	return NewInt(10000)
 }
 
-func (m *Miner) createBlock(base *MiningBase, ticket Ticket, proof ElectionProof) (*FullBlock, error) {
-	st, err := m.cs.TipSetState(base.ts.Cids())
+func (m *Miner) createBlock(base *MiningBase, ticket Ticket, proof ElectionProof, timestamp uint64) (*FullBlock, error) {
+	tickets := make([]Ticket, 0, len(base.tickets)+1)
+	tickets = append(tickets, base.tickets...)
+	tickets = append(tickets, ticket)
+
+	return MinerCreateBlock(m.cs, m.mpool, m.maddr, base.ts, tickets, proof, timestamp)
+}
+
+// MinerCreateBlock builds a block on top of parents, filled with messages
+// selected from the pool. The tickets are those of the null rounds since
+// parents followed by the block's own ticket. All messages are stored, so
+// the block can be validated from its BlockMsg alone.
+func MinerCreateBlock(cs *ChainStore, mpool *MessagePool, miner address.Address, parents *TipSet, tickets []Ticket, proof ElectionProof, timestamp uint64) (*FullBlock, error) {
+	st, err := cs.TipSetState(parents.Cids())
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to load tipset state")
 	}
 
-	height := base.ts.Height() + uint64(len(base.tickets)) + 1
+	height := parents.Height() + uint64(len(tickets))
 
-	vm, err := NewVM(st, height, m.maddr, m.cs)
+	vm, err := NewVM(st, height, miner, cs)
+	if err != nil {
+		return nil, err
+	}
 
 	// apply miner reward
-	if err := vm.TransferFunds(NetworkAddress, m.maddr, miningRewardForBlock(base.ts)); err != nil {
+	if err := vm.TransferFunds(NetworkAddress, miner, miningRewardForBlock(parents)); err != nil {
 		return nil, err
 	}
 
 	next := &BlockHeader{
-		Miner:   m.maddr,
-		Parents: base.ts.Cids(),
-		Tickets: append(base.tickets, ticket),
-		Height:  height,
+		Miner:         miner,
+		Parents:       parents.Cids(),
+		Tickets:       tickets,
+		ElectionProof: proof,
+		Height:        height,
+		Timestamp:     timestamp,
 	}
 
-	pending := m.mpool.Pending()
-	fmt.Printf("adding %d messages to block...", len(pending))
-	var msgCids []cid.Cid
+	pending, err := mpool.SelectMessages(parents)
+	if err != nil {
+		return nil, errors.Wrap(err, "selecting messages failed")
+	}
+	log.Debugf("adding %d messages to block", len(pending))
+	var blsMessages []*Message
+	var secpkMessages []*SignedMessage
+	var blsMsgCids, secpkMsgCids []cid.Cid
 	var blsSigs []Signature
-	var receipts []interface{}
 	for _, msg := range pending {
-		if msg.Signature.TypeCode() == 2 {
+		if msg.Signature.Type == KTBLS {
 			blsSigs = append(blsSigs, msg.Signature)
+			blsMessages = append(blsMessages, &msg.Message)
 
-			blk, err := msg.Message.ToStorageBlock()
-			if err != nil {
+			// BLS messages are included without their signature, store
+			// them so they can be fetched by cid
+			if err := cs.PutMessage(&msg.Message); err != nil {
 				return nil, err
 			}
-			if err := m.cs.bs.Put(blk); err != nil {
+
+			blsMsgCids = append(blsMsgCids, msg.Message.Cid())
+		} else {
+			if err := cs.PutMessage(msg); err != nil {
 				return nil, err
 			}
 
-			msgCids = append(msgCids, blk.Cid())
-		} else {
-			msgCids = append(msgCids, msg.Cid())
+			secpkMessages = append(secpkMessages, msg)
+			secpkMsgCids = append(secpkMsgCids, msg.Cid())
 		}
+	}
+
+	// BLS messages are applied first, see ValidateBlock
+	var receipts []interface{}
+	for _, msg := range blsMessages {
+		rec, err := vm.ApplyMessage(msg)
+		if err != nil {
+			return nil, errors.Wrap(err, "apply message failure")
+		}
+
+		receipts = append(receipts, rec)
+	}
+	for _, msg := range secpkMessages {
 		rec, err := vm.ApplyMessage(&msg.Message)
 		if err != nil {
 			return nil, errors.Wrap(err, "apply message failure")
@@ -185,8 +453,12 @@ func (m *Miner) createBlock(base *MiningBase, ticket Ticket, proof ElectionProof
 		receipts = append(receipts, rec)
 	}
 
-	cst := hamt.CSTFromBstore(m.cs.bs)
-	msgroot, err := sharray.Build(context.TODO(), 4, toIfArr(msgCids), cst)
+	if _, err := vm.ApplyCronTick(); err != nil {
+		return nil, err
+	}
+
+	cst := hamt.CSTFromBstore(cs.bs)
+	msgroot, err := computeMsgMeta(cst, blsMsgCids, secpkMsgCids)
 	if err != nil {
 		return nil, err
 	}
@@ -210,18 +482,40 @@ func (m *Miner) createBlock(base *MiningBase, ticket Ticket, proof ElectionProof
 
 	next.BLSAggregate = aggSig
 	next.StateRoot = stateRoot
-	pweight := m.cs.Weight(base.ts)
+	pweight := cs.Weight(parents)
 	next.ParentWeight = NewInt(pweight)
 
 	fullBlock := &FullBlock{
-		Header:   next,
-		Messages: pending,
+		Header:        next,
+		BlsMessages:   blsMessages,
+		SecpkMessages: secpkMessages,
 	}
 
 	return fullBlock, nil
 }
 
+// SignBlock sets the block signature, made with the worker key held in the
+// wallet
+func SignBlock(w *Wallet, worker address.Address, h *BlockHeader) error {
+	data, err := h.SigningBytes()
+	if err != nil {
+		return err
+	}
+
+	sig, err := w.Sign(worker, data)
+	if err != nil {
+		return err
+	}
+
+	h.BlockSig = *sig
+	return nil
+}
+
 func aggregateSignatures(sigs []Signature) (Signature, error) {
+	if len(sigs) == 0 {
+		return Signature{Type: KTBLS}, nil
+	}
+
 	var blsSigs []bls.Signature
 	for _, s := range sigs {
 		var bsig bls.Signature
@@ -0,0 +1,173 @@
+package chain
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"go.opencensus.io/stats/view"
+
+	"github.com/zgfzgf/mid-lotus/build"
+	"github.com/zgfzgf/mid-lotus/metrics"
+)
+
+// mineBlock mines a block on parents with the genesis miner after the given
+// number of null rounds. The block holds the messages selected from mp, if
+// mp isn't nil.
+func (tc *testChain) mineBlock(t *testing.T, parents *TipSet, nullRounds int, mp *MessagePool) *FullBlock {
+	t.Helper()
+
+	mi, _, err := tc.cs.MinerPower(parents, tc.miner)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock, err := tc.cs.Clock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prev := parents.MinTicket()
+	var tickets []Ticket
+	for i := 0; i <= nullRounds; i++ {
+		ticket, err := ComputeVRF(tc.w, mi.Worker, prev)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tickets = append(tickets, ticket)
+		prev = ticket
+	}
+
+	proof, err := ComputeVRF(tc.w, mi.Worker, prev)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mp == nil {
+		mp = NewMessagePool(tc.cs, tc.localWallet(t))
+	}
+
+	height := parents.Height() + uint64(len(tickets))
+	b, err := MinerCreateBlock(tc.cs, mp, tc.miner, parents, tickets, proof, clock.EpochStart(height))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := SignBlock(tc.w, mi.Worker, b.Header); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// mineChain mines a block on the heaviest tipset for each entry of
+// nullRounds, after that many null rounds, and puts it in the chain store
+func (tc *testChain) mineChain(t *testing.T, mp *MessagePool, nullRounds ...int) []*FullBlock {
+	t.Helper()
+
+	var out []*FullBlock
+	for _, n := range nullRounds {
+		b := tc.mineBlock(t, tc.cs.GetHeaviestTipSet(), n, mp)
+		if err := tc.cs.PutTipSet(&FullTipSet{Blocks: []*FullBlock{b}}); err != nil {
+			t.Fatal(err)
+		}
+		out = append(out, b)
+	}
+	return out
+}
+
+// testSyncer returns a syncer validating blocks against the test chain
+func (tc *testChain) testSyncer(t *testing.T) *Syncer {
+	t.Helper()
+
+	syncer, err := NewSyncer(tc.cs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return syncer
+}
+
+func TestMineOneSkipsMissedRounds(t *testing.T) {
+	tc := newTestChain(t)
+	m := NewMiner(tc.cs, tc.w, tc.miner, NewMessagePool(tc.cs, tc.localWallet(t)), nil)
+
+	clock, err := tc.cs.Clock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the chain started testGenesisEpochs ago, and nothing was mined since
+	gen := tc.cs.GetHeaviestTipSet()
+	b, err := m.mineOne(context.Background(), clock, &MiningBase{ts: gen})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b == nil {
+		t.Fatal("the only miner lost the round")
+	}
+
+	h := b.Header
+	if h.Height < testGenesisEpochs {
+		t.Fatalf("mined a block at height %d for a round which is over", h.Height)
+	}
+	if err := clock.CheckRecent(h.Height); err != nil {
+		t.Fatal(err)
+	}
+	if now := uint64(time.Now().Unix()); h.Timestamp+build.BlockDelay < now {
+		t.Fatalf("block timestamp %d is in the past (now %d)", h.Timestamp, now)
+	}
+
+	if err := tc.testSyncer(t).ValidateBlock(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatchHeadsCancelsRound(t *testing.T) {
+	tc := newTestChain(t)
+	m := NewMiner(tc.cs, tc.w, tc.miner, nil, nil)
+
+	if err := view.Register(metrics.MinerOrphansAvoidedView); err != nil {
+		t.Fatal(err)
+	}
+	defer view.Unregister(metrics.MinerOrphansAvoidedView)
+
+	gen := tc.cs.GetHeaviestTipSet()
+	b := tc.mineBlock(t, gen, 0, nil)
+	next, err := NewTipSet([]*BlockHeader{b.Header})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roundCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.roundBase, m.roundCancel = gen, cancel
+
+	heads := make(chan interface{})
+	done := make(chan struct{})
+	go func() {
+		m.watchHeads(context.Background(), heads)
+		close(done)
+	}()
+
+	// the round's own base doesn't stop it
+	heads <- gen
+	// a new head does, and is only counted once per round
+	heads <- next
+	heads <- next
+	close(heads)
+	<-done
+
+	if roundCtx.Err() == nil {
+		t.Fatal("round wasn't cancelled when the head changed")
+	}
+	if n := m.Status().OrphansAvoided; n != 1 {
+		t.Fatalf("counted %d orphans avoided, expected 1", n)
+	}
+
+	rows, err := view.RetrieveData(metrics.MinerOrphansAvoidedView.Name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 1 || rows[0].Data.(*view.SumData).Value != 1 {
+		t.Fatalf("recorded %v, expected a single orphan avoided", rows)
+	}
+}
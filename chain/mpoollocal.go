@@ -0,0 +1,174 @@
+package chain
+
+import (
+	"time"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/query"
+	"github.com/pkg/errors"
+)
+
+// RepublishInterval is how often locally submitted messages which are still
+// pending are broadcast again
+const RepublishInterval = 30 * time.Second
+
+var localMsgsPrefix = datastore.NewKey("/mpool/local")
+
+func localMsgKey(c cid.Cid) datastore.Key {
+	return localMsgsPrefix.ChildString(c.String())
+}
+
+// AddLocal adds a message submitted through this node to the pool. Local
+// messages are persisted in the datastore so they survive restarts, and are
+// republished until they are included in the chain or become invalid.
+func (mp *MessagePool) AddLocal(m *SignedMessage) error {
+	if err := mp.Add(m); err != nil {
+		return err
+	}
+
+	data, err := m.Serialize()
+	if err != nil {
+		return err
+	}
+
+	mp.lk.Lock()
+	defer mp.lk.Unlock()
+
+	// the message may already be gone if a head change raced with us
+	mset, ok := mp.pending[m.Message.From]
+	if !ok {
+		return nil
+	}
+	if cur, ok := mset.msgs[m.Message.Nonce]; !ok || cur.Cid() != m.Cid() {
+		return nil
+	}
+
+	if err := mp.cs.ds.Put(localMsgKey(m.Cid()), data); err != nil {
+		return errors.Wrap(err, "persisting local message")
+	}
+
+	mp.localMsgs[m.Cid()] = m
+	return nil
+}
+
+// LoadLocal adds the persisted local messages back into the pool. The chain
+// head isn't persisted, so messages which can't be added against the current
+// head are kept and retried on every head change. Messages are only dropped
+// from the datastore once they're included or can never be applied.
+func (mp *MessagePool) LoadLocal() error {
+	res, err := mp.cs.ds.Query(query.Query{Prefix: localMsgsPrefix.String()})
+	if err != nil {
+		return errors.Wrap(err, "querying local messages")
+	}
+
+	entries, err := res.Rest()
+	if err != nil {
+		return errors.Wrap(err, "querying local messages")
+	}
+
+	for _, e := range entries {
+		m, err := DecodeSignedMessage(e.Value)
+		if err != nil {
+			return errors.Wrapf(err, "decoding local message %s", e.Key)
+		}
+
+		err = mp.Add(m)
+
+		mp.lk.Lock()
+		switch {
+		case err == nil:
+			mp.localMsgs[m.Cid()] = m
+		case isPermanentErr(err):
+			log.Infof("dropping local message %s: %s", m.Cid(), err)
+			if err := mp.cs.ds.Delete(datastore.NewKey(e.Key)); err != nil {
+				mp.lk.Unlock()
+				return err
+			}
+		default:
+			log.Infof("deferring local message %s: %s", m.Cid(), err)
+			mp.localDeferred[m.Cid()] = m
+		}
+		mp.lk.Unlock()
+	}
+
+	return nil
+}
+
+// isPermanentErr says whether a message rejected with err can never be added
+// to the pool, whatever the chain head
+func isPermanentErr(err error) bool {
+	switch errors.Cause(err) {
+	case ErrInvalidToAddr, ErrInvalidFromAddr, ErrInvalidValue, ErrInvalidGas,
+		ErrInvalidSignature, ErrNonceTooLow:
+		return true
+	default:
+		return false
+	}
+}
+
+// deferLocal keeps a local message which left the pool without being
+// included, so it's retried on the next head change. It must be called with
+// the pool lock held.
+func (mp *MessagePool) deferLocal(m *SignedMessage) {
+	if _, ok := mp.localMsgs[m.Cid()]; !ok {
+		return
+	}
+
+	delete(mp.localMsgs, m.Cid())
+	mp.localDeferred[m.Cid()] = m
+}
+
+// retryDeferred adds the deferred local messages which are valid against st
+// back into the pool, and drops the ones which were included or can never be
+// applied. It must be called with the pool lock held.
+func (mp *MessagePool) retryDeferred(st *StateTree, included map[cid.Cid]bool) {
+	for c, m := range mp.localDeferred {
+		if included[c] || included[m.Message.Cid()] {
+			mp.dropDeferred(m)
+			continue
+		}
+
+		err := mp.add(m, st, true)
+		switch {
+		case err == nil:
+			delete(mp.localDeferred, c)
+			mp.localMsgs[c] = m
+		case isPermanentErr(err):
+			log.Infof("dropping local message %s: %s", c, err)
+			mp.dropDeferred(m)
+		}
+	}
+}
+
+func (mp *MessagePool) dropDeferred(m *SignedMessage) {
+	delete(mp.localDeferred, m.Cid())
+	if err := mp.cs.ds.Delete(localMsgKey(m.Cid())); err != nil {
+		log.Errorf("removing local message %s from datastore: %s", m.Cid(), err)
+	}
+}
+
+// LocalPending returns the local messages which are still in the pool
+func (mp *MessagePool) LocalPending() []*SignedMessage {
+	mp.lk.Lock()
+	defer mp.lk.Unlock()
+
+	out := make([]*SignedMessage, 0, len(mp.localMsgs))
+	for _, m := range mp.localMsgs {
+		out = append(out, m)
+	}
+	return out
+}
+
+// forgetLocal stops tracking m as a local message, it must be called with
+// the pool lock held
+func (mp *MessagePool) forgetLocal(m *SignedMessage) {
+	if _, ok := mp.localMsgs[m.Cid()]; !ok {
+		return
+	}
+
+	delete(mp.localMsgs, m.Cid())
+	if err := mp.cs.ds.Delete(localMsgKey(m.Cid())); err != nil {
+		log.Errorf("removing local message %s from datastore: %s", m.Cid(), err)
+	}
+}
@@ -0,0 +1,111 @@
+package chain
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+)
+
+func hasLocalEntry(t *testing.T, cs *ChainStore, m *SignedMessage) bool {
+	t.Helper()
+
+	has, err := cs.ds.Has(localMsgKey(m.Cid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return has
+}
+
+func TestMpoolLocalSurvivesRestart(t *testing.T) {
+	tc := newTestChain(t)
+
+	// the sender only exists after the first block
+	sender, err := tc.w.GenerateKey(KTSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mp := NewMessagePool(tc.cs, tc.localWallet(t, sender))
+	fund := tc.sign(t, &Message{
+		From: tc.miner, To: sender, Nonce: 0,
+		Value: NewInt(100000), GasPrice: NewInt(1), GasLimit: NewInt(1000),
+	})
+	if err := mp.Add(fund); err != nil {
+		t.Fatal(err)
+	}
+	b1 := tc.mineChain(t, mp, 0)[0]
+
+	msg := tc.testMsg(t, sender, 0, 1)
+	if err := mp.AddLocal(msg); err != nil {
+		t.Fatal(err)
+	}
+
+	// restart over the same stores, the new chain store starts at genesis
+	gen, err := tc.cs.GetGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs := NewChainStore(tc.cs.bs, tc.cs.ds.(datastore.Batching))
+	if err := cs.SetGenesis(gen); err != nil {
+		t.Fatal(err)
+	}
+
+	restarted := NewMessagePool(cs, tc.localWallet(t, sender))
+	if err := restarted.LoadLocal(); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(restarted.Pending()); n != 0 {
+		t.Fatalf("%d messages pending at genesis, the sender doesn't exist yet", n)
+	}
+	if !hasLocalEntry(t, cs, msg) {
+		t.Fatal("local message dropped from the datastore")
+	}
+
+	// syncing the block creating the sender brings the message back
+	if err := cs.PutTipSet(&FullTipSet{Blocks: []*FullBlock{b1}}); err != nil {
+		t.Fatal(err)
+	}
+	if p := restarted.LocalPending(); len(p) != 1 || p[0].Cid() != msg.Cid() {
+		t.Fatalf("local messages %v after sync, expected the persisted one", p)
+	}
+
+	// and it's forgotten once included
+	b2 := tc.mineChain(t, mp, 0)[0]
+	if len(b2.SecpkMessages) != 1 {
+		t.Fatalf("block has %d messages, expected 1", len(b2.SecpkMessages))
+	}
+	if err := cs.PutTipSet(&FullTipSet{Blocks: []*FullBlock{b2}}); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(restarted.LocalPending()); n != 0 {
+		t.Fatalf("%d local messages left after inclusion", n)
+	}
+	if hasLocalEntry(t, cs, msg) {
+		t.Fatal("included local message still in the datastore")
+	}
+}
+
+func TestMpoolLoadLocalDropsInvalid(t *testing.T) {
+	tc := newTestChainWithAccounts(t, 1)
+
+	bad := tc.testMsg(t, tc.accounts[0], 0, 1)
+	bad.Signature.Data = append([]byte{}, bad.Signature.Data...)
+	bad.Signature.Data[0] ^= 0xff
+
+	data, err := bad.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tc.cs.ds.Put(localMsgKey(bad.Cid()), data); err != nil {
+		t.Fatal(err)
+	}
+
+	mp := NewMessagePool(tc.cs, tc.localWallet(t, tc.accounts[0]))
+	if err := mp.LoadLocal(); err != nil {
+		t.Fatal(err)
+	}
+	if hasLocalEntry(t, tc.cs, bad) {
+		t.Fatal("message with a bad signature kept in the datastore")
+	}
+
+}
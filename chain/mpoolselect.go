@@ -0,0 +1,198 @@
+package chain
+
+import (
+	"bytes"
+	"container/heap"
+	"sort"
+
+	"github.com/zgfzgf/mid-lotus/chain/address"
+	"github.com/zgfzgf/mid-lotus/chain/aerrors"
+
+	hamt "github.com/ipfs/go-hamt-ipld"
+	"github.com/pkg/errors"
+)
+
+const (
+	// DefaultBlockGasLimit is the default total gas limit of the messages
+	// selected for a block
+	DefaultBlockGasLimit = 100000000
+
+	// DefaultBlockSizeLimit is the default total serialized size, in bytes,
+	// of the messages selected for a block
+	DefaultBlockSizeLimit = 1 << 20
+)
+
+// msgChain is the sequence of pending messages from a single sender which can
+// be applied on top of the sender's current state nonce
+type msgChain struct {
+	from address.Address
+	msgs []*SignedMessage
+}
+
+func (mc *msgChain) head() *SignedMessage {
+	return mc.msgs[0]
+}
+
+// chainHeap orders sender chains by the gas price of their next message
+type chainHeap []*msgChain
+
+func (h chainHeap) Len() int { return len(h) }
+
+func (h chainHeap) Less(i, j int) bool {
+	c := BigCmp(h[i].head().Message.GasPrice, h[j].head().Message.GasPrice)
+	if c != 0 {
+		return c > 0
+	}
+	// keep the selection deterministic for equally priced messages
+	return bytes.Compare(h[i].from.Bytes(), h[j].from.Bytes()) < 0
+}
+
+func (h chainHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *chainHeap) Push(x interface{}) {
+	*h = append(*h, x.(*msgChain))
+}
+
+func (h *chainHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	x := old[n-1]
+	*h = old[:n-1]
+	return x
+}
+
+// SelectMessages picks the pending messages to include in a block mined on
+// top of ts. Messages are taken greedily by gas price, keeping each sender's
+// messages in nonce order, until the block gas or size limit is reached.
+// Messages which would fail when applied on top of ts are skipped along with
+// all later messages from the same sender.
+func (mp *MessagePool) SelectMessages(ts *TipSet) ([]*SignedMessage, error) {
+	st, err := mp.cs.TipSetState(ts.Cids())
+	if err != nil {
+		return nil, errors.Wrap(err, "loading tipset state")
+	}
+
+	// Gas rewards aren't interesting here, credit them to the network actor
+	// so that selection doesn't depend on which miner creates the block.
+	vm, err := NewVM(st, ts.Height()+1, NetworkAddress, mp.cs)
+	if err != nil {
+		return nil, err
+	}
+
+	chains, err := mp.pendingChains(vm.cstate)
+	if err != nil {
+		return nil, err
+	}
+
+	h := chainHeap(chains)
+	heap.Init(&h)
+
+	gasLeft := mp.BlockGasLimit
+	sizeLeft := mp.BlockSizeLimit
+
+	var out []*SignedMessage
+	for h.Len() > 0 {
+		mc := heap.Pop(&h).(*msgChain)
+		m := mc.head()
+
+		if BigCmp(m.Message.GasLimit, gasLeft) > 0 {
+			continue
+		}
+
+		data, err := m.Serialize()
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > sizeLeft {
+			continue
+		}
+
+		ok, err := vm.tryApply(&m.Message)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
+		}
+
+		out = append(out, m)
+		gasLeft = BigSub(gasLeft, m.Message.GasLimit)
+		sizeLeft -= len(data)
+
+		mc.msgs = mc.msgs[1:]
+		if len(mc.msgs) > 0 {
+			heap.Push(&h, mc)
+		}
+	}
+
+	return out, nil
+}
+
+// pendingChains returns, for each sender, the pending messages with
+// consecutive nonces starting at the sender's nonce in st
+func (mp *MessagePool) pendingChains(st *StateTree) ([]*msgChain, error) {
+	mp.lk.Lock()
+	defer mp.lk.Unlock()
+
+	var out []*msgChain
+	for from, mset := range mp.pending {
+		act, err := st.GetActor(from)
+		if err != nil {
+			if err == ErrActorNotFound {
+				continue
+			}
+			return nil, err
+		}
+
+		mc := &msgChain{from: from}
+		for nonce := act.Nonce; ; nonce++ {
+			m, ok := mset.msgs[nonce]
+			if !ok {
+				break
+			}
+			mc.msgs = append(mc.msgs, m)
+		}
+
+		if len(mc.msgs) > 0 {
+			out = append(out, mc)
+		}
+	}
+
+	// map iteration order is random, sort so that heap ties break the same
+	// way every time
+	sort.Slice(out, func(i, j int) bool {
+		return bytes.Compare(out[i].from.Bytes(), out[j].from.Bytes()) < 0
+	})
+
+	return out, nil
+}
+
+// tryApply applies msg and keeps the resulting state only if the message
+// succeeded
+func (vm *VM) tryApply(msg *Message) (bool, error) {
+	root, err := vm.cstate.Flush()
+	if err != nil {
+		return false, errors.Wrap(err, "flushing state failed")
+	}
+
+	rct, err := vm.ApplyMessage(msg)
+	if err != nil {
+		// invalid messages don't change the state
+		if errors.Cause(err) == ErrMessageInvalid {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if rct.ExitCode == aerrors.Ok {
+		return true, nil
+	}
+
+	st, err := LoadStateTree(hamt.CSTFromBstore(vm.buf), root)
+	if err != nil {
+		return false, errors.Wrap(err, "reloading state failed")
+	}
+	vm.cstate = st
+
+	return false, nil
+}
@@ -0,0 +1,98 @@
+package chain
+
+import (
+	"testing"
+)
+
+func selectMessages(t *testing.T, tc *testChain, mp *MessagePool, msgs ...*SignedMessage) []*SignedMessage {
+	t.Helper()
+
+	for _, m := range msgs {
+		if err := mp.Add(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out, err := mp.SelectMessages(tc.cs.GetHeaviestTipSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func expectSelected(t *testing.T, got []*SignedMessage, expect ...*SignedMessage) {
+	t.Helper()
+
+	if len(got) != len(expect) {
+		t.Fatalf("selected %d messages, expected %d", len(got), len(expect))
+	}
+	for i := range expect {
+		if got[i].Cid() != expect[i].Cid() {
+			t.Errorf("message %d: got nonce %d from %s, expected nonce %d from %s", i,
+				got[i].Message.Nonce, got[i].Message.From, expect[i].Message.Nonce, expect[i].Message.From)
+		}
+	}
+}
+
+func TestSelectMessagesOrder(t *testing.T) {
+	tc := newTestChainWithAccounts(t, 2)
+	a, b := tc.accounts[0], tc.accounts[1]
+	mp := NewMessagePool(tc.cs, tc.localWallet(t))
+
+	a0 := tc.testMsg(t, a, 0, 1)
+	a1 := tc.testMsg(t, a, 1, 10)
+	b0 := tc.testMsg(t, b, 0, 5)
+
+	// a's well paying second message can't go before its first one
+	got := selectMessages(t, tc, mp, a1, a0, b0)
+	expectSelected(t, got, b0, a0, a1)
+}
+
+func TestSelectMessagesGasLimit(t *testing.T) {
+	tc := newTestChainWithAccounts(t, 3)
+	mp := NewMessagePool(tc.cs, tc.localWallet(t))
+	mp.BlockGasLimit = NewInt(2500)
+
+	m1 := tc.testMsg(t, tc.accounts[0], 0, 1)
+	m2 := tc.testMsg(t, tc.accounts[1], 0, 2)
+	m3 := tc.testMsg(t, tc.accounts[2], 0, 3)
+
+	// every message has a gas limit of 1000, only the two best paying fit
+	got := selectMessages(t, tc, mp, m1, m2, m3)
+	expectSelected(t, got, m3, m2)
+}
+
+func TestSelectMessagesSizeLimit(t *testing.T) {
+	tc := newTestChainWithAccounts(t, 3)
+	mp := NewMessagePool(tc.cs, tc.localWallet(t))
+
+	m1 := tc.testMsg(t, tc.accounts[0], 0, 1)
+	m2 := tc.testMsg(t, tc.accounts[1], 0, 2)
+	m3 := tc.testMsg(t, tc.accounts[2], 0, 3)
+
+	data, err := m1.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mp.BlockSizeLimit = 2*len(data) + len(data)/2
+
+	got := selectMessages(t, tc, mp, m1, m2, m3)
+	expectSelected(t, got, m3, m2)
+}
+
+func TestSelectMessagesSkipsFailing(t *testing.T) {
+	tc := newTestChainWithAccounts(t, 2)
+	a, b := tc.accounts[0], tc.accounts[1]
+	mp := NewMessagePool(tc.cs, tc.localWallet(t))
+
+	// the network account has no such method, so a0 fails when applied
+	a0 := tc.sign(t, &Message{
+		From: a, To: NetworkAddress, Nonce: 0, Method: 99,
+		Value: NewInt(0), GasPrice: NewInt(10), GasLimit: NewInt(1000),
+	})
+	a1 := tc.testMsg(t, a, 1, 10)
+	b0 := tc.testMsg(t, b, 0, 1)
+
+	got := selectMessages(t, tc, mp, a0, a1, b0)
+	expectSelected(t, got, b0)
+}
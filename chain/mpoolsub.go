@@ -0,0 +1,139 @@
+package chain
+
+import (
+	"context"
+	"fmt"
+)
+
+// MpoolChange is the kind of change in a MpoolUpdate
+type MpoolChange int
+
+const (
+	MpoolAdd MpoolChange = iota
+	MpoolRemove
+)
+
+func (c MpoolChange) String() string {
+	switch c {
+	case MpoolAdd:
+		return "add"
+	case MpoolRemove:
+		return "remove"
+	default:
+		return fmt.Sprintf("MpoolChange(%d)", int(c))
+	}
+}
+
+// MpoolRemoveReason says why a message left the pool
+type MpoolRemoveReason int
+
+const (
+	// RemoveIncluded is used for messages included in the chain
+	RemoveIncluded MpoolRemoveReason = iota + 1
+	// RemoveReplaced is used for messages replaced by one paying a higher fee
+	RemoveReplaced
+	// RemoveEvicted is used for messages evicted to make space in the pool
+	RemoveEvicted
+	// RemoveInvalid is used for messages which can no longer be applied
+	RemoveInvalid
+)
+
+func (r MpoolRemoveReason) String() string {
+	switch r {
+	case RemoveIncluded:
+		return "included"
+	case RemoveReplaced:
+		return "replaced"
+	case RemoveEvicted:
+		return "evicted"
+	case RemoveInvalid:
+		return "invalid"
+	default:
+		return fmt.Sprintf("MpoolRemoveReason(%d)", int(r))
+	}
+}
+
+// MpoolUpdate is a message entering or leaving the pool. Reason is only set
+// for removals.
+type MpoolUpdate struct {
+	Type    MpoolChange
+	Reason  MpoolRemoveReason
+	Message *SignedMessage
+}
+
+// MpoolSubBuffer is how many updates a subscriber can fall behind by before
+// it is dropped
+const MpoolSubBuffer = 256
+
+type mpoolSub struct {
+	ch chan MpoolUpdate
+}
+
+// Updates returns a channel of changes to the pool, which is closed when ctx
+// is cancelled. Updates are never waited on, a subscriber which falls more
+// than MpoolSubBuffer updates behind has its channel closed early.
+func (mp *MessagePool) Updates(ctx context.Context) <-chan MpoolUpdate {
+	sub := &mpoolSub{ch: make(chan MpoolUpdate, MpoolSubBuffer)}
+
+	mp.subLk.Lock()
+	mp.subs[sub] = struct{}{}
+	mp.subLk.Unlock()
+
+	go func() {
+		<-ctx.Done()
+
+		mp.subLk.Lock()
+		defer mp.subLk.Unlock()
+		mp.unsub(sub)
+	}()
+
+	return sub.ch
+}
+
+// unsub must be called with subLk held
+func (mp *MessagePool) unsub(sub *mpoolSub) {
+	if _, ok := mp.subs[sub]; !ok {
+		return
+	}
+	delete(mp.subs, sub)
+	close(sub.ch)
+}
+
+// notify queues a change to the pool, it must be called with the pool lock
+// held so updates are queued in order. Queued updates are delivered by
+// publishUpdates once the lock is released.
+func (mp *MessagePool) notify(typ MpoolChange, reason MpoolRemoveReason, m *SignedMessage) {
+	mp.queueLk.Lock()
+	defer mp.queueLk.Unlock()
+
+	mp.queued = append(mp.queued, MpoolUpdate{
+		Type:    typ,
+		Reason:  reason,
+		Message: m,
+	})
+}
+
+// publishUpdates delivers the queued updates to the subscribers. It must be
+// called without the pool lock held, typically deferred before taking it.
+func (mp *MessagePool) publishUpdates() {
+	// holding subLk while taking the queue keeps concurrent publishers
+	// from delivering updates out of order
+	mp.subLk.Lock()
+	defer mp.subLk.Unlock()
+
+	mp.queueLk.Lock()
+	queued := mp.queued
+	mp.queued = nil
+	mp.queueLk.Unlock()
+
+	for _, u := range queued {
+		for sub := range mp.subs {
+			select {
+			case sub.ch <- u:
+			default:
+				log.Warnf("mpool subscriber fell %d updates behind, dropping it", MpoolSubBuffer)
+				mp.unsub(sub)
+			}
+		}
+	}
+}
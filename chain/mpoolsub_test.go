@@ -0,0 +1,72 @@
+package chain
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMpoolUpdatesSlowSubscriber(t *testing.T) {
+	tc := newTestChain(t)
+	mp := NewMessagePool(tc.cs, tc.w)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// slow never reads, fast reads every update as it's published
+	slow := mp.Updates(ctx)
+	fast := mp.Updates(ctx)
+
+	msgs := make([]*SignedMessage, MpoolSubBuffer+10)
+	for i := range msgs {
+		msgs[i] = tc.sign(t, &Message{
+			From: tc.miner, To: NetworkAddress, Nonce: uint64(i),
+			Value: NewInt(1), GasPrice: NewInt(1), GasLimit: NewInt(1000),
+		})
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		for i, m := range msgs {
+			if err := mp.Add(m); err != nil {
+				done <- err
+				return
+			}
+
+			u := <-fast
+			if u.Type != MpoolAdd || u.Message.Cid() != m.Cid() {
+				t.Errorf("update %d: got %s of nonce %d", i, u.Type, u.Message.Message.Nonce)
+			}
+		}
+		done <- nil
+	}()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("adding messages blocked on a subscriber which doesn't read")
+	}
+
+	// the slow subscriber got a full buffer and was then dropped
+	got := 0
+	for range slow {
+		got++
+	}
+	if got != MpoolSubBuffer {
+		t.Errorf("slow subscriber got %d updates, expected %d", got, MpoolSubBuffer)
+	}
+
+	// cancelling closes the remaining subscriptions
+	cancel()
+	select {
+	case _, ok := <-fast:
+		if ok {
+			t.Error("unexpected update after cancel")
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("subscription not closed after cancel")
+	}
+}
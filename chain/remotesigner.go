@@ -0,0 +1,89 @@
+package chain
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/zgfzgf/mid-lotus/chain/address"
+)
+
+// RemoteSignerCacheTime is how long the addresses of a remote signer are
+// cached for HasKey
+const RemoteSignerCacheTime = 10 * time.Second
+
+// RemoteSignerTimeout bounds each request to a remote signer
+const RemoteSignerTimeout = 10 * time.Second
+
+// RemoteSigner holds keys outside of the node, in a separate signer process
+type RemoteSigner interface {
+	// List returns the addresses the signer will sign for
+	List(context.Context) ([]address.Address, error)
+
+	// Sign signs data with the key of addr
+	Sign(context.Context, address.Address, []byte) (*Signature, error)
+}
+
+// ErrRemoteWallet is returned when creating or importing keys in a wallet
+// backed by a remote signer
+var ErrRemoteWallet = errors.New("keys are held by the remote signer, create or import them with lotus-signer")
+
+// NewRemoteWallet creates a wallet which forwards requests for keys it
+// doesn't hold in the keystore to the remote signer. Keys already in the
+// keystore can still be used, but new keys can't be generated, imported or
+// derived, so no private keys end up on the node's host.
+func NewRemoteWallet(ks Keystore, rs RemoteSigner) (*Wallet, error) {
+	w, err := NewWallet(ks)
+	if err != nil {
+		return nil, err
+	}
+
+	w.remote = rs
+	return w, nil
+}
+
+// IsRemote returns whether the wallet is backed by a remote signer
+func (w *Wallet) IsRemote() bool {
+	return w.remote != nil
+}
+
+func (w *Wallet) remoteSign(addr address.Address, msg []byte) (*Signature, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), RemoteSignerTimeout)
+	defer cancel()
+
+	sig, err := w.remote.Sign(ctx, addr, msg)
+	if err != nil {
+		return nil, errors.Wrap(err, "remote signer")
+	}
+
+	// don't pass on anything we wouldn't accept from the network
+	if err := sig.Verify(addr, msg); err != nil {
+		return nil, errors.Wrap(err, "remote signer returned an invalid signature")
+	}
+
+	return sig, nil
+}
+
+// remoteAddrs lists the addresses of the remote signer, using the cached
+// list if it's recent and cached is set
+func (w *Wallet) remoteAddrs(cached bool) ([]address.Address, error) {
+	w.remoteLk.Lock()
+	defer w.remoteLk.Unlock()
+
+	if cached && time.Since(w.remoteListed) < RemoteSignerCacheTime {
+		return w.remoteCache, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), RemoteSignerTimeout)
+	defer cancel()
+
+	addrs, err := w.remote.List(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing remote signer addresses")
+	}
+
+	w.remoteCache = addrs
+	w.remoteListed = time.Now()
+	return addrs, nil
+}
@@ -0,0 +1,83 @@
+package chain
+
+import (
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/pkg/errors"
+)
+
+// BlockVM sets up a VM on top of the parent state of the given block, with
+// the block reward already applied, ready to apply the block's messages
+func (cs *ChainStore) BlockVM(h *BlockHeader) (*VM, error) {
+	stateroot, err := cs.TipSetState(h.Parents)
+	if err != nil {
+		return nil, errors.Wrap(err, "get tipset state failed")
+	}
+
+	baseTs, err := cs.LoadTipSet(h.Parents)
+	if err != nil {
+		return nil, err
+	}
+
+	vm, err := NewVM(stateroot, h.Height, h.Miner, cs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := vm.TransferFunds(NetworkAddress, h.Miner, miningRewardForBlock(baseTs)); err != nil {
+		return nil, err
+	}
+
+	return vm, nil
+}
+
+// ReplayMessage re-executes the message with the given cid, included in ts,
+// against the parent state of ts, and returns the receipt and execution trace
+func (cs *ChainStore) ReplayMessage(ts *TipSet, mcid cid.Cid) (*Message, *MessageReceipt, *ExecutionTrace, error) {
+	for _, b := range ts.Blocks() {
+		bmsgs, smsgs, err := cs.MessagesForBlock(b)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		// BLS messages are applied first and are referenced by the cid of
+		// the unsigned message, secpk messages by the signed message cid
+		msgs := make([]*Message, 0, len(bmsgs)+len(smsgs))
+		cids := make([]cid.Cid, 0, len(bmsgs)+len(smsgs))
+		for _, m := range bmsgs {
+			msgs = append(msgs, m)
+			cids = append(cids, m.Cid())
+		}
+		for _, m := range smsgs {
+			msgs = append(msgs, &m.Message)
+			cids = append(cids, m.Cid())
+		}
+
+		for i, m := range msgs {
+			if cids[i] != mcid {
+				continue
+			}
+
+			vm, err := cs.BlockVM(b)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+
+			for _, prev := range msgs[:i] {
+				if _, err := vm.ApplyMessage(prev); err != nil {
+					return nil, nil, nil, errors.Wrap(err, "applying preceding message")
+				}
+			}
+
+			rct, trace, err := vm.ApplyMessageTraced(m)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+
+			return m, rct, trace, nil
+		}
+	}
+
+	return nil, nil, nil, fmt.Errorf("message %s not found in tipset", mcid)
+}
@@ -0,0 +1,64 @@
+package chain
+
+import (
+	"context"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	hamt "github.com/ipfs/go-hamt-ipld"
+	"github.com/whyrusleeping/sharray"
+)
+
+// replayReceipt replays the message with cid mc from ts and checks it's
+// expect, and that the trace agrees with the receipt
+func replayReceipt(t *testing.T, tc *testChain, ts *TipSet, mc cid.Cid, expect *Message) *MessageReceipt {
+	t.Helper()
+
+	m, rct, trace, err := tc.cs.ReplayMessage(ts, mc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Cid() != expect.Cid() {
+		t.Fatalf("replayed message %s, expected %s", m.Cid(), expect.Cid())
+	}
+	if rct.GasUsed.Sign() == 0 {
+		t.Fatalf("message %s used no gas", mc)
+	}
+	if trace.MsgRct.ExitCode != rct.ExitCode || BigCmp(trace.MsgRct.GasUsed, rct.GasUsed) != 0 {
+		t.Fatalf("trace receipt %+v doesn't match receipt %+v", trace.MsgRct, rct)
+	}
+	return rct
+}
+
+func TestReplayMessageMatchesBlock(t *testing.T) {
+	tc, b, _, _ := mixedBlock(t)
+	if err := tc.cs.PutTipSet(&FullTipSet{Blocks: []*FullBlock{b}}); err != nil {
+		t.Fatal(err)
+	}
+	ts, err := NewTipSet([]*BlockHeader{b.Header})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// replaying every message gives back the block's receipts, the later
+	// messages from the miner depend on the earlier ones being applied first
+	var receipts []interface{}
+	for _, m := range b.BlsMessages {
+		receipts = append(receipts, replayReceipt(t, tc, ts, m.Cid(), m))
+	}
+	for _, m := range b.SecpkMessages {
+		receipts = append(receipts, replayReceipt(t, tc, ts, m.Cid(), &m.Message))
+	}
+
+	root, err := sharray.Build(context.TODO(), 4, receipts, hamt.CSTFromBstore(tc.cs.bs))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != b.Header.MessageReceipts {
+		t.Fatal("replayed receipts don't match the block's receipts")
+	}
+
+	if _, _, _, err := tc.cs.ReplayMessage(ts, tc.testMsg(t, tc.accounts[0], 5, 1).Cid()); err == nil {
+		t.Fatal("replayed a message which isn't in the tipset")
+	}
+}
@@ -18,7 +18,7 @@ type StateTree struct {
 	store *hamt.CborIpldStore
 
 	actorcache map[address.Address]*Actor
-	snapshot   cid.Cid
+	snapshots  []cid.Cid
 }
 
 func NewStateTree(cst *hamt.CborIpldStore) (*StateTree, error) {
@@ -131,16 +131,24 @@ func (st *StateTree) Flush() (cid.Cid, error) {
 	return st.store.Put(context.TODO(), st.root)
 }
 
+// Snapshot saves the current state, it must be matched by a call to Revert or
+// ClearSnapshot. Snapshots nest. Actors loaded before the snapshot must be
+// loaded again to see later changes.
 func (st *StateTree) Snapshot() error {
 	ss, err := st.Flush()
 	if err != nil {
 		return err
 	}
 
-	st.snapshot = ss
+	st.snapshots = append(st.snapshots, ss)
 	return nil
 }
 
+// ClearSnapshot drops the last snapshot, keeping the changes made since
+func (st *StateTree) ClearSnapshot() {
+	st.snapshots = st.snapshots[:len(st.snapshots)-1]
+}
+
 func (st *StateTree) RegisterNewAddress(addr address.Address, act *Actor) (address.Address, error) {
 	var out address.Address
 	err := st.MutateActor(InitActorAddress, func(initact *Actor) error {
@@ -175,13 +183,17 @@ func (st *StateTree) RegisterNewAddress(addr address.Address, act *Actor) (addre
 	return out, nil
 }
 
+// Revert drops the changes made since the last snapshot, and the snapshot
 func (st *StateTree) Revert() error {
-	nd, err := hamt.LoadNode(context.Background(), st.store, st.snapshot)
+	ss := st.snapshots[len(st.snapshots)-1]
+	nd, err := hamt.LoadNode(context.Background(), st.store, ss)
 	if err != nil {
 		return err
 	}
 
+	st.snapshots = st.snapshots[:len(st.snapshots)-1]
 	st.root = nd
+	st.actorcache = make(map[address.Address]*Actor)
 	return nil
 }
 
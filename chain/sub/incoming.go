@@ -5,6 +5,7 @@ import (
 	"fmt"
 
 	logging "github.com/ipfs/go-log"
+	"github.com/libp2p/go-libp2p-core/peer"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
 
 	"github.com/zgfzgf/mid-lotus/chain"
@@ -27,20 +28,73 @@ func HandleIncomingBlocks(ctx context.Context, bsub *pubsub.Subscription, s *cha
 		}
 
 		go func() {
-			msgs, err := s.Bsync.FetchMessagesByCids(blk.Messages)
+			bmsgs, err := s.Bsync.FetchBlsMessagesByCids(blk.BlsMessages)
+			if err != nil {
+				log.Errorf("failed to fetch all bls messages for block received over pubusb: %s", err)
+				return
+			}
+			smsgs, err := s.Bsync.FetchMessagesByCids(blk.SecpkMessages)
 			if err != nil {
 				log.Errorf("failed to fetch all messages for block received over pubusb: %s", err)
 				return
 			}
 			fmt.Println("inform new block over pubsub")
 			s.InformNewBlock(msg.GetFrom(), &chain.FullBlock{
-				Header:   blk.Header,
-				Messages: msgs,
+				Header:        blk.Header,
+				BlsMessages:   bmsgs,
+				SecpkMessages: smsgs,
 			})
 		}()
 	}
 }
 
+// BlockValidator returns a pubsub validator rejecting blocks which are unsigned,
+// not signed by the miner's worker key, or too far behind the current epoch.
+// Blocks whose parents we don't have yet are let through, the syncer checks
+// them once it fetched the chain.
+func BlockValidator(cs *chain.ChainStore) pubsub.Validator {
+	return func(ctx context.Context, _ peer.ID, msg *pubsub.Message) bool {
+		blk, err := chain.DecodeBlockMsg(msg.GetData())
+		if err != nil {
+			log.Warnf("rejecting invalid block message: %s", err)
+			return false
+		}
+
+		clock, err := cs.Clock()
+		if err != nil {
+			log.Errorf("loading chain clock: %s", err)
+			return false
+		}
+		if err := clock.CheckRecent(blk.Header.Height); err != nil {
+			log.Warnf("rejecting block %s: %s", blk.Cid(), err)
+			return false
+		}
+
+		if blk.Header.BlockSig.Type == "" {
+			log.Warnf("rejecting unsigned block %s", blk.Cid())
+			return false
+		}
+
+		baseTs, err := cs.LoadTipSet(blk.Header.Parents)
+		if err != nil {
+			return true
+		}
+
+		mi, _, err := cs.MinerPower(baseTs, blk.Header.Miner)
+		if err != nil {
+			log.Warnf("rejecting block %s: %s", blk.Cid(), err)
+			return false
+		}
+
+		if err := blk.Header.CheckBlockSignature(mi.Worker); err != nil {
+			log.Warnf("rejecting block %s: %s", blk.Cid(), err)
+			return false
+		}
+
+		return true
+	}
+}
+
 func HandleIncomingMessages(ctx context.Context, mpool *chain.MessagePool, msub *pubsub.Subscription) {
 	for {
 		msg, err := msub.Next(ctx)
@@ -0,0 +1,92 @@
+package sub
+
+import (
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-datastore"
+	dsync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+
+	"github.com/zgfzgf/mid-lotus/build"
+	"github.com/zgfzgf/mid-lotus/chain"
+)
+
+func TestBlockValidator(t *testing.T) {
+	bs := blockstore.NewBlockstore(dsync.MutexWrap(datastore.NewMapDatastore()))
+	w, err := chain.NewWallet(chain.NewMemKeystore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gen, err := chain.MakeGenesisBlock(bs, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen.Genesis.Timestamp -= 100 * build.BlockDelay
+
+	cs := chain.NewChainStore(bs, dsync.MutexWrap(datastore.NewMapDatastore()))
+	if err := cs.SetGenesis(gen.Genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	genTs := cs.GetHeaviestTipSet()
+	mi, _, err := cs.MinerPower(genTs, gen.MinerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock, err := cs.Clock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the validator doesn't check the election or the state, a header on
+	// top of genesis is enough
+	height := clock.CurrentEpoch()
+	header := func() *chain.BlockHeader {
+		h := *gen.Genesis
+		h.Miner = gen.MinerKey
+		h.Parents = genTs.Cids()
+		h.Height = height
+		h.Timestamp = clock.EpochStart(height)
+		if err := chain.SignBlock(w, mi.Worker, &h); err != nil {
+			t.Fatal(err)
+		}
+		return &h
+	}
+
+	other, err := w.GenerateKey(chain.KTSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unsigned := header()
+	unsigned.BlockSig = chain.Signature{}
+
+	nonWorker := header()
+	if err := chain.SignBlock(w, other, nonWorker); err != nil {
+		t.Fatal(err)
+	}
+
+	tampered := header()
+	tampered.Timestamp++
+
+	validate := pubsub.Validator(BlockValidator(cs))
+	check := func(name string, h *chain.BlockHeader, expect bool) {
+		data, err := (&chain.BlockMsg{Header: h}).Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg := &pubsub.Message{Message: &pb.Message{Data: data}}
+		if ok := validate(context.Background(), "", msg); ok != expect {
+			t.Errorf("%s: validator returned %t, expected %t", name, ok, expect)
+		}
+	}
+
+	check("signed by the worker", header(), true)
+	check("unsigned", unsigned, false)
+	check("signed by another key", nonWorker, false)
+	check("tampered after signing", tampered, false)
+}
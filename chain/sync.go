@@ -4,8 +4,12 @@ import (
 	"context"
 	"fmt"
 	"sync"
+	"time"
 
+	"github.com/zgfzgf/mid-lotus/build"
 	"github.com/zgfzgf/mid-lotus/chain/address"
+	"github.com/zgfzgf/mid-lotus/chain/aerrors"
+	bls "github.com/zgfzgf/mid-lotus/lib/bls-signatures"
 
 	"github.com/ipfs/go-cid"
 	dstore "github.com/ipfs/go-datastore"
@@ -26,6 +30,9 @@ type Syncer struct {
 	// The known Genesis tipset
 	Genesis *TipSet
 
+	// clock maps block heights to the times they can be mined at
+	clock *Clock
+
 	// the current mode the syncer is in
 	syncMode SyncMode
 
@@ -57,6 +64,7 @@ func NewSyncer(cs *ChainStore, bsync *BlockSync) (*Syncer, error) {
 	return &Syncer{
 		syncMode:  Bootstrap,
 		Genesis:   gent,
+		clock:     NewClock(gen.Timestamp),
 		Bsync:     bsync,
 		peerHeads: make(map[peer.ID]*TipSet),
 		head:      cs.GetHeaviestTipSet(),
@@ -226,63 +234,76 @@ func (syncer *Syncer) SyncBootstrap() {
 	}
 
 	// Fetch all the messages for all the blocks in this chain
+	if err := syncer.syncMessages(ctx, blockSet, syncer.Bsync.GetChainMessages); err != nil {
+		log.Errorf("failed to sync messages: %s", err)
+		return
+	}
+
+	head := blockSet[len(blockSet)-1]
+	log.Errorf("Finished syncing! new head: %s", head.Cids())
+	syncer.store.maybeTakeHeavierTipSet(selectedHead)
+	syncer.head = head
+	syncer.syncMode = CaughtUp
+}
+
+// chainMessagesFunc fetches the messages of the count tipsets ending in h,
+// ordered from h down
+type chainMessagesFunc func(ctx context.Context, h *TipSet, count uint64) ([]*BSTipSet, error)
 
-	windowSize := uint64(10)
-	for i := uint64(0); i <= selectedHead.Height(); i += windowSize {
+// syncMessages fetches the messages of blockSet, ordered from genesis up, in
+// windows, and validates each tipset with them. Null rounds leave no tipset,
+// so the windows go by position in blockSet and not by height.
+func (syncer *Syncer) syncMessages(ctx context.Context, blockSet []*TipSet, getMessages chainMessagesFunc) error {
+	windowSize := 10
+	for i := 0; i < len(blockSet); i += windowSize {
 		bs := bstore.NewBlockstore(dstore.NewMapDatastore())
 		cst := hamt.CSTFromBstore(bs)
 
-		nextHeight := i + windowSize - 1
-		if nextHeight > selectedHead.Height() {
-			nextHeight = selectedHead.Height()
+		last := i + windowSize - 1
+		if last >= len(blockSet) {
+			last = len(blockSet) - 1
 		}
 
-		next := blockSet[nextHeight]
-		bstips, err := syncer.Bsync.GetChainMessages(ctx, next, (nextHeight+1)-i)
+		bstips, err := getMessages(ctx, blockSet[last], uint64(last-i+1))
 		if err != nil {
-			log.Errorf("failed to fetch messages: %s", err)
-			return
+			return errors.Wrap(err, "fetching messages")
+		}
+		if len(bstips) != last-i+1 {
+			return fmt.Errorf("requested messages of %d tipsets, got %d", last-i+1, len(bstips))
 		}
 
 		for bsi := 0; bsi < len(bstips); bsi++ {
-			cur := blockSet[i+uint64(bsi)]
+			cur := blockSet[i+bsi]
 			bstip := bstips[len(bstips)-(bsi+1)]
-			fmt.Println("that loop: ", bsi, len(bstips))
-			fts, err := zipTipSetAndMessages(cst, cur, bstip.Messages, bstip.MsgIncludes)
+			fts, err := zipTipSetAndMessages(cst, cur, bstip.BlsMessages, bstip.SecpkMessages, bstip.BlsMsgIncludes, bstip.SecpkMsgIncludes)
 			if err != nil {
-				log.Error("zipping failed: ", err, bsi, i)
-				log.Error("height: ", selectedHead.Height())
-				log.Error("bstips: ", bstips)
-				log.Error("next height: ", nextHeight)
-				return
+				return errors.Wrapf(err, "zipping messages of tipset at height %d", cur.Height())
 			}
 
 			if err := syncer.ValidateTipSet(fts); err != nil {
-				log.Errorf("failed to validate tipset: %s", err)
-				return
+				return errors.Wrapf(err, "validating tipset at height %d", cur.Height())
 			}
 		}
 
 		for _, bst := range bstips {
-			for _, m := range bst.Messages {
-				if _, err := cst.Put(context.TODO(), m); err != nil {
-					log.Error("failed to persist messages: ", err)
-					return
+			for _, m := range bst.BlsMessages {
+				if err := putStorable(bs, m); err != nil {
+					return errors.Wrap(err, "persisting messages")
+				}
+			}
+			for _, m := range bst.SecpkMessages {
+				if err := putStorable(bs, m); err != nil {
+					return errors.Wrap(err, "persisting messages")
 				}
 			}
 		}
 
 		if err := copyBlockstore(bs, syncer.store.bs); err != nil {
-			log.Errorf("failed to persist temp blocks: %s", err)
-			return
+			return errors.Wrap(err, "persisting temp blocks")
 		}
 	}
 
-	head := blockSet[len(blockSet)-1]
-	log.Errorf("Finished syncing! new head: %s", head.Cids())
-	syncer.store.maybeTakeHeavierTipSet(selectedHead)
-	syncer.head = head
-	syncer.syncMode = CaughtUp
+	return nil
 }
 
 func reverse(tips []*TipSet) []*TipSet {
@@ -313,36 +334,49 @@ func copyBlockstore(from, to bstore.Blockstore) error {
 	return nil
 }
 
-func zipTipSetAndMessages(cst *hamt.CborIpldStore, ts *TipSet, messages []*SignedMessage, msgincl [][]int) (*FullTipSet, error) {
-	if len(ts.Blocks()) != len(msgincl) {
+func putStorable(bs bstore.Blockstore, m storable) error {
+	sb, err := m.ToStorageBlock()
+	if err != nil {
+		return err
+	}
+
+	return bs.Put(sb)
+}
+
+func zipTipSetAndMessages(cst *hamt.CborIpldStore, ts *TipSet, allbmsgs []*Message, allsmsgs []*SignedMessage, bmi, smi [][]int) (*FullTipSet, error) {
+	if len(ts.Blocks()) != len(smi) || len(ts.Blocks()) != len(bmi) {
 		return nil, fmt.Errorf("msgincl length didnt match tipset size")
 	}
-	fmt.Println("zipping messages: ", msgincl)
-	fmt.Println("into block: ", ts.Blocks()[0].Height)
 
 	fts := &FullTipSet{}
 	for bi, b := range ts.Blocks() {
-		var msgs []*SignedMessage
-		var msgCids []interface{}
-		for _, m := range msgincl[bi] {
-			msgs = append(msgs, messages[m])
-			msgCids = append(msgCids, messages[m].Cid())
+		var smsgs []*SignedMessage
+		var smsgCids []cid.Cid
+		for _, m := range smi[bi] {
+			smsgs = append(smsgs, allsmsgs[m])
+			smsgCids = append(smsgCids, allsmsgs[m].Cid())
+		}
+
+		var bmsgs []*Message
+		var bmsgCids []cid.Cid
+		for _, m := range bmi[bi] {
+			bmsgs = append(bmsgs, allbmsgs[m])
+			bmsgCids = append(bmsgCids, allbmsgs[m].Cid())
 		}
 
-		mroot, err := sharray.Build(context.TODO(), 4, msgCids, cst)
+		mroot, err := computeMsgMeta(cst, bmsgCids, smsgCids)
 		if err != nil {
 			return nil, err
 		}
 
-		fmt.Println("messages: ", msgCids)
-		fmt.Println("message root: ", b.Messages, mroot)
 		if b.Messages != mroot {
 			return nil, fmt.Errorf("messages didnt match message root in header")
 		}
 
 		fb := &FullBlock{
-			Header:   b,
-			Messages: msgs,
+			Header:        b,
+			BlsMessages:   bmsgs,
+			SecpkMessages: smsgs,
 		}
 
 		fts.Blocks = append(fts.Blocks, fb)
@@ -351,6 +385,25 @@ func zipTipSetAndMessages(cst *hamt.CborIpldStore, ts *TipSet, messages []*Signe
 	return fts, nil
 }
 
+// computeMsgMeta builds the message sharrays and the MsgMeta linking them,
+// and returns the cid of the MsgMeta
+func computeMsgMeta(cst *hamt.CborIpldStore, bmsgCids, smsgCids []cid.Cid) (cid.Cid, error) {
+	bmroot, err := sharray.Build(context.TODO(), 4, toIfArr(bmsgCids), cst)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	smroot, err := sharray.Build(context.TODO(), 4, toIfArr(smsgCids), cst)
+	if err != nil {
+		return cid.Undef, err
+	}
+
+	return cst.Put(context.TODO(), &MsgMeta{
+		BlsMessages:   bmroot,
+		SecpkMessages: smroot,
+	})
+}
+
 func (syncer *Syncer) selectHead(heads map[peer.ID]*TipSet) (*TipSet, error) {
 	var headsArr []*TipSet
 	for _, ts := range heads {
@@ -411,14 +464,15 @@ func (syncer *Syncer) tryLoadFullTipSet(cids []cid.Cid) (*FullTipSet, error) {
 
 	fts := &FullTipSet{}
 	for _, b := range ts.Blocks() {
-		messages, err := syncer.store.MessagesForBlock(b)
+		bmsgs, smsgs, err := syncer.store.MessagesForBlock(b)
 		if err != nil {
 			return nil, err
 		}
 
 		fb := &FullBlock{
-			Header:   b,
-			Messages: messages,
+			Header:        b,
+			BlsMessages:   bmsgs,
+			SecpkMessages: smsgs,
 		}
 		fts.Blocks = append(fts.Blocks, fb)
 	}
@@ -504,6 +558,25 @@ func (syncer *Syncer) SyncCaughtUp(maybeHead *FullTipSet) error {
 	return nil
 }
 
+// SubmitBlock validates and stores a block built and signed by an external
+// miner, its messages must already be in the local store. The block becomes
+// the head if its tipset is the heaviest.
+func (syncer *Syncer) SubmitBlock(blk *BlockMsg) error {
+	fb, err := syncer.store.LoadFullBlock(blk)
+	if err != nil {
+		return err
+	}
+
+	if err := syncer.ValidateBlock(fb); err != nil {
+		return errors.Wrap(err, "validating block")
+	}
+
+	if err := syncer.store.PutTipSet(&FullTipSet{Blocks: []*FullBlock{fb}}); err != nil {
+		return errors.Wrap(err, "storing block")
+	}
+	return nil
+}
+
 func (syncer *Syncer) ValidateTipSet(fts *FullTipSet) error {
 	ts := fts.TipSet()
 	if ts.Equals(syncer.Genesis) {
@@ -520,27 +593,48 @@ func (syncer *Syncer) ValidateTipSet(fts *FullTipSet) error {
 
 func (syncer *Syncer) ValidateBlock(b *FullBlock) error {
 	h := b.Header
-	stateroot, err := syncer.store.TipSetState(h.Parents)
+	vm, err := syncer.store.BlockVM(h)
 	if err != nil {
-		log.Error("get tipsetstate failed: ", h.Height, h.Parents, err)
+		log.Error("setting up block vm failed: ", h.Height, h.Parents, err)
+		return err
+	}
+
+	if err := syncer.clock.CheckTimestamp(h.Timestamp, h.Height); err != nil {
 		return err
 	}
-	baseTs, err := syncer.store.LoadTipSet(b.Header.Parents)
+
+	baseTs, err := syncer.store.LoadTipSet(h.Parents)
 	if err != nil {
 		return err
 	}
 
-	vm, err := NewVM(stateroot, b.Header.Height, b.Header.Miner, syncer.store)
+	mi, total, err := syncer.store.MinerPower(baseTs, h.Miner)
 	if err != nil {
 		return err
 	}
 
-	if err := vm.TransferFunds(NetworkAddress, b.Header.Miner, miningRewardForBlock(baseTs)); err != nil {
+	if err := h.CheckBlockSignature(mi.Worker); err != nil {
 		return err
 	}
 
+	if err := syncer.validateElection(h, baseTs, mi, total); err != nil {
+		return errors.Wrap(err, "block failed election validation")
+	}
+
+	if err := syncer.checkBlockMessages(b); err != nil {
+		return errors.Wrap(err, "block had invalid messages")
+	}
+
 	var receipts []interface{}
-	for _, m := range b.Messages {
+	for _, m := range b.BlsMessages {
+		receipt, err := vm.ApplyMessage(m)
+		if err != nil {
+			return err
+		}
+
+		receipts = append(receipts, receipt)
+	}
+	for _, m := range b.SecpkMessages {
 		receipt, err := vm.ApplyMessage(&m.Message)
 		if err != nil {
 			return err
@@ -549,6 +643,10 @@ func (syncer *Syncer) ValidateBlock(b *FullBlock) error {
 		receipts = append(receipts, receipt)
 	}
 
+	if _, err := vm.ApplyCronTick(); err != nil {
+		return err
+	}
+
 	cst := hamt.CSTFromBstore(syncer.store.bs)
 	recptRoot, err := sharray.Build(context.TODO(), 4, receipts, cst)
 	if err != nil {
@@ -571,6 +669,83 @@ func (syncer *Syncer) ValidateBlock(b *FullBlock) error {
 
 }
 
+// checkBlockMessages checks that the messages match the header's message
+// root, and that they are all correctly signed
+func (syncer *Syncer) checkBlockMessages(b *FullBlock) error {
+	var bmsgCids, smsgCids []cid.Cid
+	var digests []bls.Digest
+	var pubks []bls.PublicKey
+	for _, m := range b.BlsMessages {
+		if m.From.Protocol() != address.BLS {
+			return fmt.Errorf("bls message from non-bls address %s", m.From)
+		}
+
+		data, err := m.Serialize()
+		if err != nil {
+			return err
+		}
+
+		bmsgCids = append(bmsgCids, m.Cid())
+		digests = append(digests, bls.Hash(bls.Message(data)))
+
+		var pubk bls.PublicKey
+		copy(pubk[:], m.From.Payload())
+		pubks = append(pubks, pubk)
+	}
+
+	for _, m := range b.SecpkMessages {
+		if m.Signature.Type != KTSecp256k1 {
+			return fmt.Errorf("message %s in secpk list has %s signature", m.Cid(), m.Signature.Type)
+		}
+
+		data, err := m.Message.Serialize()
+		if err != nil {
+			return err
+		}
+
+		if err := m.Signature.Verify(m.Message.From, data); err != nil {
+			return errors.Wrapf(err, "message %s has invalid signature", m.Cid())
+		}
+
+		smsgCids = append(smsgCids, m.Cid())
+	}
+
+	mroot, err := computeMsgMeta(hamt.CSTFromBstore(syncer.store.bs), bmsgCids, smsgCids)
+	if err != nil {
+		return err
+	}
+	if mroot != b.Header.Messages {
+		return fmt.Errorf("messages didnt match message root in header")
+	}
+
+	return verifyBlsAggregate(b.Header.BLSAggregate, digests, pubks)
+}
+
+func verifyBlsAggregate(agg Signature, digests []bls.Digest, pubks []bls.PublicKey) error {
+	if agg.Type != KTBLS {
+		return fmt.Errorf("bls aggregate has %s signature type", agg.Type)
+	}
+
+	if len(digests) == 0 {
+		if len(agg.Data) != 0 {
+			return fmt.Errorf("block without bls messages has a bls aggregate")
+		}
+		return nil
+	}
+
+	var sig bls.Signature
+	if len(agg.Data) != len(sig) {
+		return fmt.Errorf("bls aggregate has wrong length: %d", len(agg.Data))
+	}
+	copy(sig[:], agg.Data)
+
+	if !bls.Verify(sig, digests, pubks) {
+		return fmt.Errorf("bls aggregate signature was invalid")
+	}
+
+	return nil
+}
+
 func DeductFunds(act *Actor, amt BigInt) error {
 	if BigCmp(act.Balance, amt) < 0 {
 		return fmt.Errorf("not enough funds")
@@ -584,32 +759,34 @@ func DepositFunds(act *Actor, amt BigInt) {
 	act.Balance = BigAdd(act.Balance, amt)
 }
 
-func TryCreateAccountActor(st *StateTree, addr address.Address) (*Actor, error) {
-	act, err := makeActor(st, addr)
-	if err != nil {
-		return nil, err
+func TryCreateAccountActor(st *StateTree, addr address.Address) (*Actor, aerrors.ActorError) {
+	act, aerr := makeActor(st, addr)
+	if aerr != nil {
+		return nil, aerr
 	}
 
-	_, err = st.RegisterNewAddress(addr, act)
+	_, err := st.RegisterNewAddress(addr, act)
 	if err != nil {
-		return nil, err
+		return nil, aerrors.Escalate(err, "registering actor address")
 	}
 
 	return act, nil
 }
 
-func makeActor(st *StateTree, addr address.Address) (*Actor, error) {
+func makeActor(st *StateTree, addr address.Address) (*Actor, aerrors.ActorError) {
 	switch addr.Protocol() {
 	case address.BLS:
-		return NewBLSAccountActor(st, addr)
+		act, err := NewBLSAccountActor(st, addr)
+		return act, aerrors.Escalate(err, "creating BLS account actor")
 	case address.SECP256K1:
-		return NewSecp256k1AccountActor(st, addr)
+		act, err := NewSecp256k1AccountActor(st, addr)
+		return act, aerrors.Escalate(err, "creating secp256k1 account actor")
 	case address.ID:
-		return nil, fmt.Errorf("no actor with given ID")
+		return nil, aerrors.New(aerrors.SysErrInvalidReceiver, "no actor with given ID")
 	case address.Actor:
-		return nil, fmt.Errorf("no such actor")
+		return nil, aerrors.New(aerrors.SysErrInvalidReceiver, "no such actor")
 	default:
-		return nil, fmt.Errorf("address has unsupported protocol: %d", addr.Protocol())
+		return nil, aerrors.Newf(aerrors.SysErrInvalidReceiver, "address has unsupported protocol: %d", addr.Protocol())
 	}
 }
 
@@ -641,8 +818,10 @@ func NewSecp256k1AccountActor(st *StateTree, addr address.Address) (*Actor, erro
 	return nact, nil
 }
 
+// Punctual returns whether the tipset isn't from the future
 func (syncer *Syncer) Punctual(ts *TipSet) bool {
-	return true
+	now := uint64(time.Now().Unix())
+	return ts.Blocks()[0].Timestamp <= now+build.AllowableClockDrift
 }
 
 func (syncer *Syncer) collectChainCaughtUp(fts *FullTipSet) ([]*FullTipSet, error) {
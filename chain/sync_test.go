@@ -0,0 +1,350 @@
+package chain
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dsync "github.com/ipfs/go-datastore/sync"
+	hamt "github.com/ipfs/go-hamt-ipld"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+)
+
+func TestValidateMinedBlockAfterNullRounds(t *testing.T) {
+	tc := newTestChain(t)
+	syncer := tc.testSyncer(t)
+
+	gen := tc.cs.GetHeaviestTipSet()
+	b := tc.mineBlock(t, gen, 2, nil)
+	if b.Header.Height != gen.Height()+3 {
+		t.Fatalf("block after 2 null rounds at height %d", b.Header.Height)
+	}
+
+	if err := syncer.ValidateBlock(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// bootstrapBlockSet returns the tipsets from genesis up to the heaviest tipset
+func bootstrapBlockSet(t *testing.T, cs *ChainStore) []*TipSet {
+	t.Helper()
+
+	var out []*TipSet
+	ts := cs.GetHeaviestTipSet()
+	for {
+		out = append(out, ts)
+		if ts.Height() == 0 {
+			break
+		}
+
+		var err error
+		ts, err = cs.LoadTipSet(ts.Parents())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return reverse(out)
+}
+
+func TestSyncMessagesAcrossNullRounds(t *testing.T) {
+	src := newTestChainWithAccounts(t, 1)
+
+	// a second node knowing only the genesis block
+	gen, err := src.cs.GetGenesis()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := blockstore.NewBlockstore(dsync.MutexWrap(datastore.NewMapDatastore()))
+	if err := copyBlockstore(src.cs.bs, bs); err != nil {
+		t.Fatal(err)
+	}
+	dst := NewChainStore(bs, dsync.MutexWrap(datastore.NewMapDatastore()))
+	if err := dst.SetGenesis(gen); err != nil {
+		t.Fatal(err)
+	}
+
+	// more tipsets than a sync window, with null rounds spread over them
+	mp := NewMessagePool(src.cs, src.localWallet(t))
+	src.mineChain(t, mp, 0, 1, 0, 2, 0, 0, 1)
+	if err := mp.Add(src.testMsg(t, src.accounts[0], 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+	withMsg := src.mineChain(t, mp, 1)[0]
+	src.mineChain(t, nil, 0, 3, 0, 0, 1)
+
+	if len(withMsg.SecpkMessages) != 1 {
+		t.Fatalf("block has %d messages, expected 1", len(withMsg.SecpkMessages))
+	}
+
+	blockSet := bootstrapBlockSet(t, src.cs)
+	head := blockSet[len(blockSet)-1]
+	if uint64(len(blockSet)) > head.Height() {
+		t.Fatalf("chain of %d tipsets up to height %d has no null rounds", len(blockSet), head.Height())
+	}
+
+	for _, ts := range blockSet {
+		for _, b := range ts.Blocks() {
+			if err := dst.persistBlockHeader(b); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	bss := NewBlockSyncService(src.cs)
+	getMessages := func(ctx context.Context, h *TipSet, count uint64) ([]*BSTipSet, error) {
+		return bss.collectChainSegment(h.Cids(), count, &BSOptions{IncludeMessages: true})
+	}
+
+	syncer, err := NewSyncer(dst, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := syncer.syncMessages(context.Background(), blockSet, getMessages); err != nil {
+		t.Fatal(err)
+	}
+
+	_, smsgs, err := dst.MessagesForBlock(withMsg.Header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(smsgs) != 1 || smsgs[0].Cid() != withMsg.SecpkMessages[0].Cid() {
+		t.Fatalf("synced messages %v don't match the block's", smsgs)
+	}
+}
+
+// mixedBlock mines a block holding two BLS messages from the genesis miner
+// and a secp message from each of two accounts, and returns them with it
+func mixedBlock(t *testing.T) (*testChain, *FullBlock, []*SignedMessage, []*SignedMessage) {
+	t.Helper()
+
+	tc := newTestChainWithAccounts(t, 2)
+	mp := NewMessagePool(tc.cs, tc.localWallet(t))
+
+	blsMsgs := []*SignedMessage{tc.testMsg(t, tc.miner, 0, 1), tc.testMsg(t, tc.miner, 1, 1)}
+	secpMsgs := []*SignedMessage{tc.testMsg(t, tc.accounts[0], 0, 1), tc.testMsg(t, tc.accounts[1], 0, 1)}
+	for _, m := range append(append([]*SignedMessage{}, blsMsgs...), secpMsgs...) {
+		if err := mp.Add(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := tc.mineBlock(t, tc.cs.GetHeaviestTipSet(), 0, mp)
+	if len(b.BlsMessages) != 2 || len(b.SecpkMessages) != 2 {
+		t.Fatalf("block has %d bls and %d secp messages, expected 2 of each", len(b.BlsMessages), len(b.SecpkMessages))
+	}
+	return tc, b, blsMsgs, secpMsgs
+}
+
+// withMessages returns a copy of b with other messages, and optionally
+// another bls aggregate
+func withMessages(b *FullBlock, bls []*Message, secp []*SignedMessage, agg *Signature) *FullBlock {
+	h := *b.Header
+	if agg != nil {
+		h.BLSAggregate = *agg
+	}
+	return &FullBlock{Header: &h, BlsMessages: bls, SecpkMessages: secp}
+}
+
+func TestValidateMixedMessagesBlock(t *testing.T) {
+	tc, b, _, _ := mixedBlock(t)
+
+	if len(b.Header.BLSAggregate.Data) == 0 {
+		t.Fatal("block with bls messages has no aggregate")
+	}
+	if err := tc.testSyncer(t).ValidateBlock(b); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMsgMetaRootRebuild(t *testing.T) {
+	tc, b, _, _ := mixedBlock(t)
+
+	// the messages stored for the block rebuild its message root
+	bls, secp, err := tc.cs.MessagesForBlock(b.Header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bcids, scids []cid.Cid
+	for _, m := range bls {
+		bcids = append(bcids, m.Cid())
+	}
+	for _, m := range secp {
+		scids = append(scids, m.Cid())
+	}
+	root, err := computeMsgMeta(hamt.CSTFromBstore(tc.cs.bs), bcids, scids)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if root != b.Header.Messages {
+		t.Fatalf("rebuilt message root %s, header has %s", root, b.Header.Messages)
+	}
+
+	syncer := tc.testSyncer(t)
+	if err := syncer.checkBlockMessages(withMessages(b, bls, secp, nil)); err != nil {
+		t.Fatal(err)
+	}
+
+	// any change to the message lists changes the root
+	for name, fb := range map[string]*FullBlock{
+		"missing secp message": withMessages(b, b.BlsMessages, b.SecpkMessages[:1], nil),
+		"swapped secp messages": withMessages(b, b.BlsMessages,
+			[]*SignedMessage{b.SecpkMessages[1], b.SecpkMessages[0]}, nil),
+	} {
+		err := syncer.checkBlockMessages(fb)
+		if err == nil || !strings.Contains(err.Error(), "message root") {
+			t.Errorf("%s: expected a message root mismatch, got %v", name, err)
+		}
+	}
+}
+
+func TestCheckBlockMessagesBadAggregate(t *testing.T) {
+	tc, b, blsMsgs, _ := mixedBlock(t)
+	syncer := tc.testSyncer(t)
+
+	partial, err := aggregateSignatures([]Signature{blsMsgs[0].Signature})
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := Signature{Type: KTBLS, Data: b.Header.BLSAggregate.Data[:10]}
+	secpType := Signature{Type: KTSecp256k1, Data: b.Header.BLSAggregate.Data}
+
+	for name, agg := range map[string]*Signature{
+		"partial aggregate": &partial,
+		"truncated":         &truncated,
+		"wrong type":        &secpType,
+		"empty":             {Type: KTBLS},
+	} {
+		if err := syncer.checkBlockMessages(withMessages(b, b.BlsMessages, b.SecpkMessages, agg)); err == nil {
+			t.Errorf("%s: bad bls aggregate accepted", name)
+		}
+	}
+
+	// a block without bls messages can't carry an aggregate
+	noBls, err := computeMsgMeta(hamt.CSTFromBstore(tc.cs.bs), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	empty := withMessages(b, nil, nil, nil)
+	empty.Header.Messages = noBls
+	if err := syncer.checkBlockMessages(empty); err == nil {
+		t.Error("aggregate without bls messages accepted")
+	}
+}
+
+func TestCheckBlockMessagesWrongList(t *testing.T) {
+	tc, b, blsMsgs, secpMsgs := mixedBlock(t)
+	syncer := tc.testSyncer(t)
+
+	// a secp message in the bls list
+	bls := append(append([]*Message{}, b.BlsMessages...), &secpMsgs[0].Message)
+	err := syncer.checkBlockMessages(withMessages(b, bls, b.SecpkMessages[1:], nil))
+	if err == nil || !strings.Contains(err.Error(), "non-bls address") {
+		t.Errorf("secp message in the bls list: got %v", err)
+	}
+
+	// a bls message in the secp list
+	secp := append(append([]*SignedMessage{}, b.SecpkMessages...), blsMsgs[1])
+	err = syncer.checkBlockMessages(withMessages(b, b.BlsMessages[:1], secp, nil))
+	if err == nil || !strings.Contains(err.Error(), "bls signature") {
+		t.Errorf("bls message in the secp list: got %v", err)
+	}
+}
+
+func TestValidateBlockSignature(t *testing.T) {
+	tc := newTestChain(t)
+	syncer := tc.testSyncer(t)
+	b := tc.mineBlock(t, tc.cs.GetHeaviestTipSet(), 0, nil)
+
+	mi, _, err := tc.cs.MinerPower(tc.cs.GetHeaviestTipSet(), tc.miner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Header.CheckBlockSignature(mi.Worker); err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := tc.w.GenerateKey(KTSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		mutate func(h *BlockHeader)
+		expect string
+	}{
+		{"unsigned", func(h *BlockHeader) { h.BlockSig = Signature{} }, "not signed"},
+		{"signed by another key", func(h *BlockHeader) {
+			if err := SignBlock(tc.w, other, h); err != nil {
+				t.Fatal(err)
+			}
+		}, "invalid block signature"},
+		{"tampered after signing", func(h *BlockHeader) { h.Timestamp++ }, "invalid block signature"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			h := *b.Header
+			c.mutate(&h)
+
+			if err := h.CheckBlockSignature(mi.Worker); err == nil || !strings.Contains(err.Error(), c.expect) {
+				t.Fatalf("expected %q, got %v", c.expect, err)
+			}
+
+			fb := &FullBlock{Header: &h, BlsMessages: b.BlsMessages, SecpkMessages: b.SecpkMessages}
+			if err := syncer.ValidateBlock(fb); err == nil || !strings.Contains(err.Error(), c.expect) {
+				t.Fatalf("ValidateBlock: expected %q, got %v", c.expect, err)
+			}
+		})
+	}
+}
+
+func TestSubmitExternalBlock(t *testing.T) {
+	tc := newTestChainWithAccounts(t, 1)
+	syncer := tc.testSyncer(t)
+	gen := tc.cs.GetHeaviestTipSet()
+
+	mp := NewMessagePool(tc.cs, tc.localWallet(t))
+	if err := mp.Add(tc.testMsg(t, tc.accounts[0], 0, 1)); err != nil {
+		t.Fatal(err)
+	}
+
+	// the block goes to the external miner and back over the JSON API
+	roundTrip := func(bm *BlockMsg) *BlockMsg {
+		data, err := json.Marshal(bm)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out BlockMsg
+		if err := json.Unmarshal(data, &out); err != nil {
+			t.Fatal(err)
+		}
+		return &out
+	}
+
+	b := tc.mineBlock(t, gen, 0, mp)
+	if len(b.SecpkMessages) != 1 {
+		t.Fatalf("block has %d messages, expected 1", len(b.SecpkMessages))
+	}
+
+	unsigned := roundTrip(b.ToBlockMsg())
+	unsigned.Header.BlockSig = Signature{}
+	if err := syncer.SubmitBlock(unsigned); err == nil {
+		t.Fatal("unsigned block accepted")
+	}
+	if !tc.cs.GetHeaviestTipSet().Equals(gen) {
+		t.Fatal("rejected block changed the head")
+	}
+
+	if err := syncer.SubmitBlock(roundTrip(b.ToBlockMsg())); err != nil {
+		t.Fatal(err)
+	}
+
+	head := tc.cs.GetHeaviestTipSet()
+	if len(head.Cids()) != 1 || head.Cids()[0] != b.Header.Cid() {
+		t.Fatalf("head is %v, expected the submitted block %s", head.Cids(), b.Header.Cid())
+	}
+}
@@ -0,0 +1,47 @@
+package chain
+
+import (
+	"github.com/zgfzgf/mid-lotus/chain/aerrors"
+
+	"github.com/ipfs/go-cid"
+)
+
+// ExecutionTrace records a single invocation made while applying a message,
+// along with all the calls it made to other actors
+type ExecutionTrace struct {
+	Msg    *Message
+	MsgRct *MessageReceipt
+	Error  string
+
+	GasCharges []*GasTrace
+	StateOps   []*StateOp
+
+	Subcalls []*ExecutionTrace
+}
+
+// GasTrace is a single gas charge
+type GasTrace struct {
+	Name   string
+	Amount BigInt
+}
+
+// StateOp is a single read or write of actor state
+type StateOp struct {
+	Write bool
+	Cid   cid.Cid
+}
+
+func (et *ExecutionTrace) finish(ret []byte, gasUsed BigInt, err error) {
+	if et == nil {
+		return
+	}
+
+	et.MsgRct = &MessageReceipt{
+		ExitCode: aerrors.RetCode(err),
+		Return:   ret,
+		GasUsed:  gasUsed,
+	}
+	if err != nil {
+		et.Error = err.Error()
+	}
+}
@@ -0,0 +1,80 @@
+package chain
+
+import (
+	"testing"
+
+	cid "github.com/ipfs/go-cid"
+	mh "github.com/multiformats/go-multihash"
+
+	"github.com/zgfzgf/mid-lotus/chain/address"
+	"github.com/zgfzgf/mid-lotus/chain/aerrors"
+)
+
+func TestApplyMessageTracedSubcalls(t *testing.T) {
+	tc := newTestChainWithAccounts(t, 2)
+	vm := tc.vm(t)
+
+	// an actor which pays an account, then calls a method which doesn't exist
+	code, err := cid.NewPrefixV1(cid.Raw, mh.ID).Sum([]byte("test-sending-actor"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.inv.register(code, nativeCode{
+		1: func(act *Actor, vmctx *VMContext, params []byte) ([]byte, aerrors.ActorError) {
+			if _, err := vmctx.Send(tc.accounts[1], 0, NewInt(10), nil); err != nil {
+				return nil, err
+			}
+			if _, err := vmctx.Send(NetworkAddress, 99, NewInt(0), nil); err != nil && err.IsFatal() {
+				return nil, err
+			}
+			return []byte("done"), nil
+		},
+	})
+
+	addr, err := address.NewIDAddress(999)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.cstate.SetActor(addr, &Actor{Code: code, Balance: NewInt(100), Head: EmptyObjectCid}); err != nil {
+		t.Fatal(err)
+	}
+
+	rct, trace, err := vm.ApplyMessageTraced(&Message{
+		From: tc.accounts[0], To: addr, Method: 1,
+		Value: NewInt(0), GasPrice: NewInt(1), GasLimit: NewInt(10000),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rct.ExitCode != aerrors.Ok || string(rct.Return) != "done" {
+		t.Fatalf("message failed: %+v", rct)
+	}
+	if trace.MsgRct.ExitCode != rct.ExitCode || BigCmp(trace.MsgRct.GasUsed, rct.GasUsed) != 0 {
+		t.Fatalf("trace receipt %+v doesn't match receipt %+v", trace.MsgRct, rct)
+	}
+
+	if len(trace.Subcalls) != 2 {
+		t.Fatalf("traced %d subcalls, expected 2", len(trace.Subcalls))
+	}
+
+	pay, fail := trace.Subcalls[0], trace.Subcalls[1]
+	if pay.Msg.From != addr || pay.Msg.To != tc.accounts[1] || BigCmp(pay.Msg.Value, NewInt(10)) != 0 {
+		t.Fatalf("unexpected first subcall %+v", pay.Msg)
+	}
+	if pay.MsgRct.ExitCode != aerrors.Ok || pay.Error != "" {
+		t.Fatalf("first subcall failed: %+v %s", pay.MsgRct, pay.Error)
+	}
+
+	if fail.Msg.To != NetworkAddress || fail.Msg.Method != 99 {
+		t.Fatalf("unexpected second subcall %+v", fail.Msg)
+	}
+	if fail.MsgRct.ExitCode == aerrors.Ok || fail.Error == "" {
+		t.Fatalf("second subcall didn't record its failure: %+v", fail.MsgRct)
+	}
+
+	// the gas of the subcalls is part of the message's
+	sub := BigAdd(pay.MsgRct.GasUsed, fail.MsgRct.GasUsed)
+	if BigCmp(sub, rct.GasUsed) > 0 {
+		t.Fatalf("subcalls used %s gas, more than the message's %s", sub, rct.GasUsed)
+	}
+}
@@ -2,17 +2,19 @@ package chain
 
 import (
 	"bytes"
-	"encoding/binary"
+	"encoding/json"
 	"fmt"
 	"math/big"
 
 	"github.com/zgfzgf/mid-lotus/chain/address"
+	"github.com/zgfzgf/mid-lotus/chain/aerrors"
 
 	block "github.com/ipfs/go-block-format"
 	"github.com/ipfs/go-cid"
 	cbor "github.com/ipfs/go-ipld-cbor"
 	ipld "github.com/ipfs/go-ipld-format"
 	"github.com/multiformats/go-multihash"
+	"github.com/pkg/errors"
 	"github.com/polydawn/refmt/obj/atlas"
 )
 
@@ -22,6 +24,7 @@ func init() {
 	cbor.RegisterCborType(MessageReceipt{})
 	cbor.RegisterCborType(Actor{})
 	cbor.RegisterCborType(BlockMsg{})
+	cbor.RegisterCborType(MsgMeta{})
 
 	///*
 	cbor.RegisterCborType(atlas.BuildEntry(BigInt{}).UseTag(2).Transform().
@@ -68,9 +71,7 @@ func init() {
 	cbor.RegisterCborType(atlas.BuildEntry(Signature{}).Transform().
 		TransformMarshal(atlas.MakeMarshalTransformFunc(
 			func(s Signature) ([]byte, error) {
-				buf := make([]byte, 4)
-				n := binary.PutUvarint(buf, uint64(s.TypeCode()))
-				return append(buf[:n], s.Data...), nil
+				return s.Bytes(), nil
 			})).
 		TransformUnmarshal(atlas.MakeUnmarshalTransformFunc(
 			func(x []byte) (Signature, error) {
@@ -143,6 +144,13 @@ func init() {
 				if blk.Parents == nil {
 					blk.Parents = []cid.Cid{}
 				}
+
+				// unsigned headers are encoded with an empty signature
+				blockSig := []byte{}
+				if blk.BlockSig.Type != "" {
+					blockSig = blk.BlockSig.Bytes()
+				}
+
 				return []interface{}{
 					blk.Miner.Bytes(),
 					blk.Tickets,
@@ -152,7 +160,10 @@ func init() {
 					blk.Height,
 					blk.StateRoot,
 					blk.Messages,
+					blk.BLSAggregate,
 					blk.MessageReceipts,
+					blk.Timestamp,
+					blockSig,
 				}, nil
 			})).
 		TransformUnmarshal(atlas.MakeUnmarshalTransformFunc(
@@ -179,7 +190,30 @@ func init() {
 				stateRoot := arr[6].(cid.Cid)
 
 				msgscid := arr[7].(cid.Cid)
-				recscid := arr[8].(cid.Cid)
+
+				aggb, ok := arr[8].([]byte)
+				if !ok {
+					return BlockHeader{}, fmt.Errorf("bls aggregate in block header was not bytes")
+				}
+				blsAggregate, err := SignatureFromBytes(aggb)
+				if err != nil {
+					return BlockHeader{}, err
+				}
+
+				recscid := arr[9].(cid.Cid)
+				timestamp := arr[10].(uint64)
+
+				var blockSig Signature
+				sigb, ok := arr[11].([]byte)
+				if !ok {
+					return BlockHeader{}, fmt.Errorf("block signature in block header was not bytes")
+				}
+				if len(sigb) > 0 {
+					blockSig, err = SignatureFromBytes(sigb)
+					if err != nil {
+						return BlockHeader{}, err
+					}
+				}
 
 				return BlockHeader{
 					Miner:           miner,
@@ -190,7 +224,10 @@ func init() {
 					Height:          height,
 					StateRoot:       stateRoot,
 					Messages:        msgscid,
+					BLSAggregate:    blsAggregate,
 					MessageReceipts: recscid,
+					Timestamp:       timestamp,
+					BlockSig:        blockSig,
 				}, nil
 			})).
 		Complete())
@@ -221,6 +258,10 @@ func BigSub(a, b BigInt) BigInt {
 	return BigInt{big.NewInt(0).Sub(a.Int, b.Int)}
 }
 
+func BigDiv(a, b BigInt) BigInt {
+	return BigInt{big.NewInt(0).Div(a.Int, b.Int)}
+}
+
 func BigCmp(a, b BigInt) int {
 	return a.Int.Cmp(b.Int)
 }
@@ -229,6 +270,38 @@ func (bi *BigInt) Nil() bool {
 	return bi.Int == nil
 }
 
+func (bi BigInt) MarshalJSON() ([]byte, error) {
+	if bi.Int == nil {
+		return json.Marshal("0")
+	}
+	return json.Marshal(bi.String())
+}
+
+func (bi *BigInt) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	i, err := BigFromString(s)
+	if err != nil {
+		return err
+	}
+
+	*bi = i
+	return nil
+}
+
+// BigFromString parses a base 10 integer
+func BigFromString(s string) (BigInt, error) {
+	i, ok := big.NewInt(0).SetString(s, 10)
+	if !ok {
+		return BigInt{}, fmt.Errorf("failed to parse bigint string: %q", s)
+	}
+
+	return BigInt{i}, nil
+}
+
 type Actor struct {
 	Code    cid.Cid
 	Head    cid.Cid
@@ -251,11 +324,22 @@ type BlockHeader struct {
 
 	StateRoot cid.Cid
 
+	// Messages is the cid of the block's MsgMeta
 	Messages cid.Cid
 
+	// BLSAggregate is the aggregate of the signatures of all BLS messages in
+	// the block. Its data is empty if there are none.
 	BLSAggregate Signature
 
 	MessageReceipts cid.Cid
+
+	// Timestamp is the unix time at which the block was mined, it must be
+	// within the epoch of the block's height
+	Timestamp uint64
+
+	// BlockSig is the signature of the miner's worker key over the header
+	// without the signature, see SigningBytes
+	BlockSig Signature
 }
 
 func (b *BlockHeader) ToStorageBlock() (block.Block, error) {
@@ -282,6 +366,31 @@ func (b *BlockHeader) Cid() cid.Cid {
 	return sb.Cid()
 }
 
+// SigningBytes returns the serialized header with an empty BlockSig, which is
+// what the miner signs
+func (b *BlockHeader) SigningBytes() ([]byte, error) {
+	unsigned := *b
+	unsigned.BlockSig = Signature{}
+	return unsigned.Serialize()
+}
+
+// CheckBlockSignature verifies that the header was signed by the worker key
+func (b *BlockHeader) CheckBlockSignature(worker address.Address) error {
+	if b.BlockSig.Type == "" {
+		return fmt.Errorf("block is not signed")
+	}
+
+	data, err := b.SigningBytes()
+	if err != nil {
+		return err
+	}
+
+	if err := b.BlockSig.Verify(worker, data); err != nil {
+		return errors.Wrap(err, "invalid block signature")
+	}
+	return nil
+}
+
 func DecodeBlock(b []byte) (*BlockHeader, error) {
 	var blk BlockHeader
 	if err := cbor.DecodeInto(b, &blk); err != nil {
@@ -323,6 +432,15 @@ func (m *Message) Serialize() ([]byte, error) {
 	return cbor.DumpObject(m)
 }
 
+func (m *Message) Cid() cid.Cid {
+	sb, err := m.ToStorageBlock()
+	if err != nil {
+		panic(err)
+	}
+
+	return sb.Cid()
+}
+
 func (m *Message) ToStorageBlock() (block.Block, error) {
 	data, err := m.Serialize()
 	if err != nil {
@@ -363,7 +481,7 @@ func (m *SignedMessage) Cid() cid.Cid {
 }
 
 type MessageReceipt struct {
-	ExitCode uint8
+	ExitCode aerrors.ExitCode
 
 	Return []byte
 
@@ -417,6 +535,39 @@ func NewTipSet(blks []*BlockHeader) (*TipSet, error) {
 	return &ts, nil
 }
 
+type expTipSet struct {
+	Cids   []cid.Cid
+	Blocks []*BlockHeader
+	Height uint64
+}
+
+func (ts *TipSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(expTipSet{
+		Cids:   ts.cids,
+		Blocks: ts.blks,
+		Height: ts.height,
+	})
+}
+
+func (ts *TipSet) UnmarshalJSON(b []byte) error {
+	var ets expTipSet
+	if err := json.Unmarshal(b, &ets); err != nil {
+		return err
+	}
+
+	if len(ets.Blocks) == 0 {
+		return fmt.Errorf("tipset must contain at least one block")
+	}
+
+	ots, err := NewTipSet(ets.Blocks)
+	if err != nil {
+		return err
+	}
+
+	*ts = *ots
+	return nil
+}
+
 func (ts *TipSet) Cids() []cid.Cid {
 	return ts.cids
 }
@@ -470,6 +621,8 @@ func (f *filecoinIpldNode) Cid() cid.Cid {
 		return t.Cid()
 	case SignedMessage:
 		return t.Cid()
+	case Message:
+		return t.Cid()
 	default:
 		panic("whats going on")
 	}
@@ -553,6 +706,12 @@ func (f *filecoinIpldNode) RawData() []byte {
 			panic(err)
 		}
 		return sb.RawData()
+	case Message:
+		sb, err := t.ToStorageBlock()
+		if err != nil {
+			panic(err)
+		}
+		return sb.RawData()
 	default:
 		panic("whats going on")
 	}
@@ -562,9 +721,18 @@ func (f *filecoinIpldNode) String() string {
 	return "cats"
 }
 
+// MsgMeta links the messages included in a block. BLS messages are stored
+// without signatures, which are aggregated into BlockHeader.BLSAggregate.
+// Both fields are sharrays of message cids.
+type MsgMeta struct {
+	BlsMessages   cid.Cid
+	SecpkMessages cid.Cid
+}
+
 type FullBlock struct {
-	Header   *BlockHeader
-	Messages []*SignedMessage
+	Header        *BlockHeader
+	BlsMessages   []*Message
+	SecpkMessages []*SignedMessage
 }
 
 func (fb *FullBlock) Cid() cid.Cid {
@@ -572,8 +740,24 @@ func (fb *FullBlock) Cid() cid.Cid {
 }
 
 type BlockMsg struct {
-	Header   *BlockHeader
-	Messages []cid.Cid
+	Header        *BlockHeader
+	BlsMessages   []cid.Cid
+	SecpkMessages []cid.Cid
+}
+
+// ToBlockMsg returns the form of the block gossiped over pubsub, with the
+// messages replaced by their cids
+func (fb *FullBlock) ToBlockMsg() *BlockMsg {
+	bm := &BlockMsg{
+		Header: fb.Header,
+	}
+	for _, m := range fb.BlsMessages {
+		bm.BlsMessages = append(bm.BlsMessages, m.Cid())
+	}
+	for _, m := range fb.SecpkMessages {
+		bm.SecpkMessages = append(bm.SecpkMessages, m.Cid())
+	}
+	return bm
 }
 
 func DecodeBlockMsg(b []byte) (*BlockMsg, error) {
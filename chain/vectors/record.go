@@ -0,0 +1,84 @@
+package vectors
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/zgfzgf/mid-lotus/chain"
+	"github.com/zgfzgf/mid-lotus/lib/car"
+)
+
+// RecordBlock captures the state transition of a block already in the chain
+// as a test vector. The pre-state is the parent state with the block reward
+// applied, so the recorded post-state is the block's state root.
+func RecordBlock(ctx context.Context, cs *chain.ChainStore, b *chain.BlockHeader) (*TestVector, error) {
+	vm, err := cs.BlockVM(b)
+	if err != nil {
+		return nil, err
+	}
+
+	pre, err := vm.Flush(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	msgs, err := cs.BlockMessages(b)
+	if err != nil {
+		return nil, err
+	}
+
+	v := &TestVector{
+		Description:  fmt.Sprintf("block %s at height %d", b.Cid(), b.Height),
+		Height:       b.Height,
+		Miner:        b.Miner,
+		PreStateRoot: pre,
+		ApplyCron:    true,
+	}
+
+	for _, m := range msgs {
+		rct, err := vm.ApplyMessage(m)
+		if err != nil {
+			return nil, err
+		}
+
+		v.Messages = append(v.Messages, m)
+		v.Receipts = append(v.Receipts, rct)
+	}
+
+	if _, err := vm.ApplyCronTick(); err != nil {
+		return nil, err
+	}
+
+	v.PostStateRoot, err = vm.Flush(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	if v.PostStateRoot != b.StateRoot {
+		return nil, fmt.Errorf("recorded post-state %s doesn't match block state root %s", v.PostStateRoot, b.StateRoot)
+	}
+
+	buf := new(bytes.Buffer)
+	if err := car.WriteCar(ctx, cs.Blockstore(), []cid.Cid{pre}, buf); err != nil {
+		return nil, err
+	}
+	v.PreStateCar = buf.Bytes()
+
+	return v, nil
+}
+
+// RecordTipSet records a test vector for every block in the tipset
+func RecordTipSet(ctx context.Context, cs *chain.ChainStore, ts *chain.TipSet) ([]*TestVector, error) {
+	var out []*TestVector
+	for _, b := range ts.Blocks() {
+		v, err := RecordBlock(ctx, cs, b)
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, v)
+	}
+	return out, nil
+}
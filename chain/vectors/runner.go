@@ -0,0 +1,97 @@
+package vectors
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dsync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+
+	"github.com/zgfzgf/mid-lotus/chain"
+	"github.com/zgfzgf/mid-lotus/lib/car"
+)
+
+// Result is the outcome of running a test vector through the VM
+type Result struct {
+	Receipts      []*chain.MessageReceipt
+	PostStateRoot cid.Cid
+}
+
+// Execute loads the pre-state of the vector into a fresh blockstore and
+// applies the vector's messages to it
+func Execute(ctx context.Context, v *TestVector) (*Result, error) {
+	bs := blockstore.NewIdStore(blockstore.NewBlockstore(dsync.MutexWrap(datastore.NewMapDatastore())))
+	if _, err := car.LoadCar(bs, bytes.NewReader(v.PreStateCar)); err != nil {
+		return nil, fmt.Errorf("loading pre-state: %s", err)
+	}
+
+	cs := chain.NewChainStore(bs, dsync.MutexWrap(datastore.NewMapDatastore()))
+	vm, err := chain.NewVM(v.PreStateRoot, v.Height, v.Miner, cs)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &Result{}
+	for i, m := range v.Messages {
+		rct, err := vm.ApplyMessage(m)
+		if err != nil {
+			return nil, fmt.Errorf("applying message %d: %s", i, err)
+		}
+		res.Receipts = append(res.Receipts, rct)
+	}
+
+	if v.ApplyCron {
+		if _, err := vm.ApplyCronTick(); err != nil {
+			return nil, err
+		}
+	}
+
+	res.PostStateRoot, err = vm.Flush(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
+
+// Diff returns a description of every difference between the expectations of
+// the vector and the given result. No differences means the vector passed.
+func Diff(v *TestVector, res *Result) []string {
+	var diffs []string
+
+	if len(res.Receipts) != len(v.Receipts) {
+		diffs = append(diffs, fmt.Sprintf("expected %d receipts, got %d", len(v.Receipts), len(res.Receipts)))
+	}
+
+	for i := 0; i < len(v.Receipts) && i < len(res.Receipts); i++ {
+		exp, got := v.Receipts[i], res.Receipts[i]
+		if exp.ExitCode != got.ExitCode {
+			diffs = append(diffs, fmt.Sprintf("receipt %d: expected exit code %s, got %s", i, exp.ExitCode, got.ExitCode))
+		}
+		if !bytes.Equal(exp.Return, got.Return) {
+			diffs = append(diffs, fmt.Sprintf("receipt %d: expected return %x, got %x", i, exp.Return, got.Return))
+		}
+		if chain.BigCmp(exp.GasUsed, got.GasUsed) != 0 {
+			diffs = append(diffs, fmt.Sprintf("receipt %d: expected gas used %s, got %s", i, exp.GasUsed, got.GasUsed))
+		}
+	}
+
+	if res.PostStateRoot != v.PostStateRoot {
+		diffs = append(diffs, fmt.Sprintf("expected post-state root %s, got %s", v.PostStateRoot, res.PostStateRoot))
+	}
+
+	return diffs
+}
+
+// Run executes the vector and diffs the result against its expectations
+func Run(ctx context.Context, v *TestVector) ([]string, error) {
+	res, err := Execute(ctx, v)
+	if err != nil {
+		return nil, err
+	}
+
+	return Diff(v, res), nil
+}
@@ -0,0 +1,151 @@
+package vectors
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dsync "github.com/ipfs/go-datastore/sync"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+
+	"github.com/zgfzgf/mid-lotus/chain"
+	"github.com/zgfzgf/mid-lotus/lib/car"
+)
+
+// transferVector builds a vector sending funds from the genesis miner to the
+// network account. The expectations come from applying the message directly on the
+// genesis blockstore, without going through the CAR pre-state.
+func transferVector(t *testing.T) *TestVector {
+	t.Helper()
+	ctx := context.Background()
+
+	bs := blockstore.NewIdStore(blockstore.NewBlockstore(dsync.MutexWrap(datastore.NewMapDatastore())))
+	w, err := chain.NewWallet(chain.NewMemKeystore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gen, err := chain.MakeGenesisBlock(bs, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	v := &TestVector{
+		Description:  "transfer to the network account",
+		Height:       1,
+		Miner:        gen.MinerKey,
+		PreStateRoot: gen.Genesis.StateRoot,
+		Messages: []*chain.Message{{
+			To:       chain.NetworkAddress,
+			From:     gen.MinerKey,
+			Nonce:    0,
+			Value:    chain.NewInt(100),
+			GasPrice: chain.NewInt(1),
+			GasLimit: chain.NewInt(10000),
+		}},
+	}
+
+	buf := new(bytes.Buffer)
+	if err := car.WriteCar(ctx, bs, []cid.Cid{v.PreStateRoot}, buf); err != nil {
+		t.Fatal(err)
+	}
+	v.PreStateCar = buf.Bytes()
+
+	cs := chain.NewChainStore(bs, dsync.MutexWrap(datastore.NewMapDatastore()))
+	vm, err := chain.NewVM(v.PreStateRoot, v.Height, v.Miner, cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, m := range v.Messages {
+		rct, err := vm.ApplyMessage(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v.Receipts = append(v.Receipts, rct)
+	}
+	v.PostStateRoot, err = vm.Flush(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Receipts[0].ExitCode != 0 {
+		t.Fatalf("transfer failed with exit code %d", v.Receipts[0].ExitCode)
+	}
+	if v.PostStateRoot == v.PreStateRoot {
+		t.Fatal("transfer didn't change the state")
+	}
+
+	return v
+}
+
+func TestRunMatchingVector(t *testing.T) {
+	v := transferVector(t)
+
+	// round trip through JSON like a committed vector
+	dir, err := ioutil.TempDir("", "vectors")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) //nolint:errcheck
+
+	path := filepath.Join(dir, "transfer.json")
+	if err := SaveVector(path, v); err != nil {
+		t.Fatal(err)
+	}
+	v, err = LoadVector(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	diffs, err := Run(context.Background(), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range diffs {
+		t.Error(d)
+	}
+}
+
+func TestDiffMismatch(t *testing.T) {
+	v := transferVector(t)
+
+	res, err := Execute(context.Background(), v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diffs := Diff(v, res); len(diffs) != 0 {
+		t.Fatalf("unexpected diffs %v", diffs)
+	}
+
+	cases := []struct {
+		name   string
+		mutate func(v *TestVector)
+		expect string
+	}{
+		{"exit code", func(v *TestVector) { v.Receipts[0].ExitCode = 1 }, "expected exit code"},
+		{"return", func(v *TestVector) { v.Receipts[0].Return = []byte("x") }, "expected return"},
+		{"gas used", func(v *TestVector) { v.Receipts[0].GasUsed = chain.BigAdd(v.Receipts[0].GasUsed, chain.NewInt(1)) }, "expected gas used"},
+		{"post-state", func(v *TestVector) { v.PostStateRoot = v.PreStateRoot }, "expected post-state root"},
+		{"receipt count", func(v *TestVector) { v.Receipts = append(v.Receipts, v.Receipts[0]) }, "expected 2 receipts, got 1"},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			exp := *v
+			rct := *v.Receipts[0]
+			exp.Receipts = []*chain.MessageReceipt{&rct}
+			c.mutate(&exp)
+
+			diffs := Diff(&exp, res)
+			if len(diffs) != 1 || !strings.Contains(diffs[0], c.expect) {
+				t.Fatalf("expected a single %q diff, got %v", c.expect, diffs)
+			}
+		})
+	}
+}
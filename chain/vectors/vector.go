@@ -0,0 +1,59 @@
+package vectors
+
+import (
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/ipfs/go-cid"
+
+	"github.com/zgfzgf/mid-lotus/chain"
+	"github.com/zgfzgf/mid-lotus/chain/address"
+)
+
+// TestVector describes a VM state transition: a pre-state, the messages
+// applied on top of it, and the receipts and post-state they must produce.
+//
+// Vectors are stored as JSON. PreStateCar holds the pre-state DAG in the
+// CARv1 format, rooted at PreStateRoot.
+type TestVector struct {
+	Description string
+
+	Height uint64
+	Miner  address.Address
+
+	PreStateCar  []byte
+	PreStateRoot cid.Cid
+
+	Messages []*chain.Message
+
+	// ApplyCron runs the end of tipset cron tick after the messages
+	ApplyCron bool
+
+	Receipts      []*chain.MessageReceipt
+	PostStateRoot cid.Cid
+}
+
+// LoadVector reads a test vector from a JSON file
+func LoadVector(path string) (*TestVector, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var v TestVector
+	if err := json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+
+	return &v, nil
+}
+
+// SaveVector writes a test vector to a JSON file
+func SaveVector(path string, v *TestVector) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0644)
+}
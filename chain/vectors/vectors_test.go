@@ -0,0 +1,38 @@
+package vectors
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+)
+
+// TestConformance runs every vector in testdata. Vectors are recorded from a
+// live node with `lotus state record-vector`.
+func TestConformance(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(files) == 0 {
+		t.Skip("no test vectors in testdata")
+	}
+
+	for _, f := range files {
+		t.Run(filepath.Base(f), func(t *testing.T) {
+			v, err := LoadVector(f)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			diffs, err := Run(context.Background(), v)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for _, d := range diffs {
+				t.Error(d)
+			}
+		})
+	}
+}
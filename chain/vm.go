@@ -2,25 +2,44 @@ package chain
 
 import (
 	"context"
-	"fmt"
-	"math/big"
 
 	"github.com/zgfzgf/mid-lotus/chain/address"
+	"github.com/zgfzgf/mid-lotus/chain/aerrors"
 	"github.com/zgfzgf/mid-lotus/lib/bufbstore"
 
-	bserv "github.com/ipfs/go-blockservice"
 	cid "github.com/ipfs/go-cid"
 	hamt "github.com/ipfs/go-hamt-ipld"
-	ipld "github.com/ipfs/go-ipld-format"
-	dag "github.com/ipfs/go-merkledag"
+	bstore "github.com/ipfs/go-ipfs-blockstore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	"github.com/multiformats/go-multihash"
 	"github.com/pkg/errors"
 )
 
+// The gas schedule prices the work applying a message takes, so that senders
+// pay for it and a message's gas limit bounds it. Without it every message
+// uses no gas, and gas limits can't be estimated.
+const (
+	gasOnChainMessageBase    = 100
+	gasOnChainMessagePerByte = 1
+	gasSend                  = 10
+	gasStorageGet            = 10
+	gasStoragePutPerByte     = 2
+)
+
+type gasMeter struct {
+	limit BigInt
+	used  BigInt
+}
+
 type VMContext struct {
+	vm     *VM
 	state  *StateTree
 	msg    *Message
 	height uint64
 	cst    *hamt.CborIpldStore
+
+	gas   *gasMeter
+	trace *ExecutionTrace
 }
 
 // Message is the message that kicked off the current invocation
@@ -39,9 +58,85 @@ func (vmc *VMContext) Ipld() *hamt.CborIpldStore {
 	return vmc.cst
 }
 
-// Send allows the current execution context to invoke methods on other actors in the system
-func (vmc *VMContext) Send(to address.Address, method string, value *big.Int, params []interface{}) ([][]byte, uint8, error) {
-	panic("nyi")
+// StorageGet loads the actor state object with the given cid into out
+func (vmc *VMContext) StorageGet(c cid.Cid, out interface{}) aerrors.ActorError {
+	if err := vmc.ChargeGas("StorageGet", gasStorageGet); err != nil {
+		return err
+	}
+
+	if vmc.trace != nil {
+		vmc.trace.StateOps = append(vmc.trace.StateOps, &StateOp{Cid: c})
+	}
+
+	if err := vmc.cst.Get(context.TODO(), c, out); err != nil {
+		return aerrors.Absorb(err, aerrors.ErrIllegalState, "failed to load actor state")
+	}
+	return nil
+}
+
+// StoragePut stores an actor state object and returns its cid
+func (vmc *VMContext) StoragePut(obj interface{}) (cid.Cid, aerrors.ActorError) {
+	data, err := cbor.DumpObject(obj)
+	if err != nil {
+		return cid.Undef, aerrors.Absorb(err, aerrors.ErrIllegalState, "failed to serialize actor state")
+	}
+
+	if err := vmc.ChargeGas("StoragePut", uint64(len(data))*gasStoragePutPerByte); err != nil {
+		return cid.Undef, err
+	}
+
+	c, err := vmc.cst.Put(context.TODO(), obj)
+	if err != nil {
+		return cid.Undef, aerrors.Escalate(err, "failed to store actor state")
+	}
+
+	if vmc.trace != nil {
+		vmc.trace.StateOps = append(vmc.trace.StateOps, &StateOp{Write: true, Cid: c})
+	}
+	return c, nil
+}
+
+// Send allows the current execution context to invoke methods on other actors in the system.
+// If the call fails, its state changes are reverted. Actors loaded before the
+// call must be loaded again after it.
+func (vmc *VMContext) Send(to address.Address, method uint64, value BigInt, params []byte) ([]byte, aerrors.ActorError) {
+	if err := vmc.ChargeGas("Send", gasSend); err != nil {
+		return nil, err
+	}
+
+	if err := vmc.state.Snapshot(); err != nil {
+		return nil, aerrors.Escalate(err, "state snapshot failed")
+	}
+
+	msg := &Message{
+		From:     vmc.msg.To,
+		To:       to,
+		Method:   method,
+		Value:    value,
+		Params:   params,
+		GasPrice: vmc.msg.GasPrice,
+		GasLimit: vmc.msg.GasLimit,
+	}
+
+	nvmctx := vmc.vm.makeVMContext(vmc.state, msg, vmc.gas)
+	if vmc.trace != nil {
+		nvmctx.trace = &ExecutionTrace{Msg: msg}
+		vmc.trace.Subcalls = append(vmc.trace.Subcalls, nvmctx.trace)
+	}
+
+	start := vmc.GasUsed()
+	ret, err := vmc.vm.send(nvmctx)
+	nvmctx.trace.finish(ret, BigSub(vmc.GasUsed(), start), err)
+
+	if err != nil && !err.IsFatal() {
+		if rerr := vmc.state.Revert(); rerr != nil {
+			return nil, aerrors.Escalate(rerr, "state revert failed")
+		}
+		return nil, err
+	}
+	vmc.state.ClearSnapshot()
+
+	return ret, err
 }
 
 // BlockHeight returns the height of the block this message was added to the chain in
@@ -49,15 +144,44 @@ func (vmc *VMContext) BlockHeight() uint64 {
 	return vmc.height
 }
 
+// ChargeGas charges the given amount of gas to the message being applied. It
+// returns an out of gas error once the message gas limit is exceeded.
+func (vmc *VMContext) ChargeGas(name string, amount uint64) aerrors.ActorError {
+	toUse := NewInt(amount)
+	if vmc.trace != nil {
+		vmc.trace.GasCharges = append(vmc.trace.GasCharges, &GasTrace{
+			Name:   name,
+			Amount: toUse,
+		})
+	}
+
+	vmc.gas.used = BigAdd(vmc.gas.used, toUse)
+	if BigCmp(vmc.gas.used, vmc.gas.limit) > 0 {
+		vmc.gas.used = vmc.gas.limit
+		return aerrors.Newf(aerrors.SysErrOutOfGas, "not enough gas for %s (limit %s)", name, vmc.gas.limit)
+	}
+	return nil
+}
+
 func (vmc *VMContext) GasUsed() BigInt {
-	return NewInt(0)
+	return vmc.gas.used
 }
 
-func makeVMContext(state *StateTree, msg *Message, height uint64) *VMContext {
+func (vm *VM) makeVMContext(state *StateTree, msg *Message, gas *gasMeter) *VMContext {
+	if gas == nil {
+		gas = &gasMeter{
+			limit: msg.GasLimit,
+			used:  NewInt(0),
+		}
+	}
+
 	return &VMContext{
+		vm:     vm,
 		state:  state,
 		msg:    msg,
-		height: height,
+		height: vm.blockHeight,
+		cst:    state.store,
+		gas:    gas,
 	}
 }
 
@@ -68,6 +192,7 @@ type VM struct {
 	buf         *bufbstore.BufferedBS
 	blockHeight uint64
 	blockMiner  address.Address
+	inv         *invoker
 }
 
 func NewVM(base cid.Cid, height uint64, maddr address.Address, cs *ChainStore) (*VM, error) {
@@ -85,102 +210,282 @@ func NewVM(base cid.Cid, height uint64, maddr address.Address, cs *ChainStore) (
 		buf:         buf,
 		blockHeight: height,
 		blockMiner:  maddr,
+		inv:         newInvoker(),
 	}, nil
 }
 
+// ErrMessageInvalid is returned by ApplyMessage for messages which can't be
+// included in a block at all, blocks containing them are invalid
+var ErrMessageInvalid = errors.New("invalid message")
+
+// ApplyMessage applies msg on top of the current state. Messages which fail
+// produce a receipt with a non-zero exit code. An error is returned when the
+// message is invalid (see ErrMessageInvalid), or the failure is fatal, and
+// the block can't be processed.
 func (vm *VM) ApplyMessage(msg *Message) (*MessageReceipt, error) {
+	rct, _, err := vm.applyMessage(msg, false)
+	return rct, err
+}
+
+// ApplyMessageTraced is like ApplyMessage, but also records and returns an
+// execution trace of the message
+func (vm *VM) ApplyMessageTraced(msg *Message) (*MessageReceipt, *ExecutionTrace, error) {
+	return vm.applyMessage(msg, true)
+}
+
+func (vm *VM) applyMessage(msg *Message, trace bool) (*MessageReceipt, *ExecutionTrace, error) {
+	var et *ExecutionTrace
+	if trace {
+		et = &ExecutionTrace{Msg: msg}
+	}
 	st := vm.cstate
-	st.Snapshot()
 	fromActor, err := st.GetActor(msg.From)
 	if err != nil {
-		return nil, errors.Wrap(err, "from actor not found")
-	}
-
-	gascost := BigMul(msg.GasLimit, msg.GasPrice)
-	totalCost := BigAdd(gascost, msg.Value)
-	if BigCmp(fromActor.Balance, totalCost) < 0 {
-		return nil, fmt.Errorf("not enough funds")
+		if err == ErrActorNotFound {
+			return nil, nil, errors.Wrapf(ErrMessageInvalid, "sender %s doesn't exist", msg.From)
+		}
+		return nil, nil, errors.Wrap(err, "failed to load from actor")
 	}
 
 	if msg.Nonce != fromActor.Nonce {
-		return nil, fmt.Errorf("invalid nonce")
+		return nil, nil, errors.Wrapf(ErrMessageInvalid, "nonce %d from %s, expected %d", msg.Nonce, msg.From, fromActor.Nonce)
 	}
 	fromActor.Nonce++
 
-	toActor, err := st.GetActor(msg.To)
-	if err != nil {
-		if err == ErrActorNotFound {
-			a, err := TryCreateAccountActor(st, msg.To)
-			if err != nil {
-				return nil, err
-			}
-			toActor = a
-		} else {
-			return nil, err
+	gascost := BigMul(msg.GasLimit, msg.GasPrice)
+	totalCost := BigAdd(gascost, msg.Value)
+	if BigCmp(fromActor.Balance, totalCost) < 0 {
+		rct, err := vm.chargeInsufficientFunds(fromActor, msg)
+		if err != nil {
+			return nil, nil, err
+		}
+		if et != nil {
+			et.MsgRct = rct
+			et.Error = rct.ExitCode.String()
 		}
+		return rct, et, nil
 	}
 
-	if err := DeductFunds(fromActor, totalCost); err != nil {
-		return nil, errors.Wrap(err, "failed to deduct funds")
+	if err := DeductFunds(fromActor, gascost); err != nil {
+		return nil, nil, errors.Wrap(err, "failed to deduct gas")
 	}
-	DepositFunds(toActor, msg.Value)
 
-	vmctx := makeVMContext(st, msg, vm.blockHeight)
+	// Everything after this point is reverted if the message fails, the nonce
+	// increment and the gas payment above are kept.
+	if err := st.Snapshot(); err != nil {
+		return nil, nil, errors.Wrap(err, "state snapshot failed")
+	}
+
+	vmctx := vm.makeVMContext(st, msg, nil)
+	vmctx.trace = et
 
-	var errcode byte
 	var ret []byte
-	if msg.Method != 0 {
-		ret, errcode, err = vm.Invoke(toActor, vmctx, msg.Method, msg.Params)
-		if err != nil {
-			return nil, err
+	aerr := vmctx.ChargeGas("OnChainMessage", onChainMessageGas(msg))
+	if aerr == nil {
+		ret, aerr = vm.send(vmctx)
+	}
+	if aerr != nil {
+		if aerr.IsFatal() {
+			return nil, nil, aerr
 		}
 
-		if errcode != 0 {
-			// revert all state changes since snapshot
-			st.Revert()
-			gascost := BigMul(vmctx.GasUsed(), msg.GasPrice)
-			if err := DeductFunds(fromActor, gascost); err != nil {
-				panic("invariant violated: " + err.Error())
-			}
-		} else {
-			// refund unused gas
-			refund := BigMul(BigSub(msg.GasLimit, vmctx.GasUsed()), msg.GasPrice)
-			DepositFunds(fromActor, refund)
+		log.Infof("message %d from %s failed: %s", msg.Nonce, msg.From, aerr)
+		if err := st.Revert(); err != nil {
+			return nil, nil, errors.Wrap(err, "state revert failed")
 		}
+		ret = nil
+	} else {
+		st.ClearSnapshot()
 	}
 
+	// refund unused gas
+	fromActor, err = st.GetActor(msg.From)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "failed to reload from actor")
+	}
+	refund := BigMul(BigSub(msg.GasLimit, vmctx.GasUsed()), msg.GasPrice)
+	DepositFunds(fromActor, refund)
+
 	// reward miner gas fees
 	miner, err := st.GetActor(vm.blockMiner)
 	if err != nil {
-		return nil, errors.Wrap(err, "getting block miner actor failed")
+		return nil, nil, errors.Wrap(err, "getting block miner actor failed")
 	}
 
 	gasReward := BigMul(msg.GasPrice, vmctx.GasUsed())
 	DepositFunds(miner, gasReward)
 
+	et.finish(ret, vmctx.GasUsed(), aerr)
+
 	return &MessageReceipt{
-		ExitCode: errcode,
+		ExitCode: aerrors.RetCode(aerr),
 		Return:   ret,
 		GasUsed:  vmctx.GasUsed(),
+	}, et, nil
+}
+
+// chargeInsufficientFunds charges a message whose sender can't cover its gas
+// limit and value the gas for including it, or as much of it as the sender
+// can pay, and fails it. The nonce has already been bumped, so the message
+// can't be included again.
+func (vm *VM) chargeInsufficientFunds(fromActor *Actor, msg *Message) (*MessageReceipt, error) {
+	gasUsed := NewInt(onChainMessageGas(msg))
+	if msg.GasPrice.Sign() > 0 {
+		affordable := BigDiv(fromActor.Balance, msg.GasPrice)
+		if BigCmp(affordable, gasUsed) < 0 {
+			gasUsed = affordable
+		}
+	}
+	fee := BigMul(gasUsed, msg.GasPrice)
+
+	if err := DeductFunds(fromActor, fee); err != nil {
+		return nil, errors.Wrap(err, "failed to deduct gas")
+	}
+
+	miner, err := vm.cstate.GetActor(vm.blockMiner)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting block miner actor failed")
+	}
+	DepositFunds(miner, fee)
+
+	return &MessageReceipt{
+		ExitCode: aerrors.SysErrInsufficientFunds,
+		GasUsed:  gasUsed,
 	}, nil
 }
 
-func (vm *VM) Flush(ctx context.Context) (cid.Cid, error) {
-	from := dag.NewDAGService(bserv.New(vm.buf, nil))
-	to := dag.NewDAGService(bserv.New(vm.buf.Read(), nil))
+func onChainMessageGas(msg *Message) uint64 {
+	size := 0
+	if data, err := msg.Serialize(); err == nil {
+		size = len(data)
+	}
+	return gasOnChainMessageBase + uint64(size)*gasOnChainMessagePerByte
+}
+
+// send transfers the message value and invokes the receiver's method
+func (vm *VM) send(vmctx *VMContext) ([]byte, aerrors.ActorError) {
+	st := vmctx.state
+	msg := vmctx.msg
+
+	toActor, err := st.GetActor(msg.To)
+	if err != nil {
+		if err != ErrActorNotFound {
+			return nil, aerrors.Escalate(err, "failed to load to actor")
+		}
+
+		a, aerr := TryCreateAccountActor(st, msg.To)
+		if aerr != nil {
+			return nil, aerr
+		}
+		toActor = a
+	}
+
+	fromActor, err := st.GetActor(msg.From)
+	if err != nil {
+		return nil, aerrors.Escalate(err, "failed to load from actor")
+	}
+
+	if err := DeductFunds(fromActor, msg.Value); err != nil {
+		return nil, aerrors.Absorb(err, aerrors.SysErrInsufficientFunds, "failed to deduct funds")
+	}
+	DepositFunds(toActor, msg.Value)
+
+	if msg.Method == 0 {
+		return nil, nil
+	}
+
+	return vm.Invoke(toActor, vmctx, msg.Method, msg.Params)
+}
 
+// ApplyCronTick applies the implicit system message that runs the registered
+// cron handlers. It must be called once, after all messages of the tipset have
+// been applied. The returned receipt is not part of the block's receipts.
+func (vm *VM) ApplyCronTick() (*MessageReceipt, error) {
+	msg := cronTickMessage(vm.blockHeight)
+
+	cronActor, err := vm.cstate.GetActor(msg.To)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting cron actor failed")
+	}
+
+	if err := vm.cstate.Snapshot(); err != nil {
+		return nil, errors.Wrap(err, "state snapshot failed")
+	}
+
+	// each cron handler is called through Send, which reverts the handler's
+	// changes if it fails
+	vmctx := vm.makeVMContext(vm.cstate, msg, nil)
+	ret, aerr := vm.Invoke(cronActor, vmctx, msg.Method, msg.Params)
+	if aerr != nil {
+		if aerr.IsFatal() {
+			return nil, errors.Wrap(aerr, "cron tick failed")
+		}
+		if err := vm.cstate.Revert(); err != nil {
+			return nil, errors.Wrap(err, "state revert failed")
+		}
+	} else {
+		vm.cstate.ClearSnapshot()
+	}
+
+	return &MessageReceipt{
+		ExitCode: aerrors.RetCode(aerr),
+		Return:   ret,
+		GasUsed:  vmctx.GasUsed(),
+	}, nil
+}
+
+func (vm *VM) Flush(ctx context.Context) (cid.Cid, error) {
 	root, err := vm.cstate.Flush()
 	if err != nil {
 		return cid.Undef, err
 	}
 
-	if err := ipld.Copy(ctx, from, to, root); err != nil {
-		return cid.Undef, err
+	if err := copyBlocks(ctx, vm.buf, vm.buf.Read(), root); err != nil {
+		return cid.Undef, errors.Wrap(err, "copying state to the blockstore")
 	}
 
 	return root, nil
 }
 
+// copyBlocks copies the DAG under root from one blockstore to another. Only
+// dag-cbor blocks are traversed for links, identity hashed cids are skipped,
+// and so are subtrees whose root is already in the destination.
+func copyBlocks(ctx context.Context, from, to bstore.Blockstore, root cid.Cid) error {
+	if root.Prefix().MhType == multihash.ID {
+		return nil
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	has, err := to.Has(root)
+	if err != nil || has {
+		return err
+	}
+
+	blk, err := from.Get(root)
+	if err != nil {
+		return errors.Wrapf(err, "getting block %s", root)
+	}
+
+	if root.Type() == cid.DagCBOR {
+		nd, err := cbor.DecodeBlock(blk)
+		if err != nil {
+			return err
+		}
+
+		for _, l := range nd.Links() {
+			if err := copyBlocks(ctx, from, to, l.Cid); err != nil {
+				return err
+			}
+		}
+	}
+
+	// children go first, so a block in the destination always has its DAG
+	return to.Put(blk)
+}
+
 func (vm *VM) TransferFunds(from, to address.Address, amt BigInt) error {
 	if from == to {
 		return nil
@@ -191,7 +496,7 @@ func (vm *VM) TransferFunds(from, to address.Address, amt BigInt) error {
 		return err
 	}
 
-	toAct, err := vm.cstate.GetActor(from)
+	toAct, err := vm.cstate.GetActor(to)
 	if err != nil {
 		return err
 	}
@@ -204,6 +509,6 @@ func (vm *VM) TransferFunds(from, to address.Address, amt BigInt) error {
 	return nil
 }
 
-func (vm *VM) Invoke(act *Actor, vmctx *VMContext, method uint64, params []byte) ([]byte, byte, error) {
-	panic("Implement me")
+func (vm *VM) Invoke(act *Actor, vmctx *VMContext, method uint64, params []byte) ([]byte, aerrors.ActorError) {
+	return vm.inv.Invoke(act, vmctx, method, params)
 }
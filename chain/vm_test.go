@@ -0,0 +1,409 @@
+package chain
+
+import (
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	dsync "github.com/ipfs/go-datastore/sync"
+	hamt "github.com/ipfs/go-hamt-ipld"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/pkg/errors"
+
+	"github.com/zgfzgf/mid-lotus/build"
+	"github.com/zgfzgf/mid-lotus/chain/address"
+	"github.com/zgfzgf/mid-lotus/chain/aerrors"
+)
+
+// testGenesisEpochs is how many epochs ago test chains start
+const testGenesisEpochs = 100
+
+type testChain struct {
+	cs    *ChainStore
+	w     *Wallet
+	miner address.Address
+
+	// accounts are funded secp256k1 accounts, their keys are in w
+	accounts []address.Address
+}
+
+// newTestChain creates a chain store holding a fresh genesis block, the
+// genesis miner's key is in the returned wallet
+func newTestChain(t *testing.T) *testChain {
+	return newTestChainWithAccounts(t, 0)
+}
+
+// newTestChainWithAccounts is newTestChain with n more accounts funded in the
+// genesis state
+func newTestChainWithAccounts(t *testing.T, n int) *testChain {
+	t.Helper()
+
+	bs := blockstore.NewBlockstore(dsync.MutexWrap(datastore.NewMapDatastore()))
+	w, err := NewWallet(NewMemKeystore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gen, err := MakeGenesisBlock(bs, w)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// start the chain in the past, so blocks can be mined without waiting
+	gen.Genesis.Timestamp -= testGenesisEpochs * build.BlockDelay
+
+	var accounts []address.Address
+	if n > 0 {
+		cst := hamt.CSTFromBstore(bs)
+		st, err := LoadStateTree(cst, gen.Genesis.StateRoot)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		miner, err := st.GetActor(gen.MinerKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < n; i++ {
+			addr, err := w.GenerateKey(KTSecp256k1)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = st.RegisterNewAddress(addr, &Actor{
+				Code:    AccountActorCodeCid,
+				Balance: NewInt(1000000),
+				Head:    miner.Head,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			accounts = append(accounts, addr)
+		}
+
+		gen.Genesis.StateRoot, err = st.Flush()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cs := NewChainStore(bs, dsync.MutexWrap(datastore.NewMapDatastore()))
+	if err := cs.SetGenesis(gen.Genesis); err != nil {
+		t.Fatal(err)
+	}
+
+	return &testChain{cs: cs, w: w, miner: gen.MinerKey, accounts: accounts}
+}
+
+func (tc *testChain) vm(t *testing.T) *VM {
+	t.Helper()
+
+	ts := tc.cs.GetHeaviestTipSet()
+	st, err := tc.cs.TipSetState(ts.Cids())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	vm, err := NewVM(st, ts.Height()+1, NetworkAddress, tc.cs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vm
+}
+
+func balance(t *testing.T, vm *VM, addr address.Address) BigInt {
+	t.Helper()
+
+	act, err := vm.cstate.GetActor(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return act.Balance
+}
+
+// sign signs msg with the key of its sender from the test wallet
+func (tc *testChain) sign(t *testing.T, msg *Message) *SignedMessage {
+	t.Helper()
+
+	data, err := msg.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sig, err := tc.w.Sign(msg.From, data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &SignedMessage{
+		Message:   *msg,
+		Signature: *sig,
+	}
+}
+
+func TestGasUsedAndBalances(t *testing.T) {
+	tc := newTestChain(t)
+	vm := tc.vm(t)
+
+	to, err := tc.w.GenerateKey(KTSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fromBal := balance(t, vm, tc.miner)
+	netBal := balance(t, vm, NetworkAddress)
+
+	msg := &Message{
+		From:     tc.miner,
+		To:       to,
+		Nonce:    0,
+		Value:    NewInt(1000),
+		GasPrice: NewInt(2),
+		GasLimit: NewInt(10000),
+	}
+
+	rct, err := vm.ApplyMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rct.ExitCode != aerrors.Ok {
+		t.Fatalf("message failed: %s", rct.ExitCode)
+	}
+
+	exp := NewInt(onChainMessageGas(msg))
+	if BigCmp(rct.GasUsed, exp) != 0 {
+		t.Fatalf("expected %s gas used, got %s", exp, rct.GasUsed)
+	}
+
+	fee := BigMul(rct.GasUsed, msg.GasPrice)
+	if b := balance(t, vm, tc.miner); BigCmp(b, BigSub(BigSub(fromBal, msg.Value), fee)) != 0 {
+		t.Fatalf("sender balance %s, expected %s - %s - %s", b, fromBal, msg.Value, fee)
+	}
+	if b := balance(t, vm, to); BigCmp(b, msg.Value) != 0 {
+		t.Fatalf("receiver balance %s, expected %s", b, msg.Value)
+	}
+	if b := balance(t, vm, NetworkAddress); BigCmp(b, BigAdd(netBal, fee)) != 0 {
+		t.Fatalf("block miner balance %s, expected %s + %s", b, netBal, fee)
+	}
+}
+
+func TestOutOfGas(t *testing.T) {
+	tc := newTestChain(t)
+	vm := tc.vm(t)
+
+	to, err := tc.w.GenerateKey(KTSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fromBal := balance(t, vm, tc.miner)
+
+	msg := &Message{
+		From:     tc.miner,
+		To:       to,
+		Nonce:    0,
+		Value:    NewInt(1000),
+		GasPrice: NewInt(3),
+		GasLimit: NewInt(10),
+	}
+
+	rct, err := vm.ApplyMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rct.ExitCode != aerrors.SysErrOutOfGas {
+		t.Fatalf("expected out of gas, got %s", rct.ExitCode)
+	}
+	if BigCmp(rct.GasUsed, msg.GasLimit) != 0 {
+		t.Fatalf("expected the whole gas limit to be used, got %s", rct.GasUsed)
+	}
+
+	// the value isn't transferred, but the gas is paid
+	fee := BigMul(msg.GasLimit, msg.GasPrice)
+	if b := balance(t, vm, tc.miner); BigCmp(b, BigSub(fromBal, fee)) != 0 {
+		t.Fatalf("sender balance %s, expected %s - %s", b, fromBal, fee)
+	}
+
+	act, err := vm.cstate.GetActor(tc.miner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if act.Nonce != 1 {
+		t.Fatalf("expected nonce to be bumped, got %d", act.Nonce)
+	}
+}
+
+func TestEstimateGasLimit(t *testing.T) {
+	tc := newTestChain(t)
+
+	to, err := tc.w.GenerateKey(KTSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := &Message{
+		From:  tc.miner,
+		To:    to,
+		Value: NewInt(1000),
+	}
+
+	est, err := tc.cs.EstimateGasLimit(msg, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cmsg := *msg
+	cmsg.GasLimit = NewInt(CallGasLimit)
+	cmsg.GasPrice = NewInt(0)
+	used := onChainMessageGas(&cmsg)
+	if exp := used + used*GasEstimateMarginPercent/100; est.Uint64() != exp {
+		t.Fatalf("expected estimate %d, got %s", exp, est)
+	}
+}
+
+func TestInvalidMessagesRejected(t *testing.T) {
+	tc := newTestChain(t)
+	vm := tc.vm(t)
+
+	nobody, err := tc.w.GenerateKey(KTSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, msg := range map[string]*Message{
+		"bad nonce": {
+			From: tc.miner, To: nobody, Nonce: 5,
+			Value: NewInt(1), GasPrice: NewInt(1), GasLimit: NewInt(10000),
+		},
+		"missing sender": {
+			From: nobody, To: tc.miner, Nonce: 0,
+			Value: NewInt(1), GasPrice: NewInt(1), GasLimit: NewInt(10000),
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := vm.ApplyMessage(msg)
+			if errors.Cause(err) != ErrMessageInvalid {
+				t.Fatalf("expected ErrMessageInvalid, got %v", err)
+			}
+		})
+	}
+}
+
+func TestInsufficientFundsCharged(t *testing.T) {
+	tc := newTestChain(t)
+	vm := tc.vm(t)
+
+	fromBal := balance(t, vm, tc.miner)
+	netBal := balance(t, vm, NetworkAddress)
+
+	msg := &Message{
+		From:     tc.miner,
+		To:       NetworkAddress,
+		Nonce:    0,
+		Value:    BigAdd(fromBal, NewInt(1)),
+		GasPrice: NewInt(2),
+		GasLimit: NewInt(10000),
+	}
+
+	rct, err := vm.ApplyMessage(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rct.ExitCode != aerrors.SysErrInsufficientFunds {
+		t.Fatalf("expected insufficient funds, got %s", rct.ExitCode)
+	}
+
+	fee := BigMul(NewInt(onChainMessageGas(msg)), msg.GasPrice)
+	if BigCmp(rct.GasUsed, NewInt(onChainMessageGas(msg))) != 0 {
+		t.Fatalf("unexpected gas used %s", rct.GasUsed)
+	}
+	if b := balance(t, vm, tc.miner); BigCmp(b, BigSub(fromBal, fee)) != 0 {
+		t.Fatalf("sender balance %s, expected %s - %s", b, fromBal, fee)
+	}
+	if b := balance(t, vm, NetworkAddress); BigCmp(b, BigAdd(netBal, fee)) != 0 {
+		t.Fatalf("block miner balance %s, expected %s + %s", b, netBal, fee)
+	}
+
+	// the message can't be replayed
+	if _, err := vm.ApplyMessage(msg); errors.Cause(err) != ErrMessageInvalid {
+		t.Fatalf("expected replay to be invalid, got %v", err)
+	}
+}
+
+func TestSendRevertsOnFailure(t *testing.T) {
+	tc := newTestChain(t)
+	vm := tc.vm(t)
+
+	fromBal := balance(t, vm, tc.miner)
+
+	vmctx := vm.makeVMContext(vm.cstate, &Message{
+		From:     NetworkAddress,
+		To:       tc.miner,
+		GasPrice: NewInt(0),
+		GasLimit: NewInt(10000),
+	}, nil)
+
+	// the value is transferred before the call fails, as account actors
+	// have no methods
+	_, aerr := vmctx.Send(NetworkAddress, 5, NewInt(1000), nil)
+	if aerr == nil || aerr.IsFatal() {
+		t.Fatalf("expected a non-fatal error, got %v", aerr)
+	}
+
+	if b := balance(t, vm, tc.miner); BigCmp(b, fromBal) != 0 {
+		t.Fatalf("transfer wasn't reverted, balance %s, expected %s", b, fromBal)
+	}
+}
+
+func TestCronHandlerReverted(t *testing.T) {
+	tc := newTestChain(t)
+	vm := tc.vm(t)
+
+	cronLk.Lock()
+	saved := cronEntries
+	cronLk.Unlock()
+	defer func() {
+		cronLk.Lock()
+		cronEntries = saved
+		cronLk.Unlock()
+	}()
+
+	// a handler which writes state and then fails
+	code, err := cid.NewPrefixV1(cid.Raw, mh.ID).Sum([]byte("test-failing-cron"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.inv.register(code, nativeCode{
+		1: func(act *Actor, vmctx *VMContext, params []byte) ([]byte, aerrors.ActorError) {
+			err := vmctx.state.MutateActor(NetworkAddress, func(a *Actor) error {
+				DepositFunds(a, NewInt(12345))
+				return nil
+			})
+			if err != nil {
+				return nil, aerrors.Escalate(err, "mutating network actor")
+			}
+			return nil, aerrors.New(aerrors.ErrIllegalState, "handler failed")
+		},
+	})
+
+	addr, err := address.NewIDAddress(999)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := vm.cstate.SetActor(addr, &Actor{Code: code, Balance: NewInt(0), Head: EmptyObjectCid}); err != nil {
+		t.Fatal(err)
+	}
+	RegisterCronEntry(addr, 1)
+
+	netBal := balance(t, vm, NetworkAddress)
+
+	if _, err := vm.ApplyCronTick(); err != nil {
+		t.Fatal(err)
+	}
+
+	if b := balance(t, vm, NetworkAddress); BigCmp(b, netBal) != 0 {
+		t.Fatalf("cron handler changes weren't reverted, balance %s, expected %s", b, netBal)
+	}
+}
@@ -3,12 +3,17 @@ package chain
 import (
 	"encoding/binary"
 	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/zgfzgf/mid-lotus/chain/address"
 	"github.com/zgfzgf/mid-lotus/lib/bls-signatures"
 	"github.com/zgfzgf/mid-lotus/lib/crypto"
 
 	"github.com/minio/blake2b-simd"
+	"github.com/pkg/errors"
 )
 
 const (
@@ -16,12 +21,79 @@ const (
 	KTBLS       = "bls"
 )
 
+// KDefault is the keystore name of the default address
+const KDefault = "default"
+
+// KTDefaultAddr is the key type of the KDefault entry, which holds the
+// default address in place of a private key
+const KTDefaultAddr = "default-address"
+
+// KNamePrefix prefixes the keystore names of wallet keys, followed by the
+// key's address
+const KNamePrefix = "wallet-"
+
 type Wallet struct {
-	keys map[address.Address]*KeyInfo
+	keys     map[address.Address]*KeyInfo
+	keystore Keystore
+
+	lk sync.Mutex
+
+	// hdSeed is nil until HDInit is called, hdLk serializes HD key
+	// derivation so two callers don't get the same index
+	hdSeed []byte
+	hdLk   sync.Mutex
+
+	// remote is set for wallets created with NewRemoteWallet
+	remote       RemoteSigner
+	remoteCache  []address.Address
+	remoteListed time.Time
+	remoteLk     sync.Mutex
+
+	// noExport is set by DisableExport
+	noExport bool
 }
 
-func NewWallet() *Wallet {
-	return &Wallet{keys: make(map[address.Address]*KeyInfo)}
+// NewWallet creates a wallet holding the keys in the keystore. New keys are
+// persisted to it.
+func NewWallet(ks Keystore) (*Wallet, error) {
+	w := &Wallet{
+		keys:     make(map[address.Address]*KeyInfo),
+		keystore: ks,
+	}
+
+	names, err := ks.List()
+	if err != nil {
+		return nil, errors.Wrap(err, "listing keys")
+	}
+
+	for _, name := range names {
+		if !strings.HasPrefix(name, KNamePrefix) {
+			continue
+		}
+
+		ki, err := ks.Get(name)
+		if err != nil {
+			return nil, errors.Wrapf(err, "loading key %s", name)
+		}
+
+		addr := ki.Address()
+		if name != KNamePrefix+addr.String() {
+			return nil, fmt.Errorf("key %s is stored under the wrong name, its address is %s", name, addr)
+		}
+
+		w.keys[addr] = &ki
+	}
+
+	seed, err := ks.Get(KHDSeed)
+	switch err {
+	case nil:
+		w.hdSeed = seed.PrivateKey
+	case ErrKeyNotFound:
+	default:
+		return nil, errors.Wrap(err, "loading HD seed")
+	}
+
+	return w, nil
 }
 
 type Signature struct {
@@ -38,6 +110,8 @@ func SignatureFromBytes(x []byte) (Signature, error) {
 	switch val {
 	case 1:
 		ts = KTSecp256k1
+	case 2:
+		ts = KTBLS
 	default:
 		return Signature{}, fmt.Errorf("unsupported signature type: %d", val)
 	}
@@ -48,11 +122,17 @@ func SignatureFromBytes(x []byte) (Signature, error) {
 	}, nil
 }
 
-func (s *Signature) Verify(addr address.Address, msg []byte) error {
-	b2sum := blake2b.Sum256(msg)
+// Bytes returns the signature encoded as its type code followed by the data
+func (s *Signature) Bytes() []byte {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(s.TypeCode()))
+	return append(buf[:n], s.Data...)
+}
 
+func (s *Signature) Verify(addr address.Address, msg []byte) error {
 	switch s.Type {
 	case KTSecp256k1:
+		b2sum := blake2b.Sum256(msg)
 		pubk, err := crypto.EcRecover(b2sum[:], s.Data)
 		if err != nil {
 			return err
@@ -67,6 +147,26 @@ func (s *Signature) Verify(addr address.Address, msg []byte) error {
 			return fmt.Errorf("signature did not match")
 		}
 
+		return nil
+	case KTBLS:
+		if addr.Protocol() != address.BLS {
+			return fmt.Errorf("bls signature for non-bls address %s", addr)
+		}
+
+		var sig bls.Signature
+		if len(s.Data) != len(sig) {
+			return fmt.Errorf("bls signature has wrong length: %d", len(s.Data))
+		}
+		copy(sig[:], s.Data)
+
+		var pub bls.PublicKey
+		copy(pub[:], addr.Payload())
+
+		digest := bls.Hash(bls.Message(msg))
+		if !bls.Verify(sig, []bls.Digest{digest}, []bls.PublicKey{pub}) {
+			return fmt.Errorf("bls signature did not match")
+		}
+
 		return nil
 	default:
 		return fmt.Errorf("cannot verify signature of unsupported type: %s", s.Type)
@@ -87,6 +187,9 @@ func (s *Signature) TypeCode() int {
 func (w *Wallet) Sign(addr address.Address, msg []byte) (*Signature, error) {
 	ki, err := w.findKey(addr)
 	if err != nil {
+		if w.remote != nil {
+			return w.remoteSign(addr, msg)
+		}
 		return nil, err
 	}
 
@@ -118,6 +221,9 @@ func (w *Wallet) Sign(addr address.Address, msg []byte) (*Signature, error) {
 }
 
 func (w *Wallet) findKey(addr address.Address) (*KeyInfo, error) {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
 	ki, ok := w.keys[addr]
 	if !ok {
 		return nil, fmt.Errorf("key not for given address not found in wallet")
@@ -125,12 +231,146 @@ func (w *Wallet) findKey(addr address.Address) (*KeyInfo, error) {
 	return ki, nil
 }
 
-func (w *Wallet) Export(addr address.Address) ([]byte, error) {
-	panic("nyi")
+// ListAddrs returns the addresses of all keys in the wallet, including those
+// of the remote signer. If the remote signer can't be reached the local
+// addresses are still returned, along with the error.
+func (w *Wallet) ListAddrs() ([]address.Address, error) {
+	w.lk.Lock()
+	out := make([]address.Address, 0, len(w.keys))
+	for addr := range w.keys {
+		out = append(out, addr)
+	}
+	w.lk.Unlock()
+
+	var remoteErr error
+	if w.remote != nil {
+		raddrs, err := w.remoteAddrs(false)
+		for _, addr := range raddrs {
+			if !w.hasLocalKey(addr) {
+				out = append(out, addr)
+			}
+		}
+		remoteErr = err
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].String() < out[j].String()
+	})
+	return out, remoteErr
 }
 
-func (w *Wallet) Import(kdata []byte) (address.Address, error) {
-	panic("nyi")
+// GetDefault returns the address used when none is specified
+func (w *Wallet) GetDefault() (address.Address, error) {
+	ki, err := w.keystore.Get(KDefault)
+	if err != nil {
+		if err == ErrKeyNotFound {
+			return address.Undef, fmt.Errorf("no default address set")
+		}
+		return address.Undef, errors.Wrap(err, "loading default address")
+	}
+
+	if ki.Type != KTDefaultAddr {
+		// older keystores hold a copy of the default key
+		return ki.Address(), nil
+	}
+
+	return address.NewFromBytes(ki.PrivateKey)
+}
+
+// SetDefault makes addr, which must be in the wallet or held by its remote
+// signer, the default address. Only the address is stored, not a copy of the
+// key.
+func (w *Wallet) SetDefault(addr address.Address) error {
+	if !w.HasKey(addr) {
+		return fmt.Errorf("key for address %s not found in wallet", addr)
+	}
+
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	if err := w.keystore.Delete(KDefault); err != nil && err != ErrKeyNotFound {
+		return errors.Wrap(err, "removing old default address")
+	}
+
+	if err := w.keystore.Put(KDefault, KeyInfo{Type: KTDefaultAddr, PrivateKey: addr.Bytes()}); err != nil {
+		return errors.Wrap(err, "saving default address")
+	}
+	return nil
+}
+
+// HasKey returns whether the wallet, or its remote signer, holds the key for
+// addr
+func (w *Wallet) HasKey(addr address.Address) bool {
+	if w.hasLocalKey(addr) {
+		return true
+	}
+	if w.remote == nil {
+		return false
+	}
+
+	raddrs, err := w.remoteAddrs(true)
+	if err != nil {
+		log.Warnf("checking for key %s: %s", addr, err)
+		return false
+	}
+	for _, a := range raddrs {
+		if a == addr {
+			return true
+		}
+	}
+	return false
+}
+
+func (w *Wallet) hasLocalKey(addr address.Address) bool {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	_, ok := w.keys[addr]
+	return ok
+}
+
+// ErrExportDisabled is returned by Export on wallets with export disabled
+var ErrExportDisabled = errors.New("key export is disabled")
+
+// DisableExport makes Export fail, for wallets whose keys shouldn't leave the
+// node
+func (w *Wallet) DisableExport() {
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	w.noExport = true
+}
+
+// Export returns the key for addr, see EncodeKeyInfo for a portable format
+func (w *Wallet) Export(addr address.Address) (*KeyInfo, error) {
+	w.lk.Lock()
+	disabled := w.noExport
+	w.lk.Unlock()
+	if disabled {
+		return nil, ErrExportDisabled
+	}
+
+	ki, err := w.findKey(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	out := *ki
+	return &out, nil
+}
+
+// Import adds a key to the wallet, it fails if the key is invalid or already
+// in the wallet
+func (w *Wallet) Import(ki *KeyInfo) (address.Address, error) {
+	if err := ki.Validate(); err != nil {
+		return address.Undef, err
+	}
+
+	if w.HasKey(ki.Address()) {
+		return address.Undef, errors.Wrapf(ErrKeyExists, "importing %s", ki.Address())
+	}
+
+	return w.addKey(ki)
 }
 
 func (w *Wallet) GenerateKey(typ string) (address.Address, error) {
@@ -145,9 +385,7 @@ func (w *Wallet) GenerateKey(typ string) (address.Address, error) {
 			Type:       typ,
 		}
 
-		addr := ki.Address()
-		w.keys[addr] = ki
-		return addr, nil
+		return w.addKey(ki)
 	case KTBLS:
 		priv := bls.PrivateKeyGenerate()
 
@@ -156,20 +394,55 @@ func (w *Wallet) GenerateKey(typ string) (address.Address, error) {
 			Type:       KTBLS,
 		}
 
-		addr := ki.Address()
-		w.keys[addr] = ki
-		return addr, nil
+		return w.addKey(ki)
 	default:
 		return address.Undef, fmt.Errorf("invalid key type: %s", typ)
 	}
 }
 
+// addKey persists the key to the keystore and makes it available for signing
+func (w *Wallet) addKey(ki *KeyInfo) (address.Address, error) {
+	if w.remote != nil {
+		return address.Undef, ErrRemoteWallet
+	}
+
+	addr := ki.Address()
+
+	w.lk.Lock()
+	defer w.lk.Unlock()
+
+	if err := w.keystore.Put(KNamePrefix+addr.String(), *ki); err != nil {
+		return address.Undef, errors.Wrap(err, "saving key to keystore")
+	}
+
+	w.keys[addr] = ki
+	return addr, nil
+}
+
 type KeyInfo struct {
 	PrivateKey []byte
 
 	Type string
 }
 
+// Validate checks that the key type is known and the private key has the
+// right size for it
+func (ki *KeyInfo) Validate() error {
+	switch ki.Type {
+	case KTSecp256k1:
+		if len(ki.PrivateKey) != crypto.PrivateKeyBytes {
+			return fmt.Errorf("secp256k1 private key must be %d bytes, got %d", crypto.PrivateKeyBytes, len(ki.PrivateKey))
+		}
+	case KTBLS:
+		if len(ki.PrivateKey) != bls.PrivateKeyBytes {
+			return fmt.Errorf("bls private key must be %d bytes, got %d", bls.PrivateKeyBytes, len(ki.PrivateKey))
+		}
+	default:
+		return fmt.Errorf("unknown key type: %q", ki.Type)
+	}
+	return nil
+}
+
 func (ki *KeyInfo) Address() address.Address {
 	switch ki.Type {
 	case KTSecp256k1:
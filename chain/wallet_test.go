@@ -0,0 +1,95 @@
+package chain
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/zgfzgf/mid-lotus/chain/address"
+)
+
+func TestWalletDefaultStoresAddressOnly(t *testing.T) {
+	ks := NewMemKeystore()
+	w, err := NewWallet(ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr, err := w.GenerateKey(KTSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.SetDefault(addr); err != nil {
+		t.Fatal(err)
+	}
+
+	ki, err := ks.Get(KDefault)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ki.Type != KTDefaultAddr {
+		t.Fatalf("default entry has type %s", ki.Type)
+	}
+
+	def, err := w.GetDefault()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if def != addr {
+		t.Fatalf("default is %s, expected %s", def, addr)
+	}
+}
+
+type emptySigner struct{}
+
+func (emptySigner) List(context.Context) ([]address.Address, error) {
+	return nil, nil
+}
+
+func (emptySigner) Sign(context.Context, address.Address, []byte) (*Signature, error) {
+	return nil, ErrKeyNotFound
+}
+
+func TestRemoteWalletRefusesNewKeys(t *testing.T) {
+	ks := NewMemKeystore()
+	w, err := NewRemoteWallet(ks, emptySigner{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	local, err := NewWallet(NewMemKeystore())
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr, err := local.GenerateKey(KTSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ki, err := local.Export(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, typ := range []string{KTSecp256k1, KTBLS} {
+		if _, err := w.GenerateKey(typ); err != ErrRemoteWallet {
+			t.Errorf("generating a %s key: expected ErrRemoteWallet, got %v", typ, err)
+		}
+	}
+	if _, err := w.Import(ki); err != ErrRemoteWallet {
+		t.Errorf("importing a key: expected ErrRemoteWallet, got %v", err)
+	}
+	if err := w.HDInit(strings.Repeat("abandon ", 11)+"about", ""); err != ErrRemoteWallet {
+		t.Errorf("setting the HD seed: expected ErrRemoteWallet, got %v", err)
+	}
+	if _, err := w.GenerateHDKey(KTSecp256k1); err == nil {
+		t.Error("derived an HD key")
+	}
+
+	names, err := ks.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) != 0 {
+		t.Fatalf("keystore has entries %v", names)
+	}
+}
@@ -43,6 +43,11 @@ func reqContext(cctx *cli.Context) context.Context {
 }
 
 var Commands = []*cli.Command{
+	minerCmd,
+	mpoolCmd,
 	netCmd,
+	sendCmd,
+	stateCmd,
 	versionCmd,
+	walletCmd,
 }
@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"fmt"
+
+	"gopkg.in/urfave/cli.v2"
+
+	"github.com/zgfzgf/mid-lotus/chain/address"
+)
+
+var minerCmd = &cli.Command{
+	Name:  "miner",
+	Usage: "Manage the block miner",
+	Subcommands: []*cli.Command{
+		minerStart,
+		minerStop,
+		minerStatus,
+	},
+}
+
+var minerStart = &cli.Command{
+	Name:      "start",
+	Usage:     "Start mining blocks",
+	ArgsUsage: "[address]",
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		addr := address.Undef
+		if cctx.Args().Present() {
+			var err error
+			addr, err = address.NewFromString(cctx.Args().First())
+			if err != nil {
+				return err
+			}
+		}
+
+		return api.MinerStart(ctx, addr)
+	},
+}
+
+var minerStop = &cli.Command{
+	Name:  "stop",
+	Usage: "Stop mining blocks",
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		return api.MinerStop(ctx)
+	},
+}
+
+var minerStatus = &cli.Command{
+	Name:  "status",
+	Usage: "Show whether the miner is running",
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		st, err := api.MinerStatus(ctx)
+		if err != nil {
+			return err
+		}
+
+		if !st.Running {
+			fmt.Println("miner stopped")
+		} else {
+			fmt.Println("miner running")
+		}
+		if !st.Address.Empty() {
+			fmt.Printf("address: %s\n", st.Address)
+		}
+		fmt.Printf("blocks mined: %d\n", st.BlocksMined)
+		fmt.Printf("orphans avoided: %d\n", st.OrphansAvoided)
+
+		return nil
+	},
+}
@@ -0,0 +1,96 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/urfave/cli.v2"
+
+	"github.com/zgfzgf/mid-lotus/chain"
+	"github.com/zgfzgf/mid-lotus/chain/address"
+)
+
+var mpoolCmd = &cli.Command{
+	Name:  "mpool",
+	Usage: "Manage message pool",
+	Subcommands: []*cli.Command{
+		mpoolPending,
+		mpoolGetNonce,
+		mpoolSub,
+	},
+}
+
+var mpoolPending = &cli.Command{
+	Name:  "pending",
+	Usage: "Get pending messages",
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		msgs, err := api.MpoolPending(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, msg := range msgs {
+			out, err := json.MarshalIndent(msg, "", "  ")
+			if err != nil {
+				return err
+			}
+			fmt.Println(string(out))
+		}
+
+		return nil
+	},
+}
+
+var mpoolGetNonce = &cli.Command{
+	Name:      "nonce",
+	Usage:     "Get the next nonce of an address, including pending messages",
+	ArgsUsage: "<address>",
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		if !cctx.Args().Present() {
+			return fmt.Errorf("'nonce' expects an address")
+		}
+
+		addr, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		nonce, err := api.MpoolGetNonce(ctx, addr)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(nonce)
+		return nil
+	},
+}
+
+var mpoolSub = &cli.Command{
+	Name:  "sub",
+	Usage: "Subscribe to mpool changes",
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		sub, err := api.MpoolSub(ctx)
+		if err != nil {
+			return err
+		}
+
+		for u := range sub {
+			if u.Type == chain.MpoolRemove {
+				fmt.Printf("%s %s (%s)\n", u.Type, u.Message.Cid(), u.Reason)
+				continue
+			}
+			fmt.Printf("%s %s\n", u.Type, u.Message.Cid())
+		}
+
+		return nil
+	},
+}
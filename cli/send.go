@@ -0,0 +1,88 @@
+package cli
+
+import (
+	"fmt"
+
+	"gopkg.in/urfave/cli.v2"
+
+	"github.com/zgfzgf/mid-lotus/chain"
+	"github.com/zgfzgf/mid-lotus/chain/address"
+)
+
+var sendCmd = &cli.Command{
+	Name:      "send",
+	Usage:     "Send funds between accounts",
+	ArgsUsage: "<target> <amount in FIL>",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "source",
+			Usage: "address to send the funds from, the wallet's default address if not set",
+		},
+		&cli.StringFlag{
+			Name:  "gas-price",
+			Usage: "gas price to pay for the message",
+			Value: "0",
+		},
+		&cli.StringFlag{
+			Name:  "gas-limit",
+			Usage: "gas limit of the message, estimated if not set",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		if cctx.Args().Len() != 2 {
+			return fmt.Errorf("'send' expects two arguments, target and amount")
+		}
+
+		toAddr, err := address.NewFromString(cctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+
+		val, err := chain.ParseFIL(cctx.Args().Get(1))
+		if err != nil {
+			return err
+		}
+
+		var fromAddr address.Address
+		if cctx.IsSet("source") {
+			fromAddr, err = address.NewFromString(cctx.String("source"))
+		} else {
+			fromAddr, err = api.WalletDefaultAddress(ctx)
+		}
+		if err != nil {
+			return err
+		}
+
+		gasPrice, err := chain.BigFromString(cctx.String("gas-price"))
+		if err != nil {
+			return err
+		}
+
+		msg := &chain.Message{
+			From:     fromAddr,
+			To:       toAddr,
+			Value:    chain.BigInt(val),
+			GasPrice: gasPrice,
+		}
+
+		if cctx.IsSet("gas-limit") {
+			msg.GasLimit, err = chain.BigFromString(cctx.String("gas-limit"))
+		} else {
+			msg.GasLimit, err = api.GasEstimateGasLimit(ctx, msg, nil)
+		}
+		if err != nil {
+			return err
+		}
+
+		smsg, err := api.MpoolPushMessage(ctx, msg)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(smsg.Cid())
+		return nil
+	},
+}
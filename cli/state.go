@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/ipfs/go-cid"
+	"gopkg.in/urfave/cli.v2"
+
+	"github.com/zgfzgf/mid-lotus/chain"
+
+	"github.com/zgfzgf/mid-lotus/chain/vectors"
+)
+
+var stateCmd = &cli.Command{
+	Name:  "state",
+	Usage: "Interact with and query filecoin chain state",
+	Subcommands: []*cli.Command{
+		stateRecordVector,
+	},
+}
+
+var stateRecordVector = &cli.Command{
+	Name:  "record-vector",
+	Usage: "Record the blocks of a tipset as VM test vectors",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "out",
+			Usage: "directory to write the vectors to",
+			Value: ".",
+		},
+		&cli.StringFlag{
+			Name:  "tipset",
+			Usage: "comma separated CIDs of the tipset's blocks, defaults to the chain head",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		var ts *chain.TipSet
+		if cctx.IsSet("tipset") {
+			var cids []cid.Cid
+			for _, s := range strings.Split(cctx.String("tipset"), ",") {
+				c, err := cid.Decode(strings.TrimSpace(s))
+				if err != nil {
+					return fmt.Errorf("parsing tipset block cid %q: %s", s, err)
+				}
+				cids = append(cids, c)
+			}
+
+			var err error
+			ts, err = api.ChainGetTipSet(ctx, cids)
+			if err != nil {
+				return err
+			}
+		} else {
+			var err error
+			ts, err = api.ChainHead(ctx)
+			if err != nil {
+				return err
+			}
+		}
+
+		vecs, err := api.StateRecordVectors(ctx, ts)
+		if err != nil {
+			return err
+		}
+
+		for i, v := range vecs {
+			path := filepath.Join(cctx.String("out"), fmt.Sprintf("%s.json", ts.Cids()[i]))
+			if err := vectors.SaveVector(path, v); err != nil {
+				return err
+			}
+			fmt.Println(path)
+		}
+
+		return nil
+	},
+}
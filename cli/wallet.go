@@ -0,0 +1,452 @@
+package cli
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gopkg.in/urfave/cli.v2"
+
+	"github.com/zgfzgf/mid-lotus/chain"
+	"github.com/zgfzgf/mid-lotus/chain/address"
+	"github.com/zgfzgf/mid-lotus/lib/hd"
+)
+
+var walletCmd = &cli.Command{
+	Name:  "wallet",
+	Usage: "Manage wallet",
+	Subcommands: []*cli.Command{
+		walletNew,
+		walletList,
+		walletBalance,
+		walletGetDefault,
+		walletSetDefault,
+		walletSign,
+		walletVerify,
+		walletExport,
+		walletImport,
+		walletHD,
+	},
+}
+
+var walletNew = &cli.Command{
+	Name:      "new",
+	Usage:     "Generate a new key",
+	ArgsUsage: "[secp256k1 (default) | bls]",
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		typ := chain.KTSecp256k1
+		if cctx.Args().Present() {
+			typ = cctx.Args().First()
+		}
+
+		addr, err := api.WalletNew(ctx, typ)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(addr)
+		return nil
+	},
+}
+
+var walletList = &cli.Command{
+	Name:  "list",
+	Usage: "List wallet addresses",
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		addrs, err := api.WalletList(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, addr := range addrs {
+			fmt.Println(addr)
+		}
+		return nil
+	},
+}
+
+var walletBalance = &cli.Command{
+	Name:      "balance",
+	Usage:     "Get the balance of an address, or of the default address",
+	ArgsUsage: "[address]",
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		var addr address.Address
+		var err error
+		if cctx.Args().Present() {
+			addr, err = address.NewFromString(cctx.Args().First())
+		} else {
+			addr, err = api.WalletDefaultAddress(ctx)
+		}
+		if err != nil {
+			return err
+		}
+
+		balance, err := api.WalletBalance(ctx, addr)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(chain.FIL(balance))
+		return nil
+	},
+}
+
+var walletGetDefault = &cli.Command{
+	Name:  "default",
+	Usage: "Get the default address",
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		addr, err := api.WalletDefaultAddress(ctx)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(addr)
+		return nil
+	},
+}
+
+var walletSetDefault = &cli.Command{
+	Name:      "set-default",
+	Usage:     "Set the default address",
+	ArgsUsage: "<address>",
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		if !cctx.Args().Present() {
+			return fmt.Errorf("'set-default' expects an address")
+		}
+
+		addr, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		return api.WalletSetDefault(ctx, addr)
+	},
+}
+
+var walletSign = &cli.Command{
+	Name:      "sign",
+	Usage:     "Sign hex encoded data, and print the hex encoded signature",
+	ArgsUsage: "<address> <hex data>",
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		if cctx.Args().Len() != 2 {
+			return fmt.Errorf("'sign' expects two arguments, address and data")
+		}
+
+		addr, err := address.NewFromString(cctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+
+		data, err := hex.DecodeString(cctx.Args().Get(1))
+		if err != nil {
+			return err
+		}
+
+		sig, err := api.WalletSign(ctx, addr, data)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(hex.EncodeToString(sig.Bytes()))
+		return nil
+	},
+}
+
+var walletVerify = &cli.Command{
+	Name:      "verify",
+	Usage:     "Verify a hex encoded signature over hex encoded data",
+	ArgsUsage: "<address> <hex data> <hex signature>",
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		if cctx.Args().Len() != 3 {
+			return fmt.Errorf("'verify' expects three arguments, address, data and signature")
+		}
+
+		addr, err := address.NewFromString(cctx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+
+		data, err := hex.DecodeString(cctx.Args().Get(1))
+		if err != nil {
+			return err
+		}
+
+		sigb, err := hex.DecodeString(cctx.Args().Get(2))
+		if err != nil {
+			return err
+		}
+
+		sig, err := chain.SignatureFromBytes(sigb)
+		if err != nil {
+			return err
+		}
+
+		ok, err := api.WalletVerify(ctx, addr, data, &sig)
+		if err != nil {
+			return err
+		}
+
+		if !ok {
+			return fmt.Errorf("signature is invalid")
+		}
+		fmt.Println("valid")
+		return nil
+	},
+}
+
+var passwordFileFlag = &cli.StringFlag{
+	Name:  "password-file",
+	Usage: "file holding the password the key is encrypted with",
+}
+
+func readPassword(cctx *cli.Context) (string, error) {
+	if !cctx.IsSet("password-file") {
+		return "", nil
+	}
+
+	data, err := ioutil.ReadFile(cctx.String("password-file"))
+	if err != nil {
+		return "", err
+	}
+
+	pw := strings.TrimRight(string(data), "\r\n")
+	if pw == "" {
+		return "", fmt.Errorf("password file is empty")
+	}
+	return pw, nil
+}
+
+var walletExport = &cli.Command{
+	Name:      "export",
+	Usage:     "Export the private key of an address as hex, needs Wallet.EnableExport in the node's config",
+	ArgsUsage: "<address>",
+	Flags: []cli.Flag{
+		passwordFileFlag,
+	},
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		if !cctx.Args().Present() {
+			return fmt.Errorf("'export' expects an address")
+		}
+
+		addr, err := address.NewFromString(cctx.Args().First())
+		if err != nil {
+			return err
+		}
+
+		password, err := readPassword(cctx)
+		if err != nil {
+			return err
+		}
+
+		ki, err := api.WalletExport(ctx, addr)
+		if err != nil {
+			return err
+		}
+
+		out, err := chain.EncodeKeyInfo(ki, password)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(out)
+		return nil
+	},
+}
+
+var walletImport = &cli.Command{
+	Name:      "import",
+	Usage:     "Import a private key exported by 'wallet export'",
+	ArgsUsage: "[<path> (optional, reads from stdin if omitted)]",
+	Flags: []cli.Flag{
+		passwordFileFlag,
+		&cli.StringFlag{
+			Name:  "type",
+			Usage: "refuse keys which aren't of this type (secp256k1 or bls)",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		var data []byte
+		var err error
+		if cctx.Args().Present() {
+			data, err = ioutil.ReadFile(cctx.Args().First())
+		} else {
+			data, err = ioutil.ReadAll(os.Stdin)
+		}
+		if err != nil {
+			return err
+		}
+
+		password, err := readPassword(cctx)
+		if err != nil {
+			return err
+		}
+
+		ki, err := chain.DecodeKeyInfo(strings.TrimSpace(string(data)), password)
+		if err != nil {
+			return err
+		}
+
+		if cctx.IsSet("type") && ki.Type != cctx.String("type") {
+			return fmt.Errorf("key is of type %s, expected %s", ki.Type, cctx.String("type"))
+		}
+
+		addr, err := api.WalletImport(ctx, ki)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("imported key %s\n", addr)
+		return nil
+	},
+}
+
+var walletHD = &cli.Command{
+	Name:  "hd",
+	Usage: "Manage keys derived from a seed phrase",
+	Subcommands: []*cli.Command{
+		walletHDInit,
+		walletHDNew,
+		walletHDScan,
+	},
+}
+
+var walletHDInit = &cli.Command{
+	Name:      "init",
+	Usage:     "Generate a new seed phrase, or restore one, and use it for HD keys",
+	ArgsUsage: "[<path> (with --restore, reads from stdin if omitted)]",
+	Flags: []cli.Flag{
+		&cli.BoolFlag{
+			Name:  "restore",
+			Usage: "restore an existing seed phrase instead of generating one",
+		},
+		&cli.StringFlag{
+			Name:  "password-file",
+			Usage: "file with an optional BIP39 passphrase",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		var mnemonic string
+		if cctx.Bool("restore") {
+			var data []byte
+			var err error
+			if cctx.Args().Present() {
+				data, err = ioutil.ReadFile(cctx.Args().First())
+			} else {
+				data, err = ioutil.ReadAll(os.Stdin)
+			}
+			if err != nil {
+				return err
+			}
+			mnemonic = strings.TrimSpace(string(data))
+		} else {
+			var err error
+			mnemonic, err = hd.NewMnemonic()
+			if err != nil {
+				return err
+			}
+		}
+
+		passphrase, err := readPassword(cctx)
+		if err != nil {
+			return err
+		}
+
+		if err := api.WalletHDInit(ctx, mnemonic, passphrase); err != nil {
+			return err
+		}
+
+		if !cctx.Bool("restore") {
+			fmt.Println("Write down this seed phrase, it is the only backup of your HD keys:")
+			fmt.Println()
+			fmt.Println(mnemonic)
+		}
+		return nil
+	},
+}
+
+var walletHDNew = &cli.Command{
+	Name:      "new",
+	Usage:     "Derive the next HD key",
+	ArgsUsage: "[secp256k1 (default) | bls]",
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		typ := chain.KTSecp256k1
+		if cctx.Args().Present() {
+			typ = cctx.Args().First()
+		}
+
+		addr, err := api.WalletHDNew(ctx, typ)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(addr)
+		return nil
+	},
+}
+
+var walletHDScan = &cli.Command{
+	Name:      "scan",
+	Usage:     "Find HD keys with actors on chain and add them to the wallet",
+	ArgsUsage: "[secp256k1 (default) | bls]",
+	Flags: []cli.Flag{
+		&cli.IntFlag{
+			Name:  "gap",
+			Usage: "number of unused addresses in a row after which to stop",
+			Value: chain.DefaultHDScanGap,
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		api := getApi(cctx)
+		ctx := reqContext(cctx)
+
+		typ := chain.KTSecp256k1
+		if cctx.Args().Present() {
+			typ = cctx.Args().First()
+		}
+
+		addrs, err := api.WalletHDScan(ctx, typ, cctx.Int("gap"))
+		if err != nil {
+			return err
+		}
+
+		for _, addr := range addrs {
+			fmt.Println(addr)
+		}
+		return nil
+	},
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"gopkg.in/urfave/cli.v2"
+
+	"github.com/zgfzgf/mid-lotus/chain"
+	"github.com/zgfzgf/mid-lotus/node/modules"
+)
+
+// openWallet opens the signer's keystore, shared by serving and the key
+// management commands
+func openWallet(cctx *cli.Context) (*chain.Wallet, error) {
+	if !cctx.IsSet("keystore") {
+		return nil, cli.Exit("--keystore is required", 1)
+	}
+
+	passphrase, err := modules.KeystorePassphrase()
+	if err != nil {
+		return nil, err
+	}
+
+	ks, err := chain.OpenFSKeystore(cctx.String("keystore"), passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	return chain.NewWallet(ks)
+}
+
+var newCmd = &cli.Command{
+	Name:      "new",
+	Usage:     "Generate a new key in the signer's keystore",
+	ArgsUsage: "[secp256k1 (default) | bls]",
+	Action: func(cctx *cli.Context) error {
+		w, err := openWallet(cctx)
+		if err != nil {
+			return err
+		}
+
+		typ := chain.KTSecp256k1
+		if cctx.Args().Present() {
+			typ = cctx.Args().First()
+		}
+
+		addr, err := w.GenerateKey(typ)
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(addr)
+		return nil
+	},
+}
+
+var importCmd = &cli.Command{
+	Name:      "import",
+	Usage:     "Import a private key exported by 'lotus wallet export'",
+	ArgsUsage: "[<path> (optional, reads from stdin if omitted)]",
+	Flags: []cli.Flag{
+		&cli.StringFlag{
+			Name:  "password-file",
+			Usage: "file holding the password the key is encrypted with",
+		},
+	},
+	Action: func(cctx *cli.Context) error {
+		w, err := openWallet(cctx)
+		if err != nil {
+			return err
+		}
+
+		var data []byte
+		if cctx.Args().Present() {
+			data, err = ioutil.ReadFile(cctx.Args().First())
+		} else {
+			data, err = ioutil.ReadAll(os.Stdin)
+		}
+		if err != nil {
+			return err
+		}
+
+		var password string
+		if cctx.IsSet("password-file") {
+			pw, err := ioutil.ReadFile(cctx.String("password-file"))
+			if err != nil {
+				return err
+			}
+			password = strings.TrimRight(string(pw), "\r\n")
+			if password == "" {
+				return fmt.Errorf("password file is empty")
+			}
+		}
+
+		ki, err := chain.DecodeKeyInfo(strings.TrimSpace(string(data)), password)
+		if err != nil {
+			return err
+		}
+
+		addr, err := w.Import(ki)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("imported key %s\n", addr)
+		return nil
+	},
+}
+
+var listCmd = &cli.Command{
+	Name:  "list",
+	Usage: "List the addresses of the keys in the signer's keystore",
+	Action: func(cctx *cli.Context) error {
+		w, err := openWallet(cctx)
+		if err != nil {
+			return err
+		}
+
+		addrs, err := w.ListAddrs()
+		if err != nil {
+			return err
+		}
+
+		for _, addr := range addrs {
+			fmt.Println(addr)
+		}
+		return nil
+	},
+}
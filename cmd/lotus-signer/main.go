@@ -0,0 +1,69 @@
+package main
+
+import (
+	"log"
+	"os"
+
+	"gopkg.in/urfave/cli.v2"
+
+	"github.com/zgfzgf/mid-lotus/build"
+	"github.com/zgfzgf/mid-lotus/chain/address"
+	"github.com/zgfzgf/mid-lotus/node/modules"
+	"github.com/zgfzgf/mid-lotus/signer"
+)
+
+func main() {
+	app := &cli.App{
+		Name:    "lotus-signer",
+		Usage:   "Sign for a lotus node with keys kept out of the node process",
+		Version: build.Version,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:  "keystore",
+				Usage: "keystore directory, encrypted with the passphrase from " + modules.KeystorePassphraseEnv,
+			},
+			&cli.StringFlag{
+				Name:  "listen",
+				Usage: "address to serve the signer API on, keep it local to the node's host",
+				Value: "127.0.0.1:1235",
+			},
+			&cli.StringSliceFlag{
+				Name:  "allow",
+				Usage: "address of a key the node may use, can be repeated",
+			},
+		},
+		Commands: []*cli.Command{
+			newCmd,
+			importCmd,
+			listCmd,
+		},
+		Action: func(cctx *cli.Context) error {
+			w, err := openWallet(cctx)
+			if err != nil {
+				return err
+			}
+
+			var allow []address.Address
+			for _, s := range cctx.StringSlice("allow") {
+				addr, err := address.NewFromString(s)
+				if err != nil {
+					return err
+				}
+				allow = append(allow, addr)
+			}
+
+			s, err := signer.New(w, allow)
+			if err != nil {
+				return err
+			}
+
+			log.Printf("serving %d keys on %s", len(allow), cctx.String("listen"))
+			return signer.Serve(s, cctx.String("listen"))
+		},
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		log.Println(err)
+		os.Exit(1)
+	}
+}
@@ -5,6 +5,10 @@ package daemon
 import (
 	"context"
 
+	"github.com/pkg/errors"
+	"go.opencensus.io/stats/view"
+
+	"github.com/zgfzgf/mid-lotus/metrics"
 	"github.com/zgfzgf/mid-lotus/node"
 	"github.com/zgfzgf/mid-lotus/node/config"
 
@@ -29,6 +33,10 @@ var Cmd = &cli.Command{
 			return err
 		}
 
+		if err := view.Register(metrics.DefaultViews...); err != nil {
+			return errors.Wrap(err, "registering metrics views")
+		}
+
 		api, err := node.New(ctx, node.Online(), node.Config(cfg))
 		if err != nil {
 			return err
@@ -0,0 +1,196 @@
+package car
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+
+	block "github.com/ipfs/go-block-format"
+	"github.com/ipfs/go-cid"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	mh "github.com/multiformats/go-multihash"
+)
+
+func init() {
+	cbor.RegisterCborType(CarHeader{})
+}
+
+// CarHeader is the header of a CARv1 file
+type CarHeader struct {
+	Roots   []cid.Cid `refmt:"roots"`
+	Version uint64    `refmt:"version"`
+}
+
+// BlockGetter is the read side of a blockstore
+type BlockGetter interface {
+	Get(cid.Cid) (block.Block, error)
+}
+
+// BlockPutter is the write side of a blockstore
+type BlockPutter interface {
+	Put(block.Block) error
+}
+
+// WriteCar writes the DAGs under roots to w in the CARv1 format. Only
+// dag-cbor blocks are traversed for links, and identity hashed cids are
+// skipped as their data is inlined in the cid.
+func WriteCar(ctx context.Context, bs BlockGetter, roots []cid.Cid, w io.Writer) error {
+	hb, err := cbor.DumpObject(&CarHeader{
+		Roots:   roots,
+		Version: 1,
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := writeSection(w, hb); err != nil {
+		return err
+	}
+
+	seen := cid.NewSet()
+	var walk func(c cid.Cid) error
+	walk = func(c cid.Cid) error {
+		if c.Prefix().MhType == mh.ID || !seen.Visit(c) {
+			return nil
+		}
+
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		blk, err := bs.Get(c)
+		if err != nil {
+			return fmt.Errorf("getting block %s: %s", c, err)
+		}
+
+		if err := writeSection(w, append(c.Bytes(), blk.RawData()...)); err != nil {
+			return err
+		}
+
+		if c.Type() != cid.DagCBOR {
+			return nil
+		}
+
+		nd, err := cbor.DecodeBlock(blk)
+		if err != nil {
+			return err
+		}
+
+		for _, l := range nd.Links() {
+			if err := walk(l.Cid); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, r := range roots {
+		if err := walk(r); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// LoadCar reads a CARv1 file from r and puts all of its blocks into bs
+func LoadCar(bs BlockPutter, r io.Reader) (*CarHeader, error) {
+	br := bufio.NewReader(r)
+
+	hb, err := readSection(br)
+	if err != nil {
+		return nil, fmt.Errorf("reading car header: %s", err)
+	}
+
+	var h CarHeader
+	if err := cbor.DecodeInto(hb, &h); err != nil {
+		return nil, fmt.Errorf("decoding car header: %s", err)
+	}
+
+	if h.Version != 1 {
+		return nil, fmt.Errorf("unsupported car version: %d", h.Version)
+	}
+
+	for {
+		data, err := readSection(br)
+		if err == io.EOF {
+			return &h, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		n, err := cidLen(data)
+		if err != nil {
+			return nil, err
+		}
+
+		c, err := cid.Cast(data[:n])
+		if err != nil {
+			return nil, err
+		}
+
+		blk, err := block.NewBlockWithCid(data[n:], c)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := bs.Put(blk); err != nil {
+			return nil, err
+		}
+	}
+}
+
+func writeSection(w io.Writer, data []byte) error {
+	buf := make([]byte, binary.MaxVarintLen64)
+	n := binary.PutUvarint(buf, uint64(len(data)))
+	if _, err := w.Write(buf[:n]); err != nil {
+		return err
+	}
+
+	_, err := w.Write(data)
+	return err
+}
+
+func readSection(br *bufio.Reader) ([]byte, error) {
+	l, err := binary.ReadUvarint(br)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, l)
+	if _, err := io.ReadFull(br, data); err != nil {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+// cidLen returns the length of the cid at the start of data
+func cidLen(data []byte) (int, error) {
+	// CIDv0 is a bare sha2-256 multihash
+	if len(data) >= 34 && data[0] == mh.SHA2_256 && data[1] == 32 {
+		return 34, nil
+	}
+
+	off := 0
+	// version, codec, multihash type, multihash length
+	var vals [4]uint64
+	for i := range vals {
+		v, n := binary.Uvarint(data[off:])
+		if n <= 0 {
+			return 0, fmt.Errorf("invalid cid prefix in car section")
+		}
+		vals[i] = v
+		off += n
+	}
+
+	end := off + int(vals[3])
+	if end > len(data) {
+		return 0, fmt.Errorf("cid in car section is truncated")
+	}
+
+	return end, nil
+}
@@ -0,0 +1,45 @@
+package car
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/ipfs/go-cid"
+	"github.com/ipfs/go-datastore"
+	blockstore "github.com/ipfs/go-ipfs-blockstore"
+	cbor "github.com/ipfs/go-ipld-cbor"
+	mh "github.com/multiformats/go-multihash"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRoundtrip(t *testing.T) {
+	assert := assert.New(t)
+
+	bs := blockstore.NewBlockstore(datastore.NewMapDatastore())
+
+	leaf, err := cbor.WrapObject(map[string]string{"hello": "world"}, mh.SHA2_256, -1)
+	assert.NoError(err)
+	assert.NoError(bs.Put(leaf))
+
+	root, err := cbor.WrapObject(map[string]interface{}{
+		"leaf":  leaf.Cid(),
+		"again": leaf.Cid(),
+	}, mh.SHA2_256, -1)
+	assert.NoError(err)
+	assert.NoError(bs.Put(root))
+
+	buf := new(bytes.Buffer)
+	assert.NoError(WriteCar(context.Background(), bs, []cid.Cid{root.Cid()}, buf))
+
+	out := blockstore.NewBlockstore(datastore.NewMapDatastore())
+	h, err := LoadCar(out, buf)
+	assert.NoError(err)
+	assert.Equal([]cid.Cid{root.Cid()}, h.Roots)
+
+	for _, c := range []cid.Cid{root.Cid(), leaf.Cid()} {
+		has, err := out.Has(c)
+		assert.NoError(err)
+		assert.True(has)
+	}
+}
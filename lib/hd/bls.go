@@ -0,0 +1,116 @@
+package hd
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/big"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+// BLS keys are derived as specified in EIP-2333
+
+// blsR is the order of the BLS12-381 scalar field
+var blsR, _ = new(big.Int).SetString("73eda753299d7d483339d80809a1d80553bda402fffe5bfeffffffff00000001", 16)
+
+// BLSPrivateKeyBytes is the size of a serialized BLS private key
+const BLSPrivateKeyBytes = 32
+
+// DeriveBLSMaster derives the EIP-2333 master key from a seed
+func DeriveBLSMaster(seed []byte) (*big.Int, error) {
+	if len(seed) < 32 {
+		return nil, fmt.Errorf("seed must be at least 32 bytes, got %d", len(seed))
+	}
+
+	return hkdfModR(seed)
+}
+
+// DeriveBLSChild derives the child key at index i of parent
+func DeriveBLSChild(parent *big.Int, i uint32) (*big.Int, error) {
+	lpk, err := lamportPublicKey(parent, i)
+	if err != nil {
+		return nil, err
+	}
+
+	return hkdfModR(lpk)
+}
+
+// DeriveBLS derives the BLS private key at path from a seed, serialized
+// little-endian as used by the bls-signatures library
+func DeriveBLS(seed []byte, path []uint32) ([]byte, error) {
+	sk, err := DeriveBLSMaster(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range path {
+		sk, err = DeriveBLSChild(sk, i)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	be := i2osp(sk, BLSPrivateKeyBytes)
+	out := make([]byte, BLSPrivateKeyBytes)
+	for i := range be {
+		out[i] = be[len(be)-1-i]
+	}
+	return out, nil
+}
+
+func hkdfModR(ikm []byte) (*big.Int, error) {
+	salt := []byte("BLS-SIG-KEYGEN-SALT-")
+	sk := new(big.Int)
+
+	for sk.Sign() == 0 {
+		h := sha256.Sum256(salt)
+		salt = h[:]
+
+		// key_info is empty, followed by the output length I2OSP(48, 2)
+		r := hkdf.New(sha256.New, append(ikm[:len(ikm):len(ikm)], 0), salt, []byte{0, 48})
+		okm := make([]byte, 48)
+		if _, err := io.ReadFull(r, okm); err != nil {
+			return nil, err
+		}
+
+		sk.SetBytes(okm)
+		sk.Mod(sk, blsR)
+	}
+
+	return sk, nil
+}
+
+func lamportPublicKey(parent *big.Int, i uint32) ([]byte, error) {
+	var salt [4]byte
+	binary.BigEndian.PutUint32(salt[:], i)
+
+	ikm := i2osp(parent, 32)
+	notIkm := make([]byte, len(ikm))
+	for j := range ikm {
+		notIkm[j] = ^ikm[j]
+	}
+
+	h := sha256.New()
+	for _, k := range [][]byte{ikm, notIkm} {
+		r := hkdf.New(sha256.New, k, salt[:], nil)
+		chunk := make([]byte, 32)
+		for j := 0; j < 255; j++ {
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return nil, err
+			}
+			sum := sha256.Sum256(chunk)
+			h.Write(sum[:])
+		}
+	}
+
+	return h.Sum(nil), nil
+}
+
+func i2osp(v *big.Int, n int) []byte {
+	out := make([]byte, n)
+	b := v.Bytes()
+	copy(out[n-len(b):], b)
+	return out
+}
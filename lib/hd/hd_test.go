@@ -0,0 +1,107 @@
+package hd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"strings"
+	"testing"
+)
+
+func TestWordlist(t *testing.T) {
+	sum := sha256.Sum256([]byte(strings.Join(englishWordlist, "\n") + "\n"))
+	if hex.EncodeToString(sum[:]) != "2f5eed53a4727b4bf8880d8f3f199efc90e58503646d9ff8eff3a2ed3b24dbda" {
+		t.Fatal("wordlist does not match BIP39 english.txt")
+	}
+}
+
+func TestMnemonic(t *testing.T) {
+	m, err := EntropyToMnemonic(make([]byte, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m != strings.Repeat("abandon ", 11)+"about" {
+		t.Fatalf("unexpected mnemonic: %s", m)
+	}
+
+	m, err = NewMnemonic()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(strings.Fields(m)) != 24 {
+		t.Fatalf("expected 24 words: %s", m)
+	}
+
+	if _, err := MnemonicToEntropy(strings.Repeat("abandon ", 12)); err == nil {
+		t.Fatal("expected checksum error")
+	}
+}
+
+func TestBIP32Vector(t *testing.T) {
+	seed, _ := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	path, err := ParsePath("m/0'/1/2'/2/1000000000")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	k, err := DeriveSecp256k1(seed, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp, _ := hex.DecodeString("471b76e389e528d6de6d816857e012c5455051cad6660850e58372a6c3e6e7c8")
+	if !bytes.Equal(k, exp) {
+		t.Fatalf("got %x", k)
+	}
+}
+
+func TestEIP2333Vector(t *testing.T) {
+	seed, _ := hex.DecodeString("3141592653589793238462643383279502884197169399375105820974944592")
+
+	master, err := DeriveBLSMaster(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if master.String() != "29757020647961307431480504535336562678282505419141012933316116377660817309383" {
+		t.Fatalf("bad master key: %s", master)
+	}
+
+	child, err := DeriveBLSChild(master, 3141592653)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if child.String() != "25457201688850691947727629385191704516744796114925897962676248250929345014287" {
+		t.Fatalf("bad child key: %s", child)
+	}
+
+	seed, err = MnemonicToSeed(strings.Repeat("abandon ", 11)+"about", "TREZOR")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sk, err := DeriveBLS(seed, []uint32{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp, _ := new(big.Int).SetString("20397789859736650942317412262472558107875392172444076792671091975210932703118", 10)
+	for i := 0; i < len(sk)/2; i++ {
+		sk[i], sk[len(sk)-1-i] = sk[len(sk)-1-i], sk[i]
+	}
+	if new(big.Int).SetBytes(sk).Cmp(exp) != 0 {
+		t.Fatalf("bad key for mnemonic seed: %x", sk)
+	}
+}
+
+func TestPath(t *testing.T) {
+	s := FormatPath(Secp256k1Path(3))
+	if s != "m/44'/461'/0'/0/3" {
+		t.Fatal(s)
+	}
+	p, err := ParsePath(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if FormatPath(p) != s {
+		t.Fatal("path didn't round trip")
+	}
+}
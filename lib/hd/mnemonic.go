@@ -0,0 +1,106 @@
+package hd
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// MnemonicEntropyBits is the entropy of mnemonics made by NewMnemonic, it
+// gives 24 words
+const MnemonicEntropyBits = 256
+
+var wordIndex = func() map[string]int {
+	m := make(map[string]int, len(englishWordlist))
+	for i, w := range englishWordlist {
+		m[w] = i
+	}
+	return m
+}()
+
+// NewMnemonic generates a random BIP39 mnemonic
+func NewMnemonic() (string, error) {
+	entropy := make([]byte, MnemonicEntropyBits/8)
+	if _, err := rand.Read(entropy); err != nil {
+		return "", err
+	}
+
+	return EntropyToMnemonic(entropy)
+}
+
+// EntropyToMnemonic encodes entropy, which must be 16 to 32 bytes long in
+// steps of 4, as a BIP39 mnemonic
+func EntropyToMnemonic(entropy []byte) (string, error) {
+	if len(entropy) < 16 || len(entropy) > 32 || len(entropy)%4 != 0 {
+		return "", fmt.Errorf("invalid entropy length: %d", len(entropy))
+	}
+
+	csBits := uint(len(entropy) / 4)
+	sum := sha256.Sum256(entropy)
+
+	// entropy followed by the first csBits bits of its hash
+	v := new(big.Int).SetBytes(entropy)
+	v.Lsh(v, csBits)
+	v.Or(v, big.NewInt(int64(sum[0]>>(8-csBits))))
+
+	n := (len(entropy)*8 + int(csBits)) / 11
+	words := make([]string, n)
+	mask := big.NewInt(2047)
+	for i := n - 1; i >= 0; i-- {
+		idx := new(big.Int).And(v, mask)
+		words[i] = englishWordlist[idx.Int64()]
+		v.Rsh(v, 11)
+	}
+
+	return strings.Join(words, " "), nil
+}
+
+// MnemonicToEntropy decodes a BIP39 mnemonic, checking its words and checksum
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, fmt.Errorf("mnemonic must have 12, 15, 18, 21 or 24 words, got %d", len(words))
+	}
+
+	v := new(big.Int)
+	for _, w := range words {
+		idx, ok := wordIndex[w]
+		if !ok {
+			return nil, fmt.Errorf("unknown mnemonic word: %q", w)
+		}
+		v.Lsh(v, 11)
+		v.Or(v, big.NewInt(int64(idx)))
+	}
+
+	csBits := uint(len(words) / 3)
+	cs := new(big.Int).And(v, big.NewInt(1<<csBits-1))
+	v.Rsh(v, csBits)
+
+	entropy := make([]byte, len(words)*4/3)
+	vb := v.Bytes()
+	copy(entropy[len(entropy)-len(vb):], vb)
+
+	sum := sha256.Sum256(entropy)
+	if cs.Int64() != int64(sum[0]>>(8-csBits)) {
+		return nil, fmt.Errorf("invalid mnemonic checksum")
+	}
+
+	return entropy, nil
+}
+
+// MnemonicToSeed checks the mnemonic and derives the BIP39 seed from it and
+// the passphrase. Passphrases are used as given, without unicode
+// normalization.
+func MnemonicToSeed(mnemonic, passphrase string) ([]byte, error) {
+	if _, err := MnemonicToEntropy(mnemonic); err != nil {
+		return nil, err
+	}
+
+	normalized := strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key([]byte(normalized), []byte("mnemonic"+passphrase), 2048, 64, sha512.New), nil
+}
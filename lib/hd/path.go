@@ -0,0 +1,64 @@
+package hd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Hardened is added to an index to derive a hardened child
+const Hardened uint32 = 0x80000000
+
+// FilecoinCoinType is the SLIP-44 coin type of Filecoin
+const FilecoinCoinType = 461
+
+// Secp256k1Path is the BIP44 path of the secp256k1 key with the given index,
+// m/44'/461'/0'/0/index
+func Secp256k1Path(index uint32) []uint32 {
+	return []uint32{44 + Hardened, FilecoinCoinType + Hardened, Hardened, 0, index}
+}
+
+// BLSPath is the EIP-2334 path of the BLS key with the given index,
+// m/12381/461/0/index
+func BLSPath(index uint32) []uint32 {
+	return []uint32{12381, FilecoinCoinType, 0, index}
+}
+
+// ParsePath parses a path like m/44'/461'/0'/0/0, hardened indexes are
+// marked with ' or h
+func ParsePath(s string) ([]uint32, error) {
+	parts := strings.Split(s, "/")
+	if parts[0] != "m" {
+		return nil, fmt.Errorf("path must start with m: %q", s)
+	}
+
+	out := make([]uint32, 0, len(parts)-1)
+	for _, p := range parts[1:] {
+		var off uint32
+		if strings.HasSuffix(p, "'") || strings.HasSuffix(p, "h") {
+			off = Hardened
+			p = p[:len(p)-1]
+		}
+
+		i, err := strconv.ParseUint(p, 10, 32)
+		if err != nil || uint32(i) >= Hardened {
+			return nil, fmt.Errorf("invalid path index %q in %q", p, s)
+		}
+		out = append(out, uint32(i)+off)
+	}
+	return out, nil
+}
+
+// FormatPath is the inverse of ParsePath
+func FormatPath(path []uint32) string {
+	var sb strings.Builder
+	sb.WriteString("m")
+	for _, i := range path {
+		if i >= Hardened {
+			fmt.Fprintf(&sb, "/%d'", i-Hardened)
+		} else {
+			fmt.Fprintf(&sb, "/%d", i)
+		}
+	}
+	return sb.String()
+}
@@ -0,0 +1,105 @@
+package hd
+
+import (
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+
+	secp256k1 "github.com/ipsn/go-secp256k1"
+
+	"github.com/zgfzgf/mid-lotus/lib/crypto"
+)
+
+// ExtendedKey is a BIP32 secp256k1 private key with its chain code
+type ExtendedKey struct {
+	Key       []byte
+	ChainCode []byte
+}
+
+// NewMasterKey derives the BIP32 master key from a seed
+func NewMasterKey(seed []byte) (*ExtendedKey, error) {
+	if len(seed) < 16 || len(seed) > 64 {
+		return nil, fmt.Errorf("invalid seed length: %d", len(seed))
+	}
+
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+
+	k := new(big.Int).SetBytes(sum[:32])
+	if k.Sign() == 0 || k.Cmp(secp256k1.S256().Params().N) >= 0 {
+		return nil, fmt.Errorf("seed gives an invalid master key")
+	}
+
+	return &ExtendedKey{
+		Key:       sum[:32],
+		ChainCode: sum[32:],
+	}, nil
+}
+
+// Child derives the child key at index i, indexes from Hardened up give
+// hardened keys
+func (k *ExtendedKey) Child(i uint32) (*ExtendedKey, error) {
+	var data []byte
+	if i >= Hardened {
+		data = append([]byte{0}, k.Key...)
+	} else {
+		data = compressedPublicKey(k.Key)
+	}
+	var ib [4]byte
+	binary.BigEndian.PutUint32(ib[:], i)
+	data = append(data, ib[:]...)
+
+	mac := hmac.New(sha512.New, k.ChainCode)
+	mac.Write(data)
+	sum := mac.Sum(nil)
+
+	n := secp256k1.S256().Params().N
+	il := new(big.Int).SetBytes(sum[:32])
+	if il.Cmp(n) >= 0 {
+		return nil, fmt.Errorf("child %d is invalid", i)
+	}
+
+	ck := il.Add(il, new(big.Int).SetBytes(k.Key))
+	ck.Mod(ck, n)
+	if ck.Sign() == 0 {
+		return nil, fmt.Errorf("child %d is invalid", i)
+	}
+
+	key := make([]byte, crypto.PrivateKeyBytes)
+	ckb := ck.Bytes()
+	copy(key[len(key)-len(ckb):], ckb)
+
+	return &ExtendedKey{
+		Key:       key,
+		ChainCode: sum[32:],
+	}, nil
+}
+
+// DeriveSecp256k1 derives the secp256k1 private key at path from a seed
+func DeriveSecp256k1(seed []byte, path []uint32) ([]byte, error) {
+	k, err := NewMasterKey(seed)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, i := range path {
+		k, err = k.Child(i)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return k.Key, nil
+}
+
+func compressedPublicKey(sk []byte) []byte {
+	// uncompressed keys are 0x04 || x || y
+	pub := crypto.PublicKey(sk)
+	out := make([]byte, 33)
+	out[0] = 2 + pub[64]&1
+	copy(out[1:], pub[1:33])
+	return out
+}
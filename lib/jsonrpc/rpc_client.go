@@ -6,6 +6,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"reflect"
@@ -157,6 +158,16 @@ func NewClient(addr string, namespace string, handler interface{}) ClientCloser
 
 			// process response
 
+			if valOut != -1 && ftyp.Out(valOut).Kind() == reflect.Chan {
+				ctx := context.Background()
+				if hasCtx == 1 {
+					ctx = args[0].Interface().(context.Context)
+				}
+				return processChan(ctx, httpResp, *req.ID, ftyp.Out(valOut), processError, func(resp clientResponse) []reflect.Value {
+					return processResponse(resp, httpResp.StatusCode)
+				})
+			}
+
 			if clientDebug {
 				rsp, err := ioutil.ReadAll(httpResp.Body)
 				if err != nil {
@@ -198,3 +209,58 @@ func NewClient(addr string, namespace string, handler interface{}) ClientCloser
 	// TODO: if this is still unused as of 2020, remove the closer stuff
 	return func() {} // noop for now, not for long though
 }
+
+// processChan reads a streamed response. The first response carries only the
+// error, if any, and each following one is a value sent on the returned
+// channel. The channel is closed when the stream ends or ctx is cancelled.
+func processChan(ctx context.Context, httpResp *http.Response, id int64, chtyp reflect.Type, processError func(error) []reflect.Value, processResponse func(clientResponse) []reflect.Value) []reflect.Value {
+	dec := json.NewDecoder(httpResp.Body)
+
+	var resp clientResponse
+	resp.Result = result(reflect.ValueOf(new(json.RawMessage)))
+	if err := dec.Decode(&resp); err != nil {
+		_ = httpResp.Body.Close()
+		return processError(err)
+	}
+
+	if resp.ID != id {
+		_ = httpResp.Body.Close()
+		return processError(errors.New("request and response id didn't match"))
+	}
+
+	if resp.Error != nil {
+		_ = httpResp.Body.Close()
+		resp.Result = result(reflect.New(chtyp))
+		return processResponse(resp)
+	}
+
+	ch := reflect.MakeChan(reflect.ChanOf(reflect.BothDir, chtyp.Elem()), 0)
+
+	go func() {
+		defer ch.Close()
+		defer httpResp.Body.Close() //nolint:errcheck
+
+		for {
+			var frame clientResponse
+			frame.Result = result(reflect.New(chtyp.Elem()))
+			if err := dec.Decode(&frame); err != nil {
+				if ctx.Err() == nil && err != io.EOF {
+					log.Warnw("reading rpc stream", "error", err)
+				}
+				return
+			}
+
+			chosen, _, _ := reflect.Select([]reflect.SelectCase{
+				{Dir: reflect.SelectSend, Chan: ch, Send: reflect.Value(frame.Result).Elem()},
+				{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(ctx.Done())},
+			})
+			if chosen == 1 {
+				return
+			}
+		}
+	}()
+
+	resp.Result = result(reflect.New(chtyp))
+	reflect.Value(resp.Result).Elem().Set(ch.Convert(chtyp))
+	return processResponse(resp)
+}
@@ -25,6 +25,7 @@ type rpcHandler struct {
 
 	errOut int
 	valOut int
+	chOut  bool
 }
 
 // RPCServer provides a jsonrpc 2.0 http server handler
@@ -135,7 +136,12 @@ func (s *RPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 			}
 		}
 	}
-	if handler.valOut != -1 {
+	if handler.chOut {
+		if resp.Error == nil {
+			s.streamChan(w, resp, callResult[handler.valOut])
+			return
+		}
+	} else if handler.valOut != -1 {
 		resp.Result = callResult[handler.valOut].Interface()
 	}
 
@@ -145,6 +151,39 @@ func (s *RPCServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// streamChan sends an empty response, followed by a response for every
+// value received from ch, until ch is closed. The handler returning ch is
+// expected to close it when the request context is cancelled.
+func (s *RPCServer) streamChan(w http.ResponseWriter, resp response, ch reflect.Value) {
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	for {
+		if err := enc.Encode(resp); err != nil {
+			log.Warnf("streaming rpc response: %s", err)
+
+			// keep the handler from blocking on a client which went away
+			go func() {
+				for {
+					if _, ok := ch.Recv(); !ok {
+						return
+					}
+				}
+			}()
+			return
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		v, ok := ch.Recv()
+		if !ok {
+			return
+		}
+		resp.Result = v.Interface()
+	}
+}
+
 func (s *RPCServer) rpcError(w http.ResponseWriter, req *request, code int, err error) {
 	w.WriteHeader(500)
 	if req.ID == nil { // notification
@@ -186,6 +225,7 @@ func (s *RPCServer) Register(namespace string, r interface{}) {
 		}
 
 		valOut, errOut, _ := processFuncOut(funcType)
+		chOut := valOut != -1 && funcType.Out(valOut).Kind() == reflect.Chan
 
 		fmt.Println(namespace + "." + method.Name)
 
@@ -200,6 +240,7 @@ func (s *RPCServer) Register(namespace string, r interface{}) {
 
 			errOut: errOut,
 			valOut: valOut,
+			chOut:  chOut,
 		}
 	}
 }
@@ -252,3 +252,87 @@ func TestCtx(t *testing.T) {
 	serverHandler.lk.Unlock()
 	closer()
 }
+
+type ChanHandler struct {
+	done chan struct{}
+}
+
+func (h *ChanHandler) Sub(ctx context.Context, n int) (<-chan int, error) {
+	if n < 0 {
+		return nil, errors.New("negative")
+	}
+
+	out := make(chan int)
+	go func() {
+		defer close(out)
+		for i := 0; n == 0 || i < n; i++ {
+			select {
+			case out <- i:
+			case <-ctx.Done():
+				close(h.done)
+				return
+			}
+		}
+	}()
+
+	return out, nil
+}
+
+func TestChan(t *testing.T) {
+	serverHandler := &ChanHandler{done: make(chan struct{})}
+
+	rpcServer := NewServer()
+	rpcServer.Register("ChanHandler", serverHandler)
+
+	testServ := httptest.NewServer(rpcServer)
+	defer testServ.Close()
+
+	var client struct {
+		Sub func(context.Context, int) (<-chan int, error)
+	}
+	closer := NewClient(testServ.URL, "ChanHandler", &client)
+	defer closer()
+
+	// finite stream
+
+	ch, err := client.Sub(context.Background(), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []int
+	for v := range ch {
+		got = append(got, v)
+	}
+	if len(got) != 3 || got[0] != 0 || got[2] != 2 {
+		t.Error("wrong values", got)
+	}
+
+	// error
+
+	_, err = client.Sub(context.Background(), -1)
+	if err == nil || err.Error() != "negative" {
+		t.Error("wrong error:", err)
+	}
+
+	// cancellation closes both ends
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch, err = client.Sub(ctx, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := <-ch; v != 0 {
+		t.Error("wrong value", v)
+	}
+	cancel()
+
+	for range ch {
+	}
+
+	select {
+	case <-serverHandler.done:
+	case <-time.After(time.Second):
+		t.Error("expected cancellation on the server side")
+	}
+}
@@ -0,0 +1,24 @@
+package metrics
+
+import (
+	"go.opencensus.io/stats"
+	"go.opencensus.io/stats/view"
+)
+
+// Measures
+var (
+	MinerOrphansAvoided = stats.Int64("miner/orphans_avoided", "Mining rounds abandoned because the head changed while mining", stats.UnitDimensionless)
+)
+
+// Views
+var (
+	MinerOrphansAvoidedView = &view.View{
+		Measure:     MinerOrphansAvoided,
+		Aggregation: view.Sum(),
+	}
+)
+
+// DefaultViews is the set of views registered by the node
+var DefaultViews = []*view.View{
+	MinerOrphansAvoidedView,
+}
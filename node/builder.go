@@ -64,6 +64,7 @@ const (
 
 	HandleIncomingBlocksKey
 	HandleIncomingMessagesKey
+	RepublishLocalMessagesKey
 
 	_nInvokes // keep this last
 )
@@ -167,8 +168,10 @@ func Online() Option {
 		// Filecoin services
 		Override(new(*chain.Syncer), chain.NewSyncer),
 		Override(new(*chain.BlockSync), chain.NewBlockSyncClient),
-		Override(new(*chain.Wallet), chain.NewWallet),
+		Override(new(chain.Keystore), modules.Keystore(defConf.Wallet)),
+		Override(new(*chain.Wallet), modules.Wallet(defConf.Wallet)),
 		Override(new(*chain.MessagePool), chain.NewMessagePool),
+		Override(new(*chain.Miner), modules.Miner(defConf.Mining)),
 
 		Override(new(modules.Genesis), testing.MakeGenesis),
 		Override(SetGenisisKey, modules.SetGenesis),
@@ -179,6 +182,7 @@ func Online() Option {
 		Override(RunBlockSyncKey, modules.RunBlockSync),
 		Override(HandleIncomingBlocksKey, modules.HandleIncomingBlocks),
 		Override(HandleIncomingMessagesKey, modules.HandleIncomingMessages),
+		Override(RepublishLocalMessagesKey, modules.RepublishLocalMessages),
 	)
 }
 
@@ -189,6 +193,10 @@ func Config(cfg *config.Root) Option {
 
 		applyIf(func(s *settings) bool { return s.online },
 			Override(StartListeningKey, lp2p.StartListening(cfg.Libp2p.ListenAddresses)),
+			Override(new(*chain.MessagePool), modules.MessagePool(cfg.Mpool)),
+			Override(new(*chain.Miner), modules.Miner(cfg.Mining)),
+			Override(new(chain.Keystore), modules.Keystore(cfg.Wallet)),
+			Override(new(*chain.Wallet), modules.Wallet(cfg.Wallet)),
 		),
 	)
 }
@@ -6,6 +6,9 @@ import "time"
 type Root struct {
 	API    API
 	Libp2p Libp2p
+	Mpool  Mpool
+	Mining Mining
+	Wallet Wallet
 }
 
 // API contains configs for API endpoint
@@ -19,6 +22,54 @@ type Libp2p struct {
 	ListenAddresses []string
 }
 
+// Mpool contains configs for the message pool, zero values keep the built-in
+// defaults
+type Mpool struct {
+	// BlockGasLimit and BlockSizeLimit bound the messages selected for the
+	// blocks we create
+	BlockGasLimit  uint64
+	BlockSizeLimit int
+
+	// ReplaceByFeePercent is the minimum gas price increase, in percent,
+	// for a message to replace a pending one with the same nonce
+	ReplaceByFeePercent uint64
+
+	// MaxPending and MaxPendingPerSender limit the number of pending
+	// messages in total and from a single sender
+	MaxPending          int
+	MaxPendingPerSender int
+}
+
+// Mining contains configs for the block miner
+type Mining struct {
+	// Enabled starts the miner with the node
+	Enabled bool
+
+	// Address is the miner address blocks are mined with
+	Address string
+}
+
+// Wallet contains configs for the wallet
+type Wallet struct {
+	// KeystorePath is the directory keys are stored in, encrypted with the
+	// passphrase from the LOTUS_KEYSTORE_PASSPHRASE environment variable,
+	// relative paths are relative to the node's working directory. The node
+	// refuses to start if it's set and the passphrase is empty. By default
+	// it's empty, and keys are only kept in memory and lost on restart.
+	KeystorePath string
+
+	// RemoteSigner is the JSON-RPC endpoint of a signer process holding
+	// keys, e.g. http://127.0.0.1:1235/rpc/v0. Keys not found in the
+	// keystore are listed and used through it. New keys can't be generated
+	// or imported on the node then, use 'lotus-signer new' and 'import'.
+	RemoteSigner string
+
+	// EnableExport allows private keys to be exported through the API. The
+	// API has no authentication, so anyone who can reach it could take the
+	// keys, it's off by default.
+	EnableExport bool
+}
+
 // Default returns the default config
 func Default() *Root {
 	def := Root{
@@ -0,0 +1,60 @@
+package modules
+
+import (
+	"context"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/pkg/errors"
+	"go.uber.org/fx"
+
+	"github.com/zgfzgf/mid-lotus/chain"
+	"github.com/zgfzgf/mid-lotus/chain/address"
+	"github.com/zgfzgf/mid-lotus/node/config"
+)
+
+// Miner constructs the block miner. It is started with the node when mining
+// is enabled in the config, and can otherwise be started through the API.
+func Miner(cfg config.Mining) func(lc fx.Lifecycle, cs *chain.ChainStore, w *chain.Wallet, mpool *chain.MessagePool, ps *pubsub.PubSub) (*chain.Miner, error) {
+	return func(lc fx.Lifecycle, cs *chain.ChainStore, w *chain.Wallet, mpool *chain.MessagePool, ps *pubsub.PubSub) (*chain.Miner, error) {
+		maddr := address.Undef
+		if cfg.Address != "" {
+			var err error
+			maddr, err = address.NewFromString(cfg.Address)
+			if err != nil {
+				return nil, errors.Wrap(err, "parsing miner address")
+			}
+		}
+
+		m := chain.NewMiner(cs, w, maddr, mpool, func(b *chain.FullBlock) {
+			if err := publishBlock(ps, b); err != nil {
+				log.Errorf("publishing block %s: %s", b.Cid(), err)
+			}
+		})
+
+		lc.Append(fx.Hook{
+			OnStart: func(context.Context) error {
+				if !cfg.Enabled {
+					return nil
+				}
+				return m.Start(address.Undef)
+			},
+			OnStop: func(ctx context.Context) error {
+				if !m.Status().Running {
+					return nil
+				}
+				return m.Stop(ctx)
+			},
+		})
+
+		return m, nil
+	}
+}
+
+func publishBlock(ps *pubsub.PubSub, b *chain.FullBlock) error {
+	data, err := b.ToBlockMsg().Serialize()
+	if err != nil {
+		return err
+	}
+
+	return ps.Publish("/fil/blocks", data)
+}
@@ -0,0 +1,72 @@
+package modules
+
+import (
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"go.uber.org/fx"
+
+	"github.com/zgfzgf/mid-lotus/chain"
+	"github.com/zgfzgf/mid-lotus/node/config"
+	"github.com/zgfzgf/mid-lotus/node/modules/helpers"
+)
+
+// MessagePool constructs the message pool with the limits from the config
+func MessagePool(cfg config.Mpool) func(cs *chain.ChainStore, w *chain.Wallet) *chain.MessagePool {
+	return func(cs *chain.ChainStore, w *chain.Wallet) *chain.MessagePool {
+		mp := chain.NewMessagePool(cs, w)
+		if cfg.BlockGasLimit != 0 {
+			mp.BlockGasLimit = chain.NewInt(cfg.BlockGasLimit)
+		}
+		if cfg.BlockSizeLimit != 0 {
+			mp.BlockSizeLimit = cfg.BlockSizeLimit
+		}
+		if cfg.ReplaceByFeePercent != 0 {
+			mp.ReplaceByFeePercent = cfg.ReplaceByFeePercent
+		}
+		if cfg.MaxPending != 0 {
+			mp.MaxPending = cfg.MaxPending
+		}
+		if cfg.MaxPendingPerSender != 0 {
+			mp.MaxPendingPerSender = cfg.MaxPendingPerSender
+		}
+		return mp
+	}
+}
+
+// RepublishLocalMessages reloads the persisted local messages into the pool
+// and periodically rebroadcasts the ones which are still pending
+func RepublishLocalMessages(mctx helpers.MetricsCtx, lc fx.Lifecycle, ps *pubsub.PubSub, mpool *chain.MessagePool) error {
+	if err := mpool.LoadLocal(); err != nil {
+		return err
+	}
+
+	ctx := helpers.LifecycleCtx(mctx, lc)
+
+	go func() {
+		tick := time.NewTicker(chain.RepublishInterval)
+		defer tick.Stop()
+
+		for {
+			select {
+			case <-tick.C:
+			case <-ctx.Done():
+				return
+			}
+
+			for _, m := range mpool.LocalPending() {
+				data, err := m.Serialize()
+				if err != nil {
+					log.Errorf("serializing local message %s: %s", m.Cid(), err)
+					continue
+				}
+
+				if err := ps.Publish("/fil/messages", data); err != nil {
+					log.Warnf("republishing message %s: %s", m.Cid(), err)
+				}
+			}
+		}
+	}()
+
+	return nil
+}
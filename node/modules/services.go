@@ -32,9 +32,13 @@ func RunBlockSync(h host.Host, svc *chain.BlockSyncService) {
 	h.SetStreamHandler(chain.BlockSyncProtocolID, svc.HandleStream)
 }
 
-func HandleIncomingBlocks(mctx helpers.MetricsCtx, lc fx.Lifecycle, pubsub *pubsub.PubSub, s *chain.Syncer) {
+func HandleIncomingBlocks(mctx helpers.MetricsCtx, lc fx.Lifecycle, pubsub *pubsub.PubSub, s *chain.Syncer, cs *chain.ChainStore) {
 	ctx := helpers.LifecycleCtx(mctx, lc)
 
+	if err := pubsub.RegisterTopicValidator("/fil/blocks", sub.BlockValidator(cs)); err != nil {
+		panic(err)
+	}
+
 	blocksub, err := pubsub.Subscribe("/fil/blocks")
 	if err != nil {
 		panic(err)
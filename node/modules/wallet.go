@@ -0,0 +1,65 @@
+package modules
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/pkg/errors"
+
+	"github.com/zgfzgf/mid-lotus/api/client"
+	"github.com/zgfzgf/mid-lotus/chain"
+	"github.com/zgfzgf/mid-lotus/node/config"
+)
+
+// KeystorePassphraseEnv is the environment variable holding the passphrase of
+// the on-disk keystore
+const KeystorePassphraseEnv = "LOTUS_KEYSTORE_PASSPHRASE"
+
+// Keystore opens the keystore configured for the wallet. Keys are only kept in
+// memory if no path is set, which is the default.
+func Keystore(cfg config.Wallet) func() (chain.Keystore, error) {
+	return func() (chain.Keystore, error) {
+		if cfg.KeystorePath == "" {
+			return chain.NewMemKeystore(), nil
+		}
+
+		passphrase, err := KeystorePassphrase()
+		if err != nil {
+			return nil, errors.Wrapf(err, "opening keystore %s (set %s, or remove Wallet.KeystorePath from the config to only keep keys in memory)", cfg.KeystorePath, KeystorePassphraseEnv)
+		}
+
+		return chain.OpenFSKeystore(cfg.KeystorePath, passphrase)
+	}
+}
+
+// KeystorePassphrase returns the keystore passphrase from the environment,
+// an empty passphrase is refused so keys aren't stored with a guessable key
+func KeystorePassphrase() (string, error) {
+	passphrase := os.Getenv(KeystorePassphraseEnv)
+	if passphrase == "" {
+		return "", fmt.Errorf("%s is not set, refusing to use an empty keystore passphrase", KeystorePassphraseEnv)
+	}
+	return passphrase, nil
+}
+
+// Wallet creates the wallet, backed by the remote signer if one is configured.
+// Keys can only be exported if the config allows it.
+func Wallet(cfg config.Wallet) func(ks chain.Keystore) (*chain.Wallet, error) {
+	return func(ks chain.Keystore) (*chain.Wallet, error) {
+		var w *chain.Wallet
+		var err error
+		if cfg.RemoteSigner == "" {
+			w, err = chain.NewWallet(ks)
+		} else {
+			w, err = chain.NewRemoteWallet(ks, client.NewSignerRPC(cfg.RemoteSigner))
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if !cfg.EnableExport {
+			w.DisableExport()
+		}
+		return w, nil
+	}
+}
@@ -0,0 +1,94 @@
+package modules
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/zgfzgf/mid-lotus/chain"
+	"github.com/zgfzgf/mid-lotus/node/config"
+)
+
+// withPassphrase sets the keystore passphrase for the duration of the test
+func withPassphrase(t *testing.T, passphrase string) {
+	t.Helper()
+
+	prev, had := os.LookupEnv(KeystorePassphraseEnv)
+	if err := os.Setenv(KeystorePassphraseEnv, passphrase); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if had {
+			os.Setenv(KeystorePassphraseEnv, prev) //nolint:errcheck
+		} else {
+			os.Unsetenv(KeystorePassphraseEnv) //nolint:errcheck
+		}
+	})
+}
+
+func TestDefaultWalletStartsWithoutPassphrase(t *testing.T) {
+	withPassphrase(t, "")
+
+	cfg := config.Default().Wallet
+	ks, err := Keystore(cfg)()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := ks.(*chain.MemKeystore); !ok {
+		t.Fatalf("default keystore is a %T, expected an in-memory one", ks)
+	}
+
+	w, err := Wallet(cfg)(ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.GenerateKey(chain.KTSecp256k1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestKeystorePathRequiresPassphrase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "keystore")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir) //nolint:errcheck
+
+	cfg := config.Wallet{KeystorePath: filepath.Join(dir, "keystore")}
+
+	withPassphrase(t, "")
+	if _, err := Keystore(cfg)(); err == nil || !strings.Contains(err.Error(), KeystorePassphraseEnv) {
+		t.Fatalf("expected an error naming %s, got %v", KeystorePassphraseEnv, err)
+	}
+
+	withPassphrase(t, "correct horse")
+	if _, err := Keystore(cfg)(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWalletExportNeedsConfig(t *testing.T) {
+	for _, enabled := range []bool{false, true} {
+		cfg := config.Default().Wallet
+		cfg.EnableExport = enabled
+
+		w, err := Wallet(cfg)(chain.NewMemKeystore())
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr, err := w.GenerateKey(chain.KTSecp256k1)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		_, err = w.Export(addr)
+		if enabled && err != nil {
+			t.Fatalf("export enabled: %s", err)
+		}
+		if !enabled && err != chain.ErrExportDisabled {
+			t.Fatalf("export disabled: expected ErrExportDisabled, got %v", err)
+		}
+	}
+}
@@ -2,17 +2,246 @@ package node
 
 import (
 	"context"
+	"sync"
 
 	"github.com/zgfzgf/mid-lotus/api"
 	"github.com/zgfzgf/mid-lotus/build"
+	"github.com/zgfzgf/mid-lotus/chain"
+	"github.com/zgfzgf/mid-lotus/chain/address"
+	"github.com/zgfzgf/mid-lotus/chain/vectors"
 
+	"github.com/ipfs/go-cid"
+	logging "github.com/ipfs/go-log"
 	"github.com/libp2p/go-libp2p-core/host"
 	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
 	ma "github.com/multiformats/go-multiaddr"
+	"github.com/pkg/errors"
 )
 
+var log = logging.Logger("node")
+
 type API struct {
-	Host host.Host
+	Host   host.Host
+	PubSub *pubsub.PubSub
+	Chain  *chain.ChainStore
+	Mpool  *chain.MessagePool
+	Wallet *chain.Wallet
+	Miner  *chain.Miner
+	Syncer *chain.Syncer
+
+	// pushLk serializes MpoolPushMessage so concurrent calls don't pick the
+	// same nonce
+	pushLk sync.Mutex
+}
+
+func (a *API) ChainHead(context.Context) (*chain.TipSet, error) {
+	return a.Chain.GetHeaviestTipSet(), nil
+}
+
+func (a *API) ChainGetTipSet(ctx context.Context, cids []cid.Cid) (*chain.TipSet, error) {
+	return a.Chain.LoadTipSet(cids)
+}
+
+func (a *API) StateReplay(ctx context.Context, ts *chain.TipSet, mc cid.Cid) (*api.ReplayResults, error) {
+	m, rct, trace, err := a.Chain.ReplayMessage(ts, mc)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.ReplayResults{
+		Msg:     m,
+		Receipt: rct,
+		Trace:   trace,
+	}, nil
+}
+
+func (a *API) StateCall(ctx context.Context, msg *chain.Message, ts *chain.TipSet, opts *chain.CallOptions) (*api.MethodCall, error) {
+	rct, trace, err := a.Chain.Call(msg, ts, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &api.MethodCall{
+		Receipt: rct,
+		Trace:   trace,
+	}, nil
+}
+
+func (a *API) StateRecordVectors(ctx context.Context, ts *chain.TipSet) ([]*vectors.TestVector, error) {
+	if ts == nil {
+		ts = a.Chain.GetHeaviestTipSet()
+	}
+	return vectors.RecordTipSet(ctx, a.Chain, ts)
+}
+
+func (a *API) GasEstimateGasLimit(ctx context.Context, msg *chain.Message, ts *chain.TipSet) (chain.BigInt, error) {
+	return a.Chain.EstimateGasLimit(msg, ts)
+}
+
+func (a *API) MpoolPending(ctx context.Context) ([]*chain.SignedMessage, error) {
+	return a.Mpool.Pending(), nil
+}
+
+func (a *API) MpoolPush(ctx context.Context, smsg *chain.SignedMessage) error {
+	if err := a.Mpool.AddLocal(smsg); err != nil {
+		return err
+	}
+
+	data, err := smsg.Serialize()
+	if err != nil {
+		return err
+	}
+
+	return a.PubSub.Publish("/fil/messages", data)
+}
+
+func (a *API) MpoolPushMessage(ctx context.Context, msg *chain.Message) (*chain.SignedMessage, error) {
+	a.pushLk.Lock()
+	defer a.pushLk.Unlock()
+
+	nonce, err := a.Mpool.GetNonce(msg.From)
+	if err != nil {
+		return nil, errors.Wrap(err, "getting nonce")
+	}
+	msg.Nonce = nonce
+
+	data, err := msg.Serialize()
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := a.Wallet.Sign(msg.From, data)
+	if err != nil {
+		return nil, errors.Wrap(err, "signing message")
+	}
+
+	smsg := &chain.SignedMessage{
+		Message:   *msg,
+		Signature: *sig,
+	}
+
+	if err := a.MpoolPush(ctx, smsg); err != nil {
+		return nil, err
+	}
+	return smsg, nil
+}
+
+func (a *API) MpoolGetNonce(ctx context.Context, addr address.Address) (uint64, error) {
+	return a.Mpool.GetNonce(addr)
+}
+
+func (a *API) MpoolSub(ctx context.Context) (<-chan chain.MpoolUpdate, error) {
+	return a.Mpool.Updates(ctx), nil
+}
+
+func (a *API) MpoolSelect(ctx context.Context, ts *chain.TipSet) ([]*chain.SignedMessage, error) {
+	if ts == nil {
+		ts = a.Chain.GetHeaviestTipSet()
+	}
+	return a.Mpool.SelectMessages(ts)
+}
+
+func (a *API) MinerStart(ctx context.Context, addr address.Address) error {
+	return a.Miner.Start(addr)
+}
+
+func (a *API) MinerStop(ctx context.Context) error {
+	return a.Miner.Stop(ctx)
+}
+
+func (a *API) MinerStatus(ctx context.Context) (api.MinerStatus, error) {
+	st := a.Miner.Status()
+	return api.MinerStatus{
+		Running:        st.Running,
+		Address:        st.Address,
+		BlocksMined:    st.BlocksMined,
+		OrphansAvoided: st.OrphansAvoided,
+	}, nil
+}
+
+func (a *API) MinerCreateBlock(ctx context.Context, base *chain.TipSet, miner address.Address, tickets []chain.Ticket, proof chain.ElectionProof) (*chain.BlockMsg, error) {
+	clock, err := a.Chain.Clock()
+	if err != nil {
+		return nil, err
+	}
+
+	height := base.Height() + uint64(len(tickets))
+	fb, err := chain.MinerCreateBlock(a.Chain, a.Mpool, miner, base, tickets, proof, clock.EpochStart(height))
+	if err != nil {
+		return nil, err
+	}
+
+	return fb.ToBlockMsg(), nil
+}
+
+func (a *API) SyncSubmitBlock(ctx context.Context, blk *chain.BlockMsg) error {
+	if err := a.Syncer.SubmitBlock(blk); err != nil {
+		return err
+	}
+
+	data, err := blk.Serialize()
+	if err != nil {
+		return err
+	}
+
+	return a.PubSub.Publish("/fil/blocks", data)
+}
+
+func (a *API) WalletNew(ctx context.Context, typ string) (address.Address, error) {
+	return a.Wallet.GenerateKey(typ)
+}
+
+func (a *API) WalletList(ctx context.Context) ([]address.Address, error) {
+	addrs, err := a.Wallet.ListAddrs()
+	if err != nil {
+		// the local keys are still usable
+		log.Warnf("listing wallet addresses: %s", err)
+	}
+	return addrs, nil
+}
+
+func (a *API) WalletBalance(ctx context.Context, addr address.Address) (chain.BigInt, error) {
+	return a.Chain.GetBalance(addr)
+}
+
+func (a *API) WalletDefaultAddress(ctx context.Context) (address.Address, error) {
+	return a.Wallet.GetDefault()
+}
+
+func (a *API) WalletSetDefault(ctx context.Context, addr address.Address) error {
+	return a.Wallet.SetDefault(addr)
+}
+
+func (a *API) WalletSign(ctx context.Context, addr address.Address, data []byte) (*chain.Signature, error) {
+	return a.Wallet.Sign(addr, data)
+}
+
+func (a *API) WalletVerify(ctx context.Context, addr address.Address, data []byte, sig *chain.Signature) (bool, error) {
+	if sig == nil {
+		return false, errors.New("no signature to verify")
+	}
+	return sig.Verify(addr, data) == nil, nil
+}
+
+func (a *API) WalletExport(ctx context.Context, addr address.Address) (*chain.KeyInfo, error) {
+	return a.Wallet.Export(addr)
+}
+
+func (a *API) WalletImport(ctx context.Context, ki *chain.KeyInfo) (address.Address, error) {
+	return a.Wallet.Import(ki)
+}
+
+func (a *API) WalletHDInit(ctx context.Context, mnemonic string, passphrase string) error {
+	return a.Wallet.HDInit(mnemonic, passphrase)
+}
+
+func (a *API) WalletHDNew(ctx context.Context, typ string) (address.Address, error) {
+	return a.Wallet.GenerateHDKey(typ)
+}
+
+func (a *API) WalletHDScan(ctx context.Context, typ string, gap int) ([]address.Address, error) {
+	return a.Wallet.HDScan(typ, gap, a.Chain.ActorExists)
 }
 
 func (a *API) ID(context.Context) (peer.ID, error) {
@@ -0,0 +1,81 @@
+package signer
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	logging "github.com/ipfs/go-log"
+
+	"github.com/zgfzgf/mid-lotus/chain"
+	"github.com/zgfzgf/mid-lotus/chain/address"
+	"github.com/zgfzgf/mid-lotus/lib/jsonrpc"
+)
+
+var log = logging.Logger("signer")
+
+// Signer serves the api.Signer API for the keys of a wallet. Only keys on
+// its allowlist are listed and used, other keys in the wallet are invisible
+// to clients.
+type Signer struct {
+	wallet  *chain.Wallet
+	allowed map[address.Address]struct{}
+}
+
+// New creates a signer for the allowed keys of the wallet, all of which must
+// be in the wallet
+func New(w *chain.Wallet, allow []address.Address) (*Signer, error) {
+	s := &Signer{
+		wallet:  w,
+		allowed: make(map[address.Address]struct{}, len(allow)),
+	}
+
+	for _, addr := range allow {
+		if !w.HasKey(addr) {
+			return nil, fmt.Errorf("allowed address %s has no key in the wallet", addr)
+		}
+		s.allowed[addr] = struct{}{}
+	}
+
+	return s, nil
+}
+
+// List returns the allowed addresses
+func (s *Signer) List(ctx context.Context) ([]address.Address, error) {
+	out := make([]address.Address, 0, len(s.allowed))
+	for addr := range s.allowed {
+		out = append(out, addr)
+	}
+	sort.Slice(out, func(i, j int) bool {
+		return out[i].String() < out[j].String()
+	})
+	return out, nil
+}
+
+// Sign signs data with the key of addr, if it's allowed
+func (s *Signer) Sign(ctx context.Context, addr address.Address, data []byte) (*chain.Signature, error) {
+	if _, ok := s.allowed[addr]; !ok {
+		log.Warnf("refusing to sign with %s, it is not on the allowlist", addr)
+		return nil, fmt.Errorf("address %s is not on the allowlist", addr)
+	}
+
+	log.Infof("signing %d bytes with %s", len(data), addr)
+	return s.wallet.Sign(addr, data)
+}
+
+// Handler returns a handler serving the signer API at /rpc/v0
+func Handler(s *Signer) http.Handler {
+	rpcServer := jsonrpc.NewServer()
+	rpcServer.Register("Signer", s)
+
+	mux := http.NewServeMux()
+	mux.Handle("/rpc/v0", rpcServer)
+	return mux
+}
+
+// Serve serves the signer API on addr at /rpc/v0. The API isn't
+// authenticated, so addr should only be reachable from the node's host.
+func Serve(s *Signer, addr string) error {
+	return http.ListenAndServe(addr, Handler(s))
+}
@@ -0,0 +1,133 @@
+package signer
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/zgfzgf/mid-lotus/api/client"
+	"github.com/zgfzgf/mid-lotus/chain"
+	"github.com/zgfzgf/mid-lotus/chain/address"
+)
+
+func newTestWallet(t *testing.T, n int) (*chain.Wallet, []address.Address) {
+	t.Helper()
+
+	w, err := chain.NewWallet(chain.NewMemKeystore())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addrs := make([]address.Address, n)
+	for i := range addrs {
+		addrs[i], err = w.GenerateKey(chain.KTSecp256k1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w, addrs
+}
+
+func TestAllowlist(t *testing.T) {
+	ctx := context.Background()
+	w, addrs := newTestWallet(t, 2)
+	allowed, other := addrs[0], addrs[1]
+
+	_, missing := newTestWallet(t, 1)
+	if _, err := New(w, []address.Address{missing[0]}); err == nil {
+		t.Fatal("allowed an address without a key")
+	}
+
+	s, err := New(w, []address.Address{allowed})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := s.List(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0] != allowed {
+		t.Fatalf("listed %v, expected only %s", list, allowed)
+	}
+
+	sig, err := s.Sign(ctx, allowed, []byte("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sig.Verify(allowed, []byte("data")); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.Sign(ctx, other, []byte("data")); err == nil {
+		t.Fatal("signed with a key which isn't allowed")
+	}
+}
+
+func TestWalletRemoteFallback(t *testing.T) {
+	rw, raddrs := newTestWallet(t, 2)
+	allowed, other := raddrs[0], raddrs[1]
+
+	s, err := New(rw, []address.Address{allowed})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	srv := httptest.NewServer(Handler(s))
+	defer srv.Close()
+
+	// a key already in the node's keystore, from before it used the signer
+	ks := chain.NewMemKeystore()
+	lw, err := chain.NewWallet(ks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	local, err := lw.GenerateKey(chain.KTSecp256k1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := chain.NewRemoteWallet(ks, client.NewSignerRPC(srv.URL+"/rpc/v0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// local keys are used directly, others through the signer
+	for _, addr := range []address.Address{local, allowed} {
+		sig, err := w.Sign(addr, []byte("data"))
+		if err != nil {
+			t.Fatalf("signing with %s: %s", addr, err)
+		}
+		if err := sig.Verify(addr, []byte("data")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.Sign(other, []byte("data")); err == nil {
+		t.Fatal("signed with a remote key which isn't allowed")
+	}
+
+	list, err := w.ListAddrs()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || !w.HasKey(local) || !w.HasKey(allowed) || w.HasKey(other) {
+		t.Fatalf("unexpected addresses %v", list)
+	}
+
+	if err := w.SetDefault(allowed); err != nil {
+		t.Fatal(err)
+	}
+	if def, err := w.GetDefault(); err != nil || def != allowed {
+		t.Fatalf("default is %s (%v), expected %s", def, err, allowed)
+	}
+
+	// local keys are still listed when the signer is gone
+	srv.Close()
+	list, err = w.ListAddrs()
+	if err == nil {
+		t.Fatal("expected an error listing remote keys")
+	}
+	if len(list) != 1 || list[0] != local {
+		t.Fatalf("listed %v, expected only %s", list, local)
+	}
+}